@@ -0,0 +1,197 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrNotAKit       = errors.New("Product has no components to assemble")
+	ErrAssemblyUsed  = errors.New("Assembled stock was already used")
+	ErrAssemblyStock = errors.New("Not enough stock of the components")
+)
+
+func RegisterAssemblyEndpoints(router *gin.Engine) {
+	group := router.Group("/assemblies")
+
+	group.POST("", createAssembly)
+	group.GET("", listAssemblies)
+	group.GET("/:id", viewAssembly)
+	group.DELETE("/:id", deleteAssembly)
+}
+
+func createAssembly(context *gin.Context) {
+	var assembly *models.Assembly
+	if err := context.ShouldBindJSON(&assembly); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+	assembly.CompanyID = companyID
+
+	var kit *models.Product
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Components")
+	if tx.First(&kit, assembly.ProductID).Error != nil || !kit.IsKit() {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"ProductID": ErrNotAKit.Error(),
+		})
+		return
+	}
+
+	// Kits can't be assembled out of missing components, whatever the
+	// negative stock policy of the company
+	requests := []stockRequest{}
+	for _, component := range kit.Components {
+		requests = append(requests, stockRequest{
+			Field:     "Qty",
+			ProductID: component.ComponentID,
+			Qty:       models.RoundQty(assembly.Qty * component.Qty),
+		})
+	}
+
+	if len(checkStock(db, companyID, "assemblies", 0, requests)) > 0 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Qty": ErrAssemblyStock.Error(),
+		})
+		return
+	}
+
+	cost := 0.0
+	transactions := []*models.Transaction{}
+
+	loader := newStockLoader(db)
+	for _, component := range kit.Components {
+		for _, draw := range loader.consume(component.ComponentID, models.RoundQty(assembly.Qty*component.Qty)) {
+			assembly.StockUsages = append(assembly.StockUsages, draw.Usages...)
+			cost += draw.Cost
+
+			transactions = append(transactions, &models.Transaction{
+				Value:     -draw.Cost,
+				AccountID: draw.Product.InventoryAccountID,
+			})
+		}
+	}
+
+	assembly.StockEntry = &models.StockEntry{
+		Qty:       assembly.Qty,
+		Price:     cost / assembly.Qty,
+		ProductID: kit.ID,
+	}
+
+	assembly.Entry = &models.Entry{
+		Description: "Assembly of kit",
+		CompanyID:   companyID,
+		Transactions: append(transactions, &models.Transaction{
+			Value:     cost,
+			AccountID: kit.InventoryAccountID,
+		}),
+	}
+
+	if db.Create(&assembly).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("Product").Joins("StockEntry").Preload("Entry.Transactions.Account").First(&assembly)
+	context.JSON(http.StatusOK, assembly)
+}
+
+func listAssemblies(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var assemblies []*models.Assembly
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Joins("Product").Joins("StockEntry")
+	if tx.Find(&assemblies).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, assemblies)
+}
+
+func viewAssembly(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var assembly *models.Assembly
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID))
+	tx = tx.Joins("Product").Joins("StockEntry").Preload("Entry.Transactions.Account")
+
+	if tx.First(&assembly, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, assembly)
+}
+
+func deleteAssembly(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var assembly *models.Assembly
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("StockEntry.StockUsages")
+	if tx.First(&assembly, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	// Taking the kits apart is only possible while they're all in stock
+	if assembly.StockEntry != nil && len(assembly.StockEntry.StockUsages) > 0 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"error": ErrAssemblyUsed.Error(),
+		})
+		return
+	}
+
+	if db.Unscoped().Select("StockUsages", "Entry").Delete(&assembly).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if assembly.StockEntry != nil {
+		db.Unscoped().Delete(assembly.StockEntry)
+	}
+
+	context.Status(http.StatusNoContent)
+}
@@ -0,0 +1,249 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+)
+
+func TestAssembly(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Component{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.StockReservation{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+	db.AutoMigrate(&models.Sale{})
+	db.AutoMigrate(&models.Item{})
+	db.AutoMigrate(&models.Assembly{})
+
+	t.Cleanup(database.Cleanup)
+
+	db.Create(&models.Company{Name: "Testing Company"})
+
+	cash := &models.Account{Name: "Cash", Type: models.Asset, CompanyID: 1}
+	db.Create(cash)
+
+	components := &models.Account{Name: "Components", Type: models.Asset, CompanyID: 1}
+	db.Create(components)
+
+	kits := &models.Account{Name: "Kits", Type: models.Asset, CompanyID: 1}
+	db.Create(kits)
+
+	revenue := &models.Account{Name: "Revenue", Type: models.Revenue, CompanyID: 1}
+	db.Create(revenue)
+
+	cogs := &models.Account{Name: "Cost of Goods Sold", Type: models.Expense, CompanyID: 1}
+	db.Create(cogs)
+
+	db.Create(&models.Customer{Name: "Customer", CompanyID: 1})
+
+	// ID: 1
+	db.Create(&models.Product{
+		Name:               "Chocolate",
+		Price:              10,
+		InventoryAccountID: components.ID,
+		CompanyID:          1,
+		StockEntries:       []*models.StockEntry{{Qty: 20, Price: 4}},
+	})
+
+	// ID: 2
+	db.Create(&models.Product{
+		Name:               "Wine",
+		Price:              50,
+		InventoryAccountID: components.ID,
+		CompanyID:          1,
+		StockEntries:       []*models.StockEntry{{Qty: 10, Price: 30}},
+	})
+
+	// ID: 3
+	db.Create(&models.Product{
+		Name:                "Gift basket",
+		Price:               100,
+		Purchasable:         true,
+		RevenueAccountID:    &revenue.ID,
+		CostOfSaleAccountID: &cogs.ID,
+		InventoryAccountID:  kits.ID,
+		CompanyID:           1,
+		Components: []*models.Component{
+			{Qty: 2, ComponentID: 1},
+			{Qty: 1, ComponentID: 2},
+		},
+	})
+
+	inventory := func(productID uint) float64 {
+		var product *models.Product
+		db.Preload("StockEntries.StockUsages").First(&product, productID)
+		return product.Inventory()
+	}
+
+	balance := func(accountID uint) float64 {
+		var account *models.Account
+		db.Preload("Transactions").First(&account, accountID)
+		return account.Balance()
+	}
+
+	router := api.GetRouter()
+
+	t.Run("Create", func(t *testing.T) {
+		req := Post(t, "/assemblies", map[string]interface{}{
+			"ProductID": 3,
+			"Qty":       5,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var assembly *models.Assembly
+		if err := json.Unmarshal(w.Body.Bytes(), &assembly); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if assembly.StockEntry == nil || assembly.StockEntry.Price != 38 {
+			t.Errorf("Expected kits to cost %v, got %v", 38, assembly.StockEntry)
+		}
+
+		if inventory(1) != 10 || inventory(2) != 5 || inventory(3) != 5 {
+			t.Errorf("Expected stock 10, 5 and 5, got %v, %v and %v", inventory(1), inventory(2), inventory(3))
+		}
+
+		// Value moves from the components to the kits
+		if balance(components.ID) != -5*38 {
+			t.Errorf("Expected balance %v, got %v", -5*38, balance(components.ID))
+		}
+
+		if balance(kits.ID) != 5*38 {
+			t.Errorf("Expected balance %v, got %v", 5*38, balance(kits.ID))
+		}
+	})
+
+	t.Run("Create without enough components", func(t *testing.T) {
+		req := Post(t, "/assemblies", map[string]interface{}{
+			"ProductID": 3,
+			"Qty":       6,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if response["Qty"] != api.ErrAssemblyStock.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrAssemblyStock.Error(), response["Qty"])
+		}
+	})
+
+	t.Run("Create for a product without components", func(t *testing.T) {
+		req := Post(t, "/assemblies", map[string]interface{}{
+			"ProductID": 1,
+			"Qty":       1,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Sell kits beyond the assembled ones", func(t *testing.T) {
+		receivableID := cash.ID
+		sale := &models.Sale{
+			CustomerID:          1,
+			CompanyID:           1,
+			ReceivableAccountID: &receivableID,
+			Items: []*models.Item{
+				{Qty: 7, Price: 100, ProductID: 3},
+			},
+		}
+		db.Create(sale)
+
+		api.ReduceProductStock(sale)
+		api.CreateAccountingEntries(sale)
+
+		// 5 assembled kits are sold, the other 2 come from the components
+		if inventory(1) != 6 || inventory(2) != 3 || inventory(3) != 0 {
+			t.Errorf("Expected stock 6, 3 and 0, got %v, %v and %v", inventory(1), inventory(2), inventory(3))
+		}
+
+		if balance(cogs.ID) != 7*38 {
+			t.Errorf("Expected balance %v, got %v", 7*38, balance(cogs.ID))
+		}
+
+		if balance(kits.ID) != 0 {
+			t.Errorf("Expected balance %v, got %v", 0, balance(kits.ID))
+		}
+
+		if balance(components.ID) != -7*38 {
+			t.Errorf("Expected balance %v, got %v", -7*38, balance(components.ID))
+		}
+	})
+
+	t.Run("Delete used", func(t *testing.T) {
+		req := Delete(t, "/assemblies/1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := Post(t, "/assemblies", map[string]interface{}{
+			"ProductID": 3,
+			"Qty":       1,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		req = Delete(t, "/assemblies/2")
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected status %v, got %v", http.StatusNoContent, w.Code)
+		}
+
+		if inventory(1) != 6 || inventory(2) != 3 || inventory(3) != 0 {
+			t.Errorf("Expected stock 6, 3 and 0, got %v, %v and %v", inventory(1), inventory(2), inventory(3))
+		}
+
+		if balance(kits.ID) != 0 {
+			t.Errorf("Expected balance %v, got %v", 0, balance(kits.ID))
+		}
+	})
+}
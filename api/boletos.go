@@ -0,0 +1,575 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"example.com/accounting/boleto"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrBoletoExists          = errors.New("Document already has a boleto")
+	ErrAlreadyPaid           = errors.New("Document is already paid")
+	ErrPayerRequired         = errors.New("Customer is required to issue boleto")
+	ErrBankAgreementNotFound = errors.New("Bank agreement not found")
+	ErrReturnFileMissing     = errors.New("Return file is required")
+)
+
+func RegisterBoletoEndpoints(router *gin.Engine) {
+	agreements := router.Group("/bank-agreements")
+
+	agreements.POST("", createBankAgreement)
+	agreements.GET("", listBankAgreements)
+	agreements.GET("/:id", viewBankAgreement)
+	agreements.PUT("/:id", updateBankAgreement)
+	agreements.DELETE("/:id", deleteBankAgreement)
+
+	router.POST("/sales/:id/boleto", issueSaleBoleto)
+	router.POST("/services/performed/:id/boleto", issueServiceBoleto)
+
+	boletos := router.Group("/boletos")
+
+	boletos.GET("/:id", viewBoleto)
+	boletos.GET("/:id/pdf", viewBoletoPDF)
+	boletos.POST("/returns", importBoletoReturn)
+}
+
+type boletoIssuing struct {
+	BankAgreementID uint      `binding:"required"`
+	DueDate         time.Time `binding:"required"`
+}
+
+// boletoReturn is an occurrence of the return file and whether it matched a
+// boleto of the company.
+type boletoReturn struct {
+	*boleto.Payment
+	Matched  bool
+	BoletoID uint `json:",omitempty"`
+}
+
+func createBankAgreement(context *gin.Context) {
+	var agreement *models.BankAgreement
+	if err := context.ShouldBindJSON(&agreement); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	if _, err := boleto.OurNumber(&boleto.Agreement{Bank: agreement.Bank}, 0); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Bank": err.Error(),
+		})
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	agreement.CompanyID = context.Value("CompanyID").(uint)
+
+	if db.Create(&agreement).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("BankAccount").Joins("InterestAccount").First(&agreement)
+	context.JSON(http.StatusOK, agreement)
+}
+
+func listBankAgreements(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var agreements []*models.BankAgreement
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Joins("BankAccount").Joins("InterestAccount")
+	if tx.Find(&agreements).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, agreements)
+}
+
+func viewBankAgreement(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var agreement *models.BankAgreement
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Joins("BankAccount").Joins("InterestAccount")
+	if tx.First(&agreement, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, agreement)
+}
+
+func updateBankAgreement(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var agreement *models.BankAgreement
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&agreement, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if err := context.ShouldBindJSON(&agreement); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	if _, err := boleto.OurNumber(&boleto.Agreement{Bank: agreement.Bank}, 0); err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Bank": err.Error(),
+		})
+		return
+	}
+
+	// Boletos already issued keep the data they were registered with
+	if db.Save(&agreement).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("BankAccount").Joins("InterestAccount").First(&agreement)
+	context.JSON(http.StatusOK, agreement)
+}
+
+func deleteBankAgreement(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&models.BankAgreement{}, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if db.Delete(&models.BankAgreement{}, id).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
+
+func issueSaleBoleto(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var sale *models.Sale
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Preload("Items").First(&sale, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if sale.Paid {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Paid": ErrAlreadyPaid.Error(),
+		})
+		return
+	}
+
+	issueBoleto(context, "sales", sale.ID, models.RoundMoney(sale.Total()))
+}
+
+func issueServiceBoleto(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var performed *models.ServicePerformed
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Preload("Withholdings").First(&performed, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if performed.Paid {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Paid": ErrAlreadyPaid.Error(),
+		})
+		return
+	}
+
+	if performed.CustomerID == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"CustomerID": ErrPayerRequired.Error(),
+		})
+		return
+	}
+
+	issueBoleto(context, "service_performeds", performed.ID, models.RoundMoney(performed.Receivable()))
+}
+
+// issueBoleto registers the boleto of the document under the agreement,
+// numbered from the agreement's sequence, for the value receivable.
+func issueBoleto(context *gin.Context, sourceType string, sourceID uint, value float64) {
+	var issuing *boletoIssuing
+	if err := context.ShouldBindJSON(&issuing); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Where("source_type = ? AND source_id = ?", sourceType, sourceID).First(&models.Boleto{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"SourceID": ErrBoletoExists.Error(),
+		})
+		return
+	}
+
+	// The customer could pay twice while a PIX charge is open, and once it
+	// is paid there's nothing left to receive
+	if db.Where("source_type = ? AND source_id = ?", sourceType, sourceID).First(&models.PixCharge{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"SourceID": ErrPixChargeExists.Error(),
+		})
+		return
+	}
+
+	var agreement *models.BankAgreement
+	if db.Scopes(models.FromCompany(companyID)).First(&agreement, issuing.BankAgreementID).Error != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"BankAgreementID": ErrBankAgreementNotFound.Error(),
+		})
+		return
+	}
+
+	data := &boleto.Agreement{
+		Bank:    agreement.Bank,
+		Agency:  agreement.Agency,
+		Account: agreement.Account,
+		Wallet:  agreement.Wallet,
+		Code:    agreement.Code,
+	}
+
+	ourNumber, err := boleto.OurNumber(data, agreement.NextNumber)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"BankAgreement.Bank": err.Error(),
+		})
+		return
+	}
+
+	freeField, _ := boleto.FreeField(data, ourNumber)
+	barcode := boleto.Barcode(agreement.Bank, issuing.DueDate, value, freeField)
+
+	document := &models.Boleto{
+		OurNumber:       ourNumber,
+		Value:           value,
+		DueDate:         issuing.DueDate,
+		Barcode:         barcode,
+		DigitableLine:   boleto.DigitableLine(barcode),
+		BankAgreementID: agreement.ID,
+		SourceID:        sourceID,
+		SourceType:      sourceType,
+		CompanyID:       companyID,
+	}
+
+	if db.Create(&document).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	agreement.NextNumber++
+	db.Model(&agreement).Update("next_number", agreement.NextNumber)
+
+	context.JSON(http.StatusOK, document)
+}
+
+func viewBoleto(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var document *models.Boleto
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("BankAgreement").Preload("Entry.Transactions.Account")
+	if tx.First(&document, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, document)
+}
+
+// viewBoletoPDF renders the boleto for the customer to pay, the company as
+// beneficiary and the customer of the document as payer.
+func viewBoletoPDF(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var document *models.Boleto
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("BankAgreement").Preload("Company")
+	if tx.First(&document, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	var customerID *uint
+	if document.SourceType == "sales" {
+		var sale *models.Sale
+		db.First(&sale, document.SourceID)
+		customerID = &sale.CustomerID
+	} else {
+		var performed *models.ServicePerformed
+		db.First(&performed, document.SourceID)
+		customerID = performed.CustomerID
+	}
+
+	var customer *models.Customer
+	if customerID == nil || db.First(&customer, *customerID).Error != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"CustomerID": ErrPayerRequired.Error(),
+		})
+		return
+	}
+
+	address := ""
+	if customer.Address != nil {
+		address = fmt.Sprintf("%s, %s - %s - %s/%s - %s", customer.Address.Street, customer.Address.Number,
+			customer.Address.Neighborhood, customer.Address.City, customer.Address.State, customer.Address.Postcode)
+	}
+
+	agreement := document.BankAgreement
+	agencyCode := agreement.Agency + "/" + agreement.Account
+	if agreement.Code != "" {
+		agencyCode = agreement.Agency + "/" + agreement.Code
+	}
+
+	file := boleto.PDF(&boleto.Document{
+		Bank:                agreement.Bank,
+		Beneficiary:         document.Company.Name,
+		BeneficiaryDocument: document.Company.Cnpj,
+		AgencyCode:          agencyCode,
+		Payer:               customer.Name,
+		PayerDocument:       customer.Cpf,
+		PayerAddress:        address,
+		OurNumber:           document.OurNumber,
+		DocumentNumber:      fmt.Sprint(document.SourceID),
+		IssuedAt:            document.CreatedAt,
+		DueDate:             document.DueDate,
+		Value:               document.Value,
+		Barcode:             document.Barcode,
+		DigitableLine:       document.DigitableLine,
+	})
+
+	context.Header("Content-Disposition", fmt.Sprintf("inline; filename=boleto-%s.pdf", document.OurNumber))
+	context.Data(http.StatusOK, "application/pdf", file)
+}
+
+// importBoletoReturn reads the return file of the bank and registers the
+// payments of the boletos it settles, posting them from the receivable of
+// the document to the bank account. Occurrences not matching any boleto of
+// the company, or of boletos already paid, are returned unmatched.
+func importBoletoReturn(context *gin.Context) {
+	upload, err := context.FormFile("file")
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"file": ErrReturnFileMissing.Error(),
+		})
+		return
+	}
+
+	reader, err := upload.Open()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	payments, err := boleto.ReadReturn(contents)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"file": err.Error(),
+		})
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+	returns := []*boletoReturn{}
+
+	for _, payment := range payments {
+		processed := &boletoReturn{Payment: payment}
+		returns = append(returns, processed)
+
+		if !payment.Paid() {
+			continue
+		}
+
+		var document *models.Boleto
+
+		tx := db.Scopes(models.FromCompany(companyID)).Joins("BankAgreement")
+		tx = tx.Where("BankAgreement.bank = ? AND our_number = ?", payment.Bank, strings.TrimSpace(payment.OurNumber))
+		if tx.First(&document).Error != nil || document.PaidAt != nil {
+			continue
+		}
+
+		var receivableAccountID *uint
+		if document.SourceType == "sales" {
+			var sale *models.Sale
+			db.First(&sale, document.SourceID)
+			receivableAccountID = sale.ReceivableAccountID
+		} else {
+			var performed *models.ServicePerformed
+			db.First(&performed, document.SourceID)
+			receivableAccountID = performed.ReceivableAccountID
+		}
+
+		if receivableAccountID == nil {
+			continue
+		}
+
+		agreement := document.BankAgreement
+
+		// What is paid over the value goes to interest, when the agreement
+		// has an account for it
+		settled := payment.Value
+		if agreement.InterestAccountID != nil {
+			settled = math.Min(payment.Value, document.Value)
+		}
+
+		transactions := []*models.Transaction{
+			{Value: payment.Value, AccountID: agreement.BankAccountID},
+			{Value: -settled, AccountID: *receivableAccountID},
+		}
+
+		if interest := models.RoundMoney(payment.Value - settled); interest > 0 {
+			transactions = append(transactions, &models.Transaction{
+				Value:     interest,
+				AccountID: *agreement.InterestAccountID,
+			})
+		}
+
+		entry := &models.Entry{
+			Description:  fmt.Sprintf("Payment of boleto %s", document.OurNumber),
+			CompanyID:    companyID,
+			Transactions: transactions,
+		}
+		entry.CreatedAt = payment.Date
+
+		paidAt := payment.Date
+		document.PaidAt = &paidAt
+		document.PaidValue = payment.Value
+		document.Entry = entry
+
+		if db.Omit("BankAgreement").Save(&document).Error != nil {
+			context.Status(http.StatusInternalServerError)
+			return
+		}
+
+		processed.Matched = true
+		processed.BoletoID = document.ID
+	}
+
+	context.JSON(http.StatusOK, returns)
+}
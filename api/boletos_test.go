@@ -0,0 +1,558 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/accounting/api"
+	"example.com/accounting/boleto"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"gorm.io/gorm"
+)
+
+func TestBoletos(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Account{})
+	db.AutoMigrate(&models.Company{})
+	db.AutoMigrate(&models.Customer{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Sale{})
+	db.AutoMigrate(&models.Item{})
+	db.AutoMigrate(&models.Service{})
+	db.AutoMigrate(&models.ServicePerformed{})
+	db.AutoMigrate(&models.Withholding{})
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.BankAgreement{})
+	db.AutoMigrate(&models.Boleto{})
+	db.AutoMigrate(&models.PixCharge{})
+	db.AutoMigrate(&models.Component{})
+	db.AutoMigrate(&models.Serial{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.StockReservation{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+
+	t.Cleanup(database.Cleanup)
+
+	db.Create(&models.Company{Name: "Testing Company", Cnpj: "11.222.333/0001-81"})
+
+	customer := &models.Customer{
+		Name:      "Customer",
+		Cpf:       "529.982.247-25",
+		CompanyID: 1,
+		Address: &models.Address{
+			Street:       "Rua da Assembleia",
+			Number:       "10",
+			Neighborhood: "Centro",
+			City:         "Rio de Janeiro",
+			State:        "RJ",
+			Postcode:     "20011-000",
+		},
+	}
+	db.Create(customer)
+
+	bank := &models.Account{Name: "Bank", Type: models.Asset, CompanyID: 1}
+	db.Create(bank)
+
+	receivables := &models.Account{Name: "Receivables", Type: models.Asset, CompanyID: 1}
+	db.Create(receivables)
+
+	interest := &models.Account{Name: "Interest", Type: models.Revenue, CompanyID: 1}
+	db.Create(interest)
+
+	revenue := &models.Account{Name: "Revenue", Type: models.Revenue, CompanyID: 1}
+	db.Create(revenue)
+
+	inventory := &models.Account{Name: "Inventory", Type: models.Asset, CompanyID: 1}
+	db.Create(inventory)
+
+	costOfSale := &models.Account{Name: "Cost of sale", Type: models.Expense, CompanyID: 1}
+	db.Create(costOfSale)
+
+	chair := &models.Product{
+		Name:                "Chair",
+		Price:               100,
+		InventoryAccountID:  inventory.ID,
+		RevenueAccountID:    &revenue.ID,
+		CostOfSaleAccountID: &costOfSale.ID,
+		CompanyID:           1,
+		StockEntries:        []*models.StockEntry{{Qty: 10, Price: 60}},
+	}
+	db.Create(chair)
+
+	sell := func(paid bool) *models.Sale {
+		sale := &models.Sale{
+			Paid:                paid,
+			Discount:            20,
+			DiscountType:        models.FixedDiscount,
+			CustomerID:          customer.ID,
+			CompanyID:           1,
+			ReceivableAccountID: &receivables.ID,
+			Items: []*models.Item{
+				{Qty: 2, Price: 100, ProductID: chair.ID},
+			},
+		}
+		db.Create(sale)
+		return sale
+	}
+
+	sale := sell(false)
+	paid := sell(true)
+
+	repair := &models.Service{Name: "Repair", RevenueAccountID: revenue.ID, CostOfServiceAccountID: inventory.ID, CompanyID: 1}
+	db.Create(repair)
+
+	perform := func(customerID *uint) *models.ServicePerformed {
+		performed := &models.ServicePerformed{
+			Value:               1000,
+			ServiceID:           repair.ID,
+			CustomerID:          customerID,
+			CompanyID:           1,
+			ReceivableAccountID: &receivables.ID,
+			Withholdings: []*models.Withholding{
+				{Type: models.IRRF, Rate: 1.5, Base: 1000, Value: 15},
+				{Type: models.CSRF, Rate: 4.65, Base: 1000, Value: 46.5},
+			},
+		}
+		db.Create(performed)
+		return performed
+	}
+
+	performed := perform(&customer.ID)
+	anonymous := perform(nil)
+
+	router := api.GetRouter()
+
+	errors := func(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+		return response
+	}
+
+	agree := func(t *testing.T, agreement *models.BankAgreement) *models.BankAgreement {
+		req := Post(t, "/bank-agreements", agreement)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		json.Unmarshal(w.Body.Bytes(), &agreement)
+		return agreement
+	}
+
+	issue := func(t *testing.T, url string, agreement *models.BankAgreement) *httptest.ResponseRecorder {
+		req := Post(t, url, map[string]interface{}{
+			"BankAgreementID": agreement.ID,
+			"DueDate":         time.Date(2025, time.March, 10, 0, 0, 0, 0, time.Local),
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	upload := func(t *testing.T, file string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+
+		part, _ := form.CreateFormFile("file", "RETORNO.RET")
+		part.Write([]byte(file))
+		form.Close()
+
+		req, _ := http.NewRequest("POST", "/boletos/returns", &body)
+		req.Header.Set("content-type", form.FormDataContentType())
+		req.Header.Set("CompanyID", "1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// line lays out the fields at their positions, counted from 1
+	line := func(size int, fields map[int]string) string {
+		layout := []byte(strings.Repeat(" ", size))
+		for position, value := range fields {
+			copy(layout[position-1:], value)
+		}
+		return string(layout)
+	}
+
+	balance := func(account *models.Account) float64 {
+		value := 0.0
+		db.Model(&models.Transaction{}).Where("account_id = ?", account.ID).Select("COALESCE(SUM(value), 0)").Scan(&value)
+		return value
+	}
+
+	var bb *models.BankAgreement
+	var bradesco *models.BankAgreement
+	var saleBoleto *models.Boleto
+	var serviceBoleto *models.Boleto
+
+	t.Run("Create agreements", func(t *testing.T) {
+		bb = agree(t, &models.BankAgreement{
+			Bank:              boleto.BancoDoBrasil,
+			Agency:            "1234",
+			Account:           "56789",
+			Wallet:            "18",
+			Code:              "1234567",
+			NextNumber:        1,
+			BankAccountID:     bank.ID,
+			InterestAccountID: &interest.ID,
+		})
+
+		bradesco = agree(t, &models.BankAgreement{
+			Bank:          boleto.Bradesco,
+			Agency:        "1234",
+			Account:       "0012345",
+			Wallet:        "09",
+			NextNumber:    1,
+			BankAccountID: bank.ID,
+		})
+
+		if bb.BankAccount == nil || bb.BankAccount.Name != "Bank" {
+			t.Errorf("Expected the bank account to be loaded, got %v", bb.BankAccount)
+		}
+	})
+
+	t.Run("Create agreement with unsupported bank", func(t *testing.T) {
+		req := Post(t, "/bank-agreements", &models.BankAgreement{
+			Bank:          "999",
+			Agency:        "1234",
+			Account:       "56789",
+			BankAccountID: bank.ID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Bank"] != boleto.ErrUnsupportedBank.Error() {
+			t.Errorf("Expected error %v, got %v", boleto.ErrUnsupportedBank, w.Body.String())
+		}
+	})
+
+	t.Run("Issue for sale", func(t *testing.T) {
+		w := issue(t, fmt.Sprintf("/sales/%d/boleto", sale.ID), bb)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		json.Unmarshal(w.Body.Bytes(), &saleBoleto)
+
+		if saleBoleto.OurNumber != "12345670000000001" {
+			t.Errorf("Expected nosso número %v, got %v", "12345670000000001", saleBoleto.OurNumber)
+		}
+
+		if saleBoleto.Value != 180 {
+			t.Errorf("Expected value %v, got %v", 180, saleBoleto.Value)
+		}
+
+		barcode := saleBoleto.Barcode
+		if len(barcode) != 44 {
+			t.Fatalf("Expected barcode of 44 digits, got %v", barcode)
+		}
+
+		// Due 16 days after the factor started over on 2025-02-22
+		if barcode[:4] != "0019" || barcode[5:19] != "10160000018000" {
+			t.Errorf("Expected bank, factor and value in barcode, got %v", barcode)
+		}
+
+		if barcode[19:] != "000000"+"12345670000000001"+"18" {
+			t.Errorf("Expected free field of the agreement in barcode, got %v", barcode)
+		}
+
+		if check := boleto.Mod11(barcode[:4] + barcode[5:]); barcode[4:5] != check {
+			t.Errorf("Expected check digit %v, got %v", check, barcode[4:5])
+		}
+
+		if saleBoleto.DigitableLine != boleto.DigitableLine(barcode) {
+			t.Errorf("Expected digitable line %v, got %v", boleto.DigitableLine(barcode), saleBoleto.DigitableLine)
+		}
+
+		db.First(&bb, bb.ID)
+		if bb.NextNumber != 2 {
+			t.Errorf("Expected the agreement's sequence to move to %v, got %v", 2, bb.NextNumber)
+		}
+	})
+
+	t.Run("Issue for sale twice", func(t *testing.T) {
+		w := issue(t, fmt.Sprintf("/sales/%d/boleto", sale.ID), bb)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["SourceID"] != api.ErrBoletoExists.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrBoletoExists, w.Body.String())
+		}
+	})
+
+	t.Run("Issue for paid sale", func(t *testing.T) {
+		w := issue(t, fmt.Sprintf("/sales/%d/boleto", paid.ID), bb)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Paid"] != api.ErrAlreadyPaid.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrAlreadyPaid, w.Body.String())
+		}
+	})
+
+	t.Run("Issue for service performed", func(t *testing.T) {
+		w := issue(t, fmt.Sprintf("/services/performed/%d/boleto", performed.ID), bradesco)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		json.Unmarshal(w.Body.Bytes(), &serviceBoleto)
+
+		if serviceBoleto.OurNumber != "00000000001" {
+			t.Errorf("Expected nosso número %v, got %v", "00000000001", serviceBoleto.OurNumber)
+		}
+
+		// Net of what the customer withholds
+		if serviceBoleto.Value != 938.5 {
+			t.Errorf("Expected value %v, got %v", 938.5, serviceBoleto.Value)
+		}
+
+		if free := serviceBoleto.Barcode[19:]; free != "1234"+"09"+"00000000001"+"0012345"+"0" {
+			t.Errorf("Expected free field of the agreement in barcode, got %v", free)
+		}
+	})
+
+	t.Run("Issue for service performed without customer", func(t *testing.T) {
+		w := issue(t, fmt.Sprintf("/services/performed/%d/boleto", anonymous.ID), bradesco)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["CustomerID"] != api.ErrPayerRequired.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrPayerRequired, w.Body.String())
+		}
+	})
+
+	t.Run("Issue with unknown agreement", func(t *testing.T) {
+		w := issue(t, fmt.Sprintf("/sales/%d/boleto", sell(false).ID), &models.BankAgreement{Model: gorm.Model{ID: 99}})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["BankAgreementID"] != api.ErrBankAgreementNotFound.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrBankAgreementNotFound, w.Body.String())
+		}
+	})
+
+	t.Run("Issue while a PIX charge is open", func(t *testing.T) {
+		other := sell(false)
+		db.Create(&models.PixCharge{TxID: "SALE000001000000000000099", Value: 180, SourceID: other.ID, SourceType: "sales", CompanyID: 1})
+
+		w := issue(t, fmt.Sprintf("/sales/%d/boleto", other.ID), bb)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["SourceID"] != api.ErrPixChargeExists.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrPixChargeExists, w.Body.String())
+		}
+	})
+
+	t.Run("View PDF", func(t *testing.T) {
+		req := Get(t, fmt.Sprintf("/boletos/%d/pdf", saleBoleto.ID))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		file := w.Body.String()
+
+		if !strings.HasPrefix(file, "%PDF-1.4") || !strings.HasSuffix(file, "%%EOF\n") {
+			t.Errorf("Expected a PDF, got %v", file)
+		}
+
+		for _, text := range []string{"(001-9)", "(" + saleBoleto.DigitableLine + ")", "(R$ 180,00)", "(Customer - 529.982.247-25)"} {
+			if !strings.Contains(file, text) {
+				t.Errorf("Expected %v in the PDF", text)
+			}
+		}
+	})
+
+	t.Run("Import CNAB 400 return", func(t *testing.T) {
+		file := strings.Join([]string{
+			line(400, map[int]string{1: "02RETORNO", 77: "001"}),
+			line(400, map[int]string{1: "7", 64: saleBoleto.OurNumber, 109: "06150325", 254: "0000000018500"}),
+			line(400, map[int]string{1: "7", 64: "12345670000000099", 109: "06150325", 254: "0000000005000"}),
+			line(400, map[int]string{1: "9"}),
+		}, "\r\n")
+
+		w := upload(t, file)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var returns []map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &returns)
+
+		if len(returns) != 2 || returns[0]["Matched"] != true || returns[1]["Matched"] != false {
+			t.Fatalf("Expected the first occurrence only to match, got %v", w.Body.String())
+		}
+
+		var document *models.Boleto
+		db.Preload("Entry.Transactions").First(&document, saleBoleto.ID)
+
+		if document.PaidAt == nil || document.PaidAt.Format("2006-01-02") != "2025-03-15" || document.PaidValue != 185 {
+			t.Errorf("Expected boleto paid on 2025-03-15 with 185, got %v %v", document.PaidAt, document.PaidValue)
+		}
+
+		if document.Entry == nil || document.Entry.CreatedAt.Format("2006-01-02") != "2025-03-15" {
+			t.Fatalf("Expected the payment to be posted on the payment date, got %v", document.Entry)
+		}
+
+		// The interest goes to the agreement's interest account
+		if balance(bank) != 185 || balance(receivables) != -180 || balance(interest) != 5 {
+			t.Errorf("Expected balances %v, %v and %v, got %v, %v and %v", 185, -180, 5,
+				balance(bank), balance(receivables), balance(interest))
+		}
+
+		// The sale keeps posting to receivables, the payment settling them
+		var settled *models.Sale
+		db.First(&settled, sale.ID)
+
+		if settled.Paid || settled.PaymentAccountID != nil {
+			t.Errorf("Expected the sale to stay receivable, got %v %v", settled.Paid, settled.PaymentAccountID)
+		}
+	})
+
+	t.Run("Import CNAB 240 return", func(t *testing.T) {
+		file := strings.Join([]string{
+			line(240, map[int]string{1: "2370000"}),
+			line(240, map[int]string{1: "2370001"}),
+			line(240, map[int]string{1: "2370001300001T 06", 38: "009" + "00000" + serviceBoleto.OurNumber + "P"}),
+			line(240, map[int]string{1: "2370001300002U 06", 78: "000000000093850", 138: "20032025"}),
+			line(240, map[int]string{1: "2370001500000"}),
+			line(240, map[int]string{1: "2379999900000"}),
+		}, "\r\n")
+
+		w := upload(t, file)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var returns []map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &returns)
+
+		if len(returns) != 1 || returns[0]["Matched"] != true {
+			t.Fatalf("Expected the occurrence to match, got %v", w.Body.String())
+		}
+
+		var document *models.Boleto
+		db.First(&document, serviceBoleto.ID)
+
+		if document.PaidAt == nil || document.PaidAt.Format("2006-01-02") != "2025-03-20" || document.PaidValue != 938.5 {
+			t.Errorf("Expected boleto paid on 2025-03-20 with 938.5, got %v %v", document.PaidAt, document.PaidValue)
+		}
+
+		if balance(bank) != 1123.5 || balance(receivables) != -1118.5 {
+			t.Errorf("Expected balances %v and %v, got %v and %v", 1123.5, -1118.5, balance(bank), balance(receivables))
+		}
+
+		var settled *models.ServicePerformed
+		db.First(&settled, performed.ID)
+
+		if settled.Paid || settled.PaymentAccountID != nil {
+			t.Errorf("Expected the service to stay receivable, got %v %v", settled.Paid, settled.PaymentAccountID)
+		}
+	})
+
+	t.Run("Import return twice", func(t *testing.T) {
+		file := strings.Join([]string{
+			line(400, map[int]string{1: "02RETORNO", 77: "001"}),
+			line(400, map[int]string{1: "7", 64: saleBoleto.OurNumber, 109: "06150325", 254: "0000000018500"}),
+		}, "\r\n")
+
+		w := upload(t, file)
+
+		var returns []map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &returns)
+
+		if len(returns) != 1 || returns[0]["Matched"] != false {
+			t.Errorf("Expected boletos already paid not to match, got %v", w.Body.String())
+		}
+
+		if balance(bank) != 1123.5 {
+			t.Errorf("Expected balance %v, got %v", 1123.5, balance(bank))
+		}
+	})
+
+	t.Run("Update settled sale", func(t *testing.T) {
+		req := Put(t, fmt.Sprintf("/sales/%d", sale.ID), map[string]interface{}{
+			"Discount":            20,
+			"CustomerID":          customer.ID,
+			"ReceivableAccountID": receivables.ID,
+			"Items": []map[string]interface{}{
+				{"Qty": 2, "Price": 100, "ProductID": chair.ID},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var updated *models.Sale
+		db.Preload("Items").First(&updated, sale.ID)
+		api.CreateAccountingEntries(updated)
+
+		// The sale is posted to receivables again, which the boleto settled
+		if balance(bank) != 1123.5 || balance(receivables) != -938.5 {
+			t.Errorf("Expected balances %v and %v, got %v and %v", 1123.5, -938.5, balance(bank), balance(receivables))
+		}
+	})
+
+	t.Run("Import invalid return", func(t *testing.T) {
+		w := upload(t, "not a return file")
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["file"] != boleto.ErrInvalidReturnFile.Error() {
+			t.Errorf("Expected error %v, got %v", boleto.ErrInvalidReturnFile, w.Body.String())
+		}
+	})
+}
@@ -364,7 +364,7 @@ func TestEntries(t *testing.T) {
 
 	t.Run("Create with purchase", func(t *testing.T) {
 		db.AutoMigrate(&models.Product{})
-		db.AutoMigrate(&models.Purchase{})
+		db.AutoMigrate(&models.Purchase{}, &models.PurchaseLine{})
 
 		inventory := &models.Account{
 			Name:      "Inventory",
@@ -381,11 +381,17 @@ func TestEntries(t *testing.T) {
 			InventoryAccountID: inventory.ID,
 		})
 
+		db.Create(&models.Vendor{
+			Name:      "Vendor",
+			CompanyID: 1,
+		})
+
 		db.Create(&models.Purchase{
-			Qty:       1,
-			Price:     10,
-			ProductID: 1,
+			VendorID:  1,
 			CompanyID: 1,
+			Lines: []*models.PurchaseLine{
+				{Qty: 1, Price: 10, ProductID: 1},
+			},
 		})
 
 		req := Post(t, "/entries", map[string]interface{}{
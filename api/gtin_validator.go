@@ -0,0 +1,37 @@
+package api
+
+import (
+	"regexp"
+)
+
+// Regexp pattern for GTIN-8, GTIN-12 (UPC-A), GTIN-13 (EAN-13) and GTIN-14.
+var GTINRegexp = regexp.MustCompile(`^(\d{8}|\d{12,14})$`)
+
+// IsGTIN verifies if the given string is a valid GTIN barcode.
+func IsGTIN(code string) bool {
+
+	if !GTINRegexp.MatchString(code) {
+		return false
+	}
+
+	last := len(code) - 1
+	return code[last:] == calculateGTINDigit(code[:last])
+}
+
+// calculateGTINDigit calculates the check digit for the given code, weighting
+// its digits by 3 and 1 alternately from the rightmost one.
+func calculateGTINDigit(code string) string {
+
+	var sum int
+	for i := range code {
+
+		weight := 1
+		if i%2 == 0 {
+			weight = 3
+		}
+
+		sum += toInt(rune(code[len(code)-1-i])) * weight
+	}
+
+	return string(rune('0' + (10-sum%10)%10))
+}
@@ -0,0 +1,211 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"example.com/accounting/nfe"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrInvoiceExists    = errors.New("Sale already has an NF-e")
+	ErrCnpjMissing      = errors.New("CNPJ is required to issue NF-e")
+	ErrAddressMissing   = errors.New("Address is required to issue NF-e")
+	ErrInvalidState     = errors.New("State is not valid")
+	ErrInvalidCityCode  = errors.New("City code must have 7 digits")
+	ErrInvalidNCM       = errors.New("NCM must have 8 digits")
+	ErrInvalidCFOP      = errors.New("CFOP must have 4 digits")
+	ErrCustomerRequired = errors.New("Customer is required to issue NF-e")
+	ErrSaleInvoiced     = errors.New("Sales with an NF-e can't be changed")
+)
+
+func RegisterInvoiceEndpoints(router *gin.Engine) {
+	group := router.Group("/sales/:id/nfe")
+
+	group.POST("", issueInvoice)
+	group.GET("", viewInvoice)
+	group.GET("/xml", viewInvoiceXML)
+}
+
+// checkAddress returns the errors of an address missing what the NF-e needs,
+// keyed under the prefix.
+func checkAddress(address *models.Address, prefix string) gin.H {
+	if address == nil || address.Street == "" || address.City == "" {
+		return gin.H{prefix: ErrAddressMissing.Error()}
+	}
+
+	if _, ok := nfe.StateCodes[address.State]; !ok {
+		return gin.H{prefix + ".State": ErrInvalidState.Error()}
+	}
+
+	if len(nfe.Digits(address.CityCode)) != 7 {
+		return gin.H{prefix + ".CityCode": ErrInvalidCityCode.Error()}
+	}
+
+	return nil
+}
+
+// checkInvoiceData returns the errors of the sale missing data the NF-e
+// requires from the company, the customer, or the products.
+func checkInvoiceData(sale *models.Sale) gin.H {
+	if len(nfe.Digits(sale.Company.Cnpj)) != 14 {
+		return gin.H{"Company.Cnpj": ErrCnpjMissing.Error()}
+	}
+
+	if errs := checkAddress(sale.Company.Address, "Company.Address"); errs != nil {
+		return errs
+	}
+
+	if sale.Customer == nil {
+		return gin.H{"CustomerID": ErrCustomerRequired.Error()}
+	}
+
+	if errs := checkAddress(sale.Customer.Address, "Customer.Address"); errs != nil {
+		return errs
+	}
+
+	for idx, item := range sale.Items {
+		if len(nfe.Digits(item.Product.NCM)) != 8 {
+			return gin.H{fmt.Sprintf("Items.%d.NCM", idx): ErrInvalidNCM.Error()}
+		}
+
+		if len(nfe.Digits(item.Product.CFOP)) != 4 {
+			return gin.H{fmt.Sprintf("Items.%d.CFOP", idx): ErrInvalidCFOP.Error()}
+		}
+	}
+
+	return nil
+}
+
+func issueInvoice(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var sale *models.Sale
+	companyID := context.Value("CompanyID").(uint)
+
+	query := db.Scopes(models.FromCompany(companyID))
+	query = query.Preload("Company").Preload("Customer").Preload("Items.Product").Preload("Items.Taxes")
+
+	if query.First(&sale, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if db.Where("sale_id = ?", sale.ID).First(&models.Invoice{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"SaleID": ErrInvoiceExists.Error(),
+		})
+		return
+	}
+
+	if errs := checkInvoiceData(sale); errs != nil {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	company := sale.Company
+
+	// Numbers are never reused, not even those of deleted invoices
+	number := 0
+	tx := db.Unscoped().Model(&models.Invoice{}).Scopes(models.FromCompany(companyID))
+	tx.Where("series = ?", company.NFeSeries).Select("COALESCE(MAX(number), 0)").Scan(&number)
+
+	invoice := &models.Invoice{
+		Series:     company.NFeSeries,
+		Number:     number + 1,
+		Code:       fmt.Sprintf("%08d", rand.Intn(100000000)),
+		IssuedAt:   time.Now().Truncate(time.Second),
+		Production: company.NFeProduction,
+		SaleID:     sale.ID,
+		CompanyID:  companyID,
+	}
+
+	invoice.AccessKey = nfe.AccessKey(
+		company.Address.State,
+		invoice.IssuedAt,
+		company.Cnpj,
+		invoice.Series,
+		invoice.Number,
+		invoice.Code,
+	)
+
+	document, err := nfe.Build(sale, invoice).Marshal()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	invoice.XML = string(document)
+
+	if db.Create(&invoice).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, invoice)
+}
+
+func viewInvoice(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var invoice *models.Invoice
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Where("sale_id = ?", id).First(&invoice).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, invoice)
+}
+
+func viewInvoiceXML(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var invoice *models.Invoice
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Where("sale_id = ?", id).First(&invoice).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.Data(http.StatusOK, "application/xml", []byte(invoice.XML))
+}
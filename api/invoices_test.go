@@ -0,0 +1,397 @@
+package api_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"example.com/accounting/nfe"
+)
+
+func TestInvoices(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Account{})
+	db.AutoMigrate(&models.Company{})
+	db.AutoMigrate(&models.Customer{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Sale{})
+	db.AutoMigrate(&models.Item{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+	db.AutoMigrate(&models.Invoice{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+
+	t.Cleanup(database.Cleanup)
+
+	company := &models.Company{
+		Name:              "Testing Company",
+		Cnpj:              "11.222.333/0001-81",
+		StateRegistration: "110.042.490.114",
+		Regime:            models.NonCumulativeRegime,
+		NFeSeries:         1,
+		Address: &models.Address{
+			Street:       "Avenida Paulista",
+			Number:       "1000",
+			Neighborhood: "Bela Vista",
+			City:         "São Paulo",
+			State:        "SP",
+			Postcode:     "01310-100",
+			CityCode:     "3550308",
+		},
+	}
+	db.Create(company)
+
+	customer := &models.Customer{
+		Name:      "Customer",
+		Cpf:       "529.982.247-25",
+		CompanyID: 1,
+		Address: &models.Address{
+			Street:       "Rua da Assembleia",
+			Number:       "10",
+			Neighborhood: "Centro",
+			City:         "Rio de Janeiro",
+			State:        "RJ",
+			Postcode:     "20011-000",
+			CityCode:     "3304557",
+		},
+	}
+	db.Create(customer)
+
+	receivables := &models.Account{Name: "Receivables", Type: models.Asset, CompanyID: 1}
+	db.Create(receivables)
+
+	inventory := &models.Account{Name: "Inventory", Type: models.Asset, CompanyID: 1}
+	db.Create(inventory)
+
+	chair := &models.Product{
+		Name:               "Chair",
+		Price:              100,
+		NCM:                "9401.79.00",
+		CFOP:               "6102",
+		InventoryAccountID: inventory.ID,
+		CompanyID:          1,
+	}
+	db.Create(chair)
+
+	unclassified := &models.Product{
+		Name:               "Table",
+		Price:              300,
+		InventoryAccountID: inventory.ID,
+		CompanyID:          1,
+	}
+	db.Create(unclassified)
+
+	sell := func(product *models.Product, taxes []*models.Tax) *models.Sale {
+		sale := &models.Sale{
+			Discount:            20,
+			DiscountType:        models.FixedDiscount,
+			CustomerID:          customer.ID,
+			CompanyID:           1,
+			ReceivableAccountID: &receivables.ID,
+			Items: []*models.Item{
+				{Qty: 2, Price: product.Price, ProductID: product.ID, Taxes: taxes},
+			},
+		}
+		db.Create(sale)
+		return sale
+	}
+
+	sale := sell(chair, []*models.Tax{
+		{Type: models.ICMS, Rate: 12, Base: 180, Value: 21.6},
+		{Type: models.PIS, Rate: 1.65, Base: 180, Value: 2.97},
+	})
+
+	router := api.GetRouter()
+
+	errors := func(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+		return response
+	}
+
+	t.Run("Issue", func(t *testing.T) {
+		req := Post(t, fmt.Sprintf("/sales/%d/nfe", sale.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var invoice *models.Invoice
+		json.Unmarshal(w.Body.Bytes(), &invoice)
+
+		if invoice.Number != 1 || invoice.Series != 1 {
+			t.Errorf("Expected number 1 in series 1, got %v in %v", invoice.Number, invoice.Series)
+		}
+
+		prefix := "35" + time.Now().Format("0601") + "11222333000181" + "55" + "001" + "000000001" + "1"
+		if len(invoice.AccessKey) != 44 || !strings.HasPrefix(invoice.AccessKey, prefix) {
+			t.Errorf("Expected access key starting with %v, got %v", prefix, invoice.AccessKey)
+		}
+
+		sum, weight := 0, 2
+		for i := 42; i >= 0; i-- {
+			sum += int(invoice.AccessKey[i]-'0') * weight
+			if weight++; weight > 9 {
+				weight = 2
+			}
+		}
+
+		digit := 11 - sum%11
+		if digit > 9 {
+			digit = 0
+		}
+
+		if fmt.Sprint(digit) != invoice.AccessKey[43:] {
+			t.Errorf("Expected check digit %v, got %v", digit, invoice.AccessKey[43:])
+		}
+	})
+
+	t.Run("Issue twice", func(t *testing.T) {
+		req := Post(t, fmt.Sprintf("/sales/%d/nfe", sale.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["SaleID"] != api.ErrInvoiceExists.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrInvoiceExists.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Update invoiced sale", func(t *testing.T) {
+		req := Put(t, fmt.Sprintf("/sales/%d", sale.ID), map[string]interface{}{})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["error"] != api.ErrSaleInvoiced.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrSaleInvoiced.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Delete invoiced sale", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, Delete(t, fmt.Sprintf("/sales/%d", sale.ID)))
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if db.First(&models.Sale{}, sale.ID).Error != nil || db.Where("sale_id = ?", sale.ID).First(&models.Invoice{}).Error != nil {
+			t.Error("Expected the sale and its NF-e to be kept")
+		}
+	})
+
+	t.Run("View XML", func(t *testing.T) {
+		req := Get(t, fmt.Sprintf("/sales/%d/nfe/xml", sale.ID))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var document *nfe.NFe
+		if err := xml.Unmarshal(w.Body.Bytes(), &document); err != nil {
+			t.Fatal("Failed parsing XML", err)
+		}
+
+		ide := document.InfNFe.Ide
+		if ide.TpAmb != "2" || ide.IdDest != "2" || ide.IndFinal != "1" {
+			t.Errorf("Expected homologation interstate sale to consumer, got %v %v %v", ide.TpAmb, ide.IdDest, ide.IndFinal)
+		}
+
+		dest := document.InfNFe.Dest
+		if dest.CPF != "52998224725" || dest.XNome != nfe.HomologationName {
+			t.Errorf("Expected recipient %v, got %v %v", "52998224725", dest.CPF, dest.XNome)
+		}
+
+		det := document.InfNFe.Det[0]
+		if det.Prod.NCM != "94017900" || det.Prod.VDesc != "20.00" {
+			t.Errorf("Expected NCM %v and discount %v, got %v %v", "94017900", "20.00", det.Prod.NCM, det.Prod.VDesc)
+		}
+
+		if det.Imposto.ICMS.ICMS00 == nil || det.Imposto.ICMS.ICMS00.VICMS != "21.60" {
+			t.Errorf("Expected ICMS %v, got %+v", "21.60", det.Imposto.ICMS)
+		}
+
+		if det.Imposto.COFINS.COFINSNT == nil {
+			t.Errorf("Expected COFINS not taxed, got %+v", det.Imposto.COFINS)
+		}
+
+		total := document.InfNFe.Total.ICMSTot
+		if total.VProd != "200.00" || total.VNF != "180.00" || total.VPIS != "2.97" {
+			t.Errorf("Expected totals %v and %v, got %v and %v", "200.00", "180.00", total.VProd, total.VNF)
+		}
+
+		xmllint, err := exec.LookPath("xmllint")
+		if err != nil {
+			t.Skip("xmllint is not available to validate against the schema")
+		}
+
+		dir := t.TempDir()
+		schema := filepath.Join(dir, "nfe.xsd")
+		file := filepath.Join(dir, "nfe.xml")
+
+		os.WriteFile(schema, nfe.Schema, 0644)
+		os.WriteFile(file, w.Body.Bytes(), 0644)
+
+		output, err := exec.Command(xmllint, "--noout", "--schema", schema, file).CombinedOutput()
+		if err != nil {
+			t.Errorf("Expected XML to be valid, got %s", output)
+		}
+	})
+
+	t.Run("Issue numbers the next document", func(t *testing.T) {
+		next := sell(chair, nil)
+
+		req := Post(t, fmt.Sprintf("/sales/%d/nfe", next.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var invoice *models.Invoice
+		json.Unmarshal(w.Body.Bytes(), &invoice)
+
+		if invoice.Number != 2 {
+			t.Errorf("Expected number %v, got %v", 2, invoice.Number)
+		}
+
+		req = Get(t, fmt.Sprintf("/sales/%d/nfe", next.ID))
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("Issue to companies", func(t *testing.T) {
+		db.Model(customer).Update("cpf", "11.444.777/0001-61")
+		defer db.Model(customer).Updates(map[string]interface{}{"cpf": "529.982.247-25", "state_registration": ""})
+
+		issue := func() *nfe.NFe {
+			next := sell(chair, nil)
+
+			router.ServeHTTP(httptest.NewRecorder(), Post(t, fmt.Sprintf("/sales/%d/nfe", next.ID), nil))
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, Get(t, fmt.Sprintf("/sales/%d/nfe/xml", next.ID)))
+
+			var document *nfe.NFe
+			if err := xml.Unmarshal(w.Body.Bytes(), &document); err != nil {
+				t.Fatal("Failed parsing XML", err)
+			}
+			return document
+		}
+
+		// Non-contributors are final consumers
+		document := issue()
+		if document.InfNFe.Dest.IndIEDest != "9" || document.InfNFe.Ide.IndFinal != "1" {
+			t.Errorf("Expected non-contributor final consumer, got %v %v", document.InfNFe.Dest.IndIEDest, document.InfNFe.Ide.IndFinal)
+		}
+
+		db.Model(customer).Update("state_registration", "110.042.490.114")
+
+		document = issue()
+		if document.InfNFe.Dest.IndIEDest != "1" || document.InfNFe.Ide.IndFinal != "0" {
+			t.Errorf("Expected contributor not final consumer, got %v %v", document.InfNFe.Dest.IndIEDest, document.InfNFe.Ide.IndFinal)
+		}
+	})
+
+	t.Run("Issue without NCM", func(t *testing.T) {
+		other := sell(unclassified, nil)
+
+		req := Post(t, fmt.Sprintf("/sales/%d/nfe", other.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Items.0.NCM"] != api.ErrInvalidNCM.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrInvalidNCM.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Issue without company address", func(t *testing.T) {
+		db.Model(company).Update("city_code", "")
+
+		other := sell(chair, nil)
+
+		req := Post(t, fmt.Sprintf("/sales/%d/nfe", other.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if errors(t, w)["Company.Address.CityCode"] != api.ErrInvalidCityCode.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrInvalidCityCode.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("View missing", func(t *testing.T) {
+		req := Get(t, "/sales/100/nfe")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %v, got %v", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Issue after a deleted invoice", func(t *testing.T) {
+		db.Model(company).Update("city_code", "3550308")
+		var last *models.Invoice
+		db.Order("number DESC").First(&last)
+		db.Delete(last)
+
+		next := sell(chair, nil)
+
+		req := Post(t, fmt.Sprintf("/sales/%d/nfe", next.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var invoice *models.Invoice
+		json.Unmarshal(w.Body.Bytes(), &invoice)
+
+		if invoice.Number != last.Number+1 {
+			t.Errorf("Expected number %v, got %v", last.Number+1, invoice.Number)
+		}
+	})
+}
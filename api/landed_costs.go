@@ -0,0 +1,232 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrPurchaseNotFound  = errors.New("Purchase not found")
+	ErrNothingToAllocate = errors.New("Purchases have no stock to allocate to")
+)
+
+func RegisterLandedCostEndpoints(router *gin.Engine) {
+	group := router.Group("/landed-costs")
+
+	group.POST("", createLandedCost)
+	group.GET("", listLandedCosts)
+	group.GET("/:id", viewLandedCost)
+	group.DELETE("/:id", deleteLandedCost)
+}
+
+// landedCostEntry debits the allocated shares to the inventory accounts of
+// the products, or to their cost of sale accounts for the stock already
+// used, and credits the whole amount to the payable account.
+func landedCostEntry(landedCost *models.LandedCost) (*models.Entry, error) {
+	db, _ := database.GetConnection()
+
+	accounts := []uint{}
+	values := map[uint]float64{}
+
+	debit := func(account uint, value float64) {
+		if _, ok := values[account]; !ok {
+			accounts = append(accounts, account)
+		}
+		values[account] += value
+	}
+
+	for _, allocation := range landedCost.Allocations {
+		var product *models.Product
+		db.First(&product, allocation.StockEntry.ProductID)
+
+		if allocation.Expensed > 0 {
+			if product.CostOfSaleAccountID == nil {
+				return nil, ErrCostOfSaleAccountMissing
+			}
+			debit(*product.CostOfSaleAccountID, allocation.Expensed)
+		}
+
+		if capitalized := allocation.Value - allocation.Expensed; capitalized > 0 {
+			debit(product.InventoryAccountID, capitalized)
+		}
+	}
+
+	transactions := []*models.Transaction{}
+	for _, account := range accounts {
+		transactions = append(transactions, &models.Transaction{
+			Value:     values[account],
+			AccountID: account,
+		})
+	}
+
+	return &models.Entry{
+		CompanyID:   landedCost.CompanyID,
+		Description: landedCost.Description,
+		Transactions: append(transactions, &models.Transaction{
+			Value:     landedCost.Amount,
+			AccountID: landedCost.PayableAccountID,
+		}),
+	}, nil
+}
+
+func createLandedCost(context *gin.Context) {
+	var landedCost *models.LandedCost
+	if err := context.ShouldBindJSON(&landedCost); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	landedCost.CompanyID = companyID
+	landedCost.Allocations = nil
+	landedCost.Entry = nil
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Lines.StockEntry.StockUsages")
+	if tx.Find(&landedCost.Purchases, landedCost.PurchaseIDs).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if len(landedCost.Purchases) != len(landedCost.PurchaseIDs) {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"PurchaseIDs": ErrPurchaseNotFound.Error(),
+		})
+		return
+	}
+
+	landedCost.Allocate()
+
+	if len(landedCost.Allocations) == 0 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"PurchaseIDs": ErrNothingToAllocate.Error(),
+		})
+		return
+	}
+
+	landedCost.Entry, err = landedCostEntry(landedCost)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"PurchaseIDs": err.Error(),
+		})
+		return
+	}
+
+	if db.Create(&landedCost).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.
+		Joins("PayableAccount").
+		Preload("Purchases").
+		Preload("Allocations.StockEntry").
+		Preload("Entry.Transactions.Account").
+		First(&landedCost)
+
+	context.JSON(http.StatusOK, landedCost)
+}
+
+func listLandedCosts(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var landedCosts []*models.LandedCost
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID))
+	tx = tx.Joins("PayableAccount").Preload("Purchases")
+
+	if tx.Find(&landedCosts).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, landedCosts)
+}
+
+func viewLandedCost(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var landedCost *models.LandedCost
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID))
+	tx = tx.Joins("PayableAccount").
+		Preload("Purchases").
+		Preload("Allocations.StockEntry").
+		Preload("Entry.Transactions.Account")
+
+	if tx.First(&landedCost, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, landedCost)
+}
+
+func deleteLandedCost(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var landedCost *models.LandedCost
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID))
+	tx = tx.Preload("Allocations.StockEntry").Preload("Entry")
+
+	if tx.First(&landedCost, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	landedCost.Deallocate()
+
+	for _, allocation := range landedCost.Allocations {
+		db.Save(allocation.StockEntry)
+	}
+
+	if landedCost.Entry != nil {
+		db.Unscoped().Delete(landedCost.Entry)
+	}
+
+	if db.Unscoped().Select("Allocations", "Purchases").Delete(&landedCost).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
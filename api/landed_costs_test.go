@@ -0,0 +1,361 @@
+package api_test
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+)
+
+func TestLandedCost(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.Purchase{})
+	db.AutoMigrate(&models.PurchaseLine{})
+	db.AutoMigrate(&models.LandedCost{})
+	db.AutoMigrate(&models.LandedCostAllocation{})
+
+	t.Cleanup(database.Cleanup)
+
+	db.Create(&models.Company{Name: "Testing Company"})
+	db.Create(&models.Company{Name: "Other company"})
+
+	inventory := &models.Account{Name: "Inventory", Type: models.Asset, CompanyID: 1}
+	db.Create(inventory)
+
+	payables := &models.Account{Name: "Freight payable", Type: models.Liability, CompanyID: 1}
+	db.Create(payables)
+
+	db.Create(&models.Product{Name: "Screws", Price: 1, InventoryAccountID: inventory.ID, CompanyID: 1})
+	db.Create(&models.Product{Name: "Nails", Price: 2, InventoryAccountID: inventory.ID, CompanyID: 1})
+
+	db.Create(&models.Vendor{Name: "Vendor", CompanyID: 1})
+	db.Create(&models.Vendor{Name: "Other vendor", CompanyID: 2})
+
+	line := func(qty float64, price float64, productID uint) *models.PurchaseLine {
+		return &models.PurchaseLine{
+			Qty:        qty,
+			Price:      price,
+			ProductID:  productID,
+			StockEntry: &models.StockEntry{Qty: qty, Price: price, ProductID: productID},
+		}
+	}
+
+	// ID: 1
+	db.Create(&models.Purchase{
+		VendorID:  1,
+		CompanyID: 1,
+		Lines: []*models.PurchaseLine{
+			line(100, 1, 1),
+			line(100, 3, 2),
+		},
+	})
+
+	// ID: 2
+	db.Create(&models.Purchase{
+		VendorID:  2,
+		CompanyID: 2,
+		Lines:     []*models.PurchaseLine{line(10, 1, 1)},
+	})
+
+	cost := &models.Account{Name: "Cost of sales", Type: models.Expense, CompanyID: 1}
+	db.Create(cost)
+
+	db.Create(&models.Product{Name: "Bolts", Price: 15, InventoryAccountID: inventory.ID, CostOfSaleAccountID: &cost.ID, CompanyID: 1})
+
+	// ID: 3
+	db.Create(&models.Purchase{
+		VendorID:  1,
+		CompanyID: 1,
+		Lines:     []*models.PurchaseLine{{Qty: 5, Price: 10, ProductID: 1}},
+	})
+
+	// ID: 4, with 4 of the 10 bolts already used
+	used := line(10, 10, 3)
+	used.StockEntry.StockUsages = []*models.StockUsage{{Qty: 4}}
+
+	db.Create(&models.Purchase{
+		VendorID:  1,
+		CompanyID: 1,
+		Lines:     []*models.PurchaseLine{used},
+	})
+
+	price := func(stockEntryID uint) float64 {
+		var entry *models.StockEntry
+		db.First(&entry, stockEntryID)
+		return math.Round(entry.Price*100) / 100
+	}
+
+	router := api.GetRouter()
+
+	t.Run("Create by quantity", func(t *testing.T) {
+		req := Post(t, "/landed-costs", map[string]interface{}{
+			"Description":      "Freight",
+			"Amount":           200,
+			"Method":           models.AllocateByQty,
+			"PurchaseIDs":      []uint{1},
+			"PayableAccountID": payables.ID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var landedCost *models.LandedCost
+		if err := json.Unmarshal(w.Body.Bytes(), &landedCost); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(landedCost.Allocations) != 2 {
+			t.Fatalf("Expected %v allocations, got %v", 2, len(landedCost.Allocations))
+		}
+
+		if price(1) != 2 {
+			t.Errorf("Expected price %v, got %v", 2, price(1))
+		}
+
+		if price(2) != 4 {
+			t.Errorf("Expected price %v, got %v", 4, price(2))
+		}
+
+		var inv *models.Account
+		db.Preload("Transactions").First(&inv, inventory.ID)
+
+		if inv.Balance() != 200 {
+			t.Errorf("Expected balance %v, got %v", 200, inv.Balance())
+		}
+
+		var payable *models.Account
+		db.Preload("Transactions").First(&payable, payables.ID)
+
+		if payable.Balance() != 200 {
+			t.Errorf("Expected balance %v, got %v", 200, payable.Balance())
+		}
+	})
+
+	t.Run("Cost includes landed costs", func(t *testing.T) {
+		var product *models.Product
+		db.Preload("StockEntries.StockUsages").First(&product, 1)
+
+		if product.Cost(10) != 20 {
+			t.Errorf("Expected cost %v, got %v", 20, product.Cost(10))
+		}
+	})
+
+	t.Run("Create by value", func(t *testing.T) {
+		req := Post(t, "/landed-costs", map[string]interface{}{
+			"Description":      "Import duties",
+			"Amount":           100,
+			"Method":           models.AllocateByValue,
+			"PurchaseIDs":      []uint{1},
+			"PayableAccountID": payables.ID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		// Screws are worth 100 and nails 300, so they get 25 and 75
+		if price(1) != 2.25 {
+			t.Errorf("Expected price %v, got %v", 2.25, price(1))
+		}
+
+		if price(2) != 4.75 {
+			t.Errorf("Expected price %v, got %v", 4.75, price(2))
+		}
+	})
+
+	t.Run("Create with purchase from another company", func(t *testing.T) {
+		req := Post(t, "/landed-costs", map[string]interface{}{
+			"Description":      "Freight",
+			"Amount":           10,
+			"PurchaseIDs":      []uint{1, 2},
+			"PayableAccountID": payables.ID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if response["PurchaseIDs"] != api.ErrPurchaseNotFound.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrPurchaseNotFound.Error(), response["PurchaseIDs"])
+		}
+	})
+
+	t.Run("Create without purchases", func(t *testing.T) {
+		req := Post(t, "/landed-costs", map[string]interface{}{
+			"Description":      "Freight",
+			"Amount":           10,
+			"PurchaseIDs":      []uint{},
+			"PayableAccountID": payables.ID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := Get(t, "/landed-costs")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var landedCosts []*models.LandedCost
+		if err := json.Unmarshal(w.Body.Bytes(), &landedCosts); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(landedCosts) != 2 {
+			t.Errorf("Expected %v landed costs, got %v", 2, len(landedCosts))
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		req := Get(t, "/landed-costs/1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var landedCost *models.LandedCost
+		if err := json.Unmarshal(w.Body.Bytes(), &landedCost); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(landedCost.Purchases) != 1 {
+			t.Errorf("Expected %v purchase, got %v", 1, len(landedCost.Purchases))
+		}
+
+		if landedCost.Entry == nil || len(landedCost.Entry.Transactions) != 2 {
+			t.Error("Expected entry with inventory and payable transactions")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := Delete(t, "/landed-costs/1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected status %v, got %v", http.StatusNoContent, w.Code)
+		}
+
+		if price(1) != 1.25 {
+			t.Errorf("Expected price %v, got %v", 1.25, price(1))
+		}
+
+		if price(2) != 3.75 {
+			t.Errorf("Expected price %v, got %v", 3.75, price(2))
+		}
+
+		var inv *models.Account
+		db.Preload("Transactions").First(&inv, inventory.ID)
+
+		if inv.Balance() != 100 {
+			t.Errorf("Expected balance %v, got %v", 100, inv.Balance())
+		}
+	})
+
+	t.Run("Create without stock entries", func(t *testing.T) {
+		req := Post(t, "/landed-costs", map[string]interface{}{
+			"Description":      "Freight",
+			"Amount":           10,
+			"PurchaseIDs":      []uint{3},
+			"PayableAccountID": payables.ID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if response["PurchaseIDs"] != api.ErrNothingToAllocate.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrNothingToAllocate.Error(), response["PurchaseIDs"])
+		}
+	})
+
+	t.Run("Create over stock partly used", func(t *testing.T) {
+		req := Post(t, "/landed-costs", map[string]interface{}{
+			"Description":      "Freight",
+			"Amount":           100,
+			"PurchaseIDs":      []uint{4},
+			"PayableAccountID": payables.ID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		// The 60 falling on the 6 bolts left raises their price by 10
+		if price(4) != 20 {
+			t.Errorf("Expected price %v, got %v", 20, price(4))
+		}
+
+		var expense *models.Account
+		db.Preload("Transactions").First(&expense, cost.ID)
+
+		if expense.Balance() != 40 {
+			t.Errorf("Expected cost of sales of %v, got %v", 40, expense.Balance())
+		}
+
+		var inv *models.Account
+		db.Preload("Transactions").First(&inv, inventory.ID)
+
+		var bolts *models.Product
+		db.Preload("StockEntries.StockUsages").First(&bolts, 3)
+
+		// Only the inventory of the bolts moved since the last check
+		if inv.Balance()-100 != bolts.InventoryValue()-60 {
+			t.Errorf("Expected inventory to rise by %v, got %v", bolts.InventoryValue()-60, inv.Balance()-100)
+		}
+	})
+}
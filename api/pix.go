@@ -0,0 +1,333 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"example.com/accounting/pix"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrPixChargeExists   = errors.New("Document already has a PIX charge")
+	ErrPixKeyMissing     = errors.New("PIX key is required to charge by PIX")
+	ErrPixCityMissing    = errors.New("City is required to charge by PIX")
+	ErrPixAccountMissing = errors.New("PIX account is required to register PIX payments")
+	ErrPixAlreadyPaid    = errors.New("PIX charge is already paid")
+	ErrPixNotReceivable  = errors.New("Document has no receivable to pay by PIX")
+	ErrPixValueMismatch  = errors.New("Value paid doesn't match the PIX charge")
+)
+
+func RegisterPixEndpoints(router *gin.Engine) {
+	router.POST("/sales/:id/pix", chargeSaleByPix)
+	router.POST("/services/performed/:id/pix", chargeServiceByPix)
+
+	group := router.Group("/pix/:txid")
+
+	group.GET("", viewPixCharge)
+	group.GET("/qrcode", viewPixQRCode)
+	group.POST("/payment", payPixCharge)
+}
+
+// pixCharging takes the Location of the charge created at the PSP, under its
+// TxID, for a dynamic QR code. Static QR codes are generated otherwise.
+type pixCharging struct {
+	Location string `binding:"omitempty,url"`
+	TxID     string `binding:"required_with=Location,omitempty,alphanum,min=26,max=35"`
+}
+
+type pixPayment struct {
+	Value      float64 `binding:"required,gt=0"`
+	EndToEndID string
+	PaidAt     *time.Time
+}
+
+func chargeSaleByPix(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var sale *models.Sale
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Preload("Items").First(&sale, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if sale.Paid {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Paid": ErrAlreadyPaid.Error(),
+		})
+		return
+	}
+
+	chargeByPix(context, "sales", "SALE", sale.ID, models.RoundMoney(sale.Total()))
+}
+
+func chargeServiceByPix(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var performed *models.ServicePerformed
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Preload("Withholdings").First(&performed, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if performed.Paid {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Paid": ErrAlreadyPaid.Error(),
+		})
+		return
+	}
+
+	chargeByPix(context, "service_performeds", "SERV", performed.ID, models.RoundMoney(performed.Receivable()))
+}
+
+// chargeByPix creates the PIX charge of the document for the value
+// receivable. Static charges get a txid of 25 characters made of the
+// prefix, the company and the document.
+func chargeByPix(context *gin.Context, sourceType string, prefix string, sourceID uint, value float64) {
+	// The body is optional, static charges needing none
+	charging := &pixCharging{}
+	if err := context.ShouldBindJSON(charging); err != nil && context.Request.ContentLength > 0 {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	var company *models.Company
+	db.First(&company, companyID)
+
+	if db.Where("source_type = ? AND source_id = ?", sourceType, sourceID).First(&models.PixCharge{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"SourceID": ErrPixChargeExists.Error(),
+		})
+		return
+	}
+
+	// Boletos are paid until they're due, so the customer could pay twice
+	if db.Where("source_type = ? AND source_id = ?", sourceType, sourceID).First(&models.Boleto{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"SourceID": ErrBoletoExists.Error(),
+		})
+		return
+	}
+
+	if charging.Location == "" && company.PixKey == "" {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Company.PixKey": ErrPixKeyMissing.Error(),
+		})
+		return
+	}
+
+	if company.Address == nil || company.Address.City == "" {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Company.Address.City": ErrPixCityMissing.Error(),
+		})
+		return
+	}
+
+	txid := charging.TxID
+	if txid == "" {
+		txid = fmt.Sprintf("%s%06d%015d", prefix, companyID, sourceID)
+	}
+
+	charge := &models.PixCharge{
+		TxID:       txid,
+		Value:      value,
+		Location:   charging.Location,
+		SourceID:   sourceID,
+		SourceType: sourceType,
+		CompanyID:  companyID,
+	}
+
+	charge.Payload = pix.Payload(&pix.Charge{
+		Key:      company.PixKey,
+		Location: charging.Location,
+		Name:     company.Name,
+		City:     company.Address.City,
+		Value:    value,
+		TxID:     txid,
+	})
+
+	if db.Create(&charge).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, charge)
+}
+
+func viewPixCharge(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var charge *models.PixCharge
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Entry.Transactions.Account")
+	if tx.Where("tx_id = ?", context.Param("txid")).First(&charge).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, charge)
+}
+
+// viewPixQRCode renders the payload of the charge for the customer to scan.
+func viewPixQRCode(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var charge *models.PixCharge
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Where("tx_id = ?", context.Param("txid")).First(&charge).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	image, err := pix.QRCode(charge.Payload, 8)
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Data(http.StatusOK, "image/png", image)
+}
+
+// payPixCharge registers the payment of the charge, confirmed by the PSP,
+// posting it from the receivable of the document to the PIX account of the
+// company.
+func payPixCharge(context *gin.Context) {
+	var payment *pixPayment
+	if err := context.ShouldBindJSON(&payment); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var charge *models.PixCharge
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Company")
+	if tx.Where("tx_id = ?", context.Param("txid")).First(&charge).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if charge.PaidAt != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"TxID": ErrPixAlreadyPaid.Error(),
+		})
+		return
+	}
+
+	// The receivable is settled for the value charged only
+	if models.RoundMoney(payment.Value) != charge.Value {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Value": ErrPixValueMismatch.Error(),
+		})
+		return
+	}
+
+	if charge.Company.PixAccountID == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Company.PixAccountID": ErrPixAccountMissing.Error(),
+		})
+		return
+	}
+
+	// Documents paid otherwise have nothing left to receive
+	var receivableAccountID *uint
+	if charge.SourceType == "sales" {
+		var sale *models.Sale
+		if db.First(&sale, charge.SourceID).Error == nil && !sale.Paid {
+			receivableAccountID = sale.ReceivableAccountID
+		}
+	} else {
+		var performed *models.ServicePerformed
+		if db.First(&performed, charge.SourceID).Error == nil && !performed.Paid {
+			receivableAccountID = performed.ReceivableAccountID
+		}
+	}
+
+	if receivableAccountID == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"TxID": ErrPixNotReceivable.Error(),
+		})
+		return
+	}
+
+	paidAt := time.Now()
+	if payment.PaidAt != nil {
+		paidAt = *payment.PaidAt
+	}
+
+	entry := &models.Entry{
+		Description: fmt.Sprintf("Payment of PIX %s", charge.TxID),
+		CompanyID:   companyID,
+		Transactions: []*models.Transaction{
+			{Value: payment.Value, AccountID: *charge.Company.PixAccountID},
+			{Value: -payment.Value, AccountID: *receivableAccountID},
+		},
+	}
+	entry.CreatedAt = paidAt
+
+	charge.PaidAt = &paidAt
+	charge.PaidValue = payment.Value
+	charge.EndToEndID = payment.EndToEndID
+	charge.Entry = entry
+
+	if db.Omit("Company").Save(&charge).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Preload("Entry.Transactions.Account").First(&charge)
+	context.JSON(http.StatusOK, charge)
+}
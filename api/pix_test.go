@@ -0,0 +1,375 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"example.com/accounting/pix"
+)
+
+func TestPix(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Account{})
+	db.AutoMigrate(&models.Company{})
+	db.AutoMigrate(&models.Customer{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Sale{})
+	db.AutoMigrate(&models.Item{})
+	db.AutoMigrate(&models.Service{})
+	db.AutoMigrate(&models.ServicePerformed{})
+	db.AutoMigrate(&models.Withholding{})
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.PixCharge{})
+	db.AutoMigrate(&models.BankAgreement{})
+	db.AutoMigrate(&models.Boleto{})
+
+	t.Cleanup(database.Cleanup)
+
+	bank := &models.Account{Name: "Bank", Type: models.Asset, CompanyID: 1}
+	receivables := &models.Account{Name: "Receivables", Type: models.Asset, CompanyID: 1}
+	revenue := &models.Account{Name: "Revenue", Type: models.Revenue, CompanyID: 1}
+	inventory := &models.Account{Name: "Inventory", Type: models.Asset, CompanyID: 1}
+
+	db.Create(&models.Company{Name: "Testing Company"})
+
+	for _, account := range []*models.Account{bank, receivables, revenue, inventory} {
+		db.Create(account)
+	}
+
+	db.Model(&models.Company{}).Where("id = 1").Updates(&models.Company{
+		PixKey:       "pix@example.com",
+		PixAccountID: &bank.ID,
+		Address:      &models.Address{City: "São Paulo", State: "SP"},
+	})
+
+	customer := &models.Customer{Name: "Customer", Cpf: "529.982.247-25", CompanyID: 1}
+	db.Create(customer)
+
+	chair := &models.Product{Name: "Chair", Price: 100, InventoryAccountID: inventory.ID, CompanyID: 1}
+	db.Create(chair)
+
+	sale := &models.Sale{
+		Discount:            20,
+		DiscountType:        models.FixedDiscount,
+		CustomerID:          customer.ID,
+		CompanyID:           1,
+		ReceivableAccountID: &receivables.ID,
+		Items: []*models.Item{
+			{Qty: 2, Price: 100, ProductID: chair.ID},
+		},
+	}
+	db.Create(sale)
+
+	repair := &models.Service{Name: "Repair", RevenueAccountID: revenue.ID, CostOfServiceAccountID: inventory.ID, CompanyID: 1}
+	db.Create(repair)
+
+	performed := &models.ServicePerformed{
+		Value:               500,
+		ServiceID:           repair.ID,
+		CustomerID:          &customer.ID,
+		CompanyID:           1,
+		ReceivableAccountID: &receivables.ID,
+	}
+	db.Create(performed)
+
+	router := api.GetRouter()
+
+	errors := func(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+		return response
+	}
+
+	balance := func(account *models.Account) float64 {
+		value := 0.0
+		db.Model(&models.Transaction{}).Where("account_id = ?", account.ID).Select("COALESCE(SUM(value), 0)").Scan(&value)
+		return value
+	}
+
+	location := "https://pix.example.com/qr/v2/cobv/9d36b84f"
+	dynamicTxID := "7978c0c97ea847e78e8849634473c1f1"
+
+	t.Run("Charge sale", func(t *testing.T) {
+		req := Post(t, fmt.Sprintf("/sales/%d/pix", sale.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var charge *models.PixCharge
+		json.Unmarshal(w.Body.Bytes(), &charge)
+
+		if charge.TxID != "SALE000001000000000000001" {
+			t.Errorf("Expected txid %v, got %v", "SALE000001000000000000001", charge.TxID)
+		}
+
+		expected := "000201010211" +
+			"26370014br.gov.bcb.pix0115pix@example.com" +
+			"5204000053039865406180.005802BR" +
+			"5915Testing Company6009Sao Paulo" +
+			"62290525SALE000001000000000000001" + "6304"
+
+		if !strings.HasPrefix(charge.Payload, expected) {
+			t.Errorf("Expected payload %v, got %v", expected, charge.Payload)
+		}
+
+		crc := fmt.Sprintf("%04X", pix.CRC16(expected))
+		if !strings.HasSuffix(charge.Payload, crc) || len(charge.Payload) != len(expected)+4 {
+			t.Errorf("Expected payload closed by CRC %v, got %v", crc, charge.Payload)
+		}
+	})
+
+	t.Run("Charge sale twice", func(t *testing.T) {
+		req := Post(t, fmt.Sprintf("/sales/%d/pix", sale.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["SourceID"] != api.ErrPixChargeExists.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrPixChargeExists, w.Body.String())
+		}
+	})
+
+	t.Run("Charge sale with a boleto", func(t *testing.T) {
+		other := &models.Sale{CustomerID: customer.ID, CompanyID: 1, ReceivableAccountID: &receivables.ID}
+		db.Create(other)
+		agreement := &models.BankAgreement{Bank: "001", Agency: "1234", Account: "56789", BankAccountID: bank.ID, CompanyID: 1}
+		db.Create(agreement)
+		db.Create(&models.Boleto{OurNumber: "00000000001", BankAgreementID: agreement.ID, SourceID: other.ID, SourceType: "sales", CompanyID: 1})
+
+		req := Post(t, fmt.Sprintf("/sales/%d/pix", other.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["SourceID"] != api.ErrBoletoExists.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrBoletoExists, w.Body.String())
+		}
+	})
+
+	t.Run("Charge service dynamically without txid", func(t *testing.T) {
+		req := Post(t, fmt.Sprintf("/services/performed/%d/pix", performed.ID), map[string]interface{}{
+			"Location": location,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if _, ok := errors(t, w)["TxID"]; !ok {
+			t.Errorf("Expected error in TxID, got %v", w.Body.String())
+		}
+	})
+
+	t.Run("Charge service dynamically", func(t *testing.T) {
+		req := Post(t, fmt.Sprintf("/services/performed/%d/pix", performed.ID), map[string]interface{}{
+			"Location": location,
+			"TxID":     dynamicTxID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var charge *models.PixCharge
+		json.Unmarshal(w.Body.Bytes(), &charge)
+
+		url := strings.TrimPrefix(location, "https://")
+		for _, field := range []string{"010212", fmt.Sprintf("25%02d%s", len(url), url), "62070503***"} {
+			if !strings.Contains(charge.Payload, field) {
+				t.Errorf("Expected field %v in payload %v", field, charge.Payload)
+			}
+		}
+
+		// The value is served at the location
+		if strings.Contains(charge.Payload, "pix@example.com") || strings.Contains(charge.Payload, "5406500.00") {
+			t.Errorf("Expected neither key nor value in payload %v", charge.Payload)
+		}
+	})
+
+	t.Run("View QR code", func(t *testing.T) {
+		req := Get(t, "/pix/SALE000001000000000000001/qrcode")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		image, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+		if err != nil {
+			t.Fatalf("Expected a PNG, got %v", err)
+		}
+
+		bounds := image.Bounds()
+		if bounds.Dx() != bounds.Dy() || bounds.Dx()%8 != 0 {
+			t.Errorf("Expected a square of modules of 8 pixels, got %v", bounds)
+		}
+
+		// The finder in the corner, past the quiet zone of 4 modules
+		dark := color.GrayModel.Convert(image.At(4*8, 4*8)).(color.Gray).Y == 0
+		light := color.GrayModel.Convert(image.At(5*8, 5*8)).(color.Gray).Y == 255
+		quiet := color.GrayModel.Convert(image.At(0, 0)).(color.Gray).Y == 255
+
+		if !dark || !light || !quiet {
+			t.Errorf("Expected the finder pattern in the corner")
+		}
+	})
+
+	t.Run("View unknown charge", func(t *testing.T) {
+		req := Get(t, "/pix/UNKNOWN")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %v, got %v", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Pay without PIX account", func(t *testing.T) {
+		db.Model(&models.Company{}).Where("id = 1").Update("pix_account_id", nil)
+		defer db.Model(&models.Company{}).Where("id = 1").Update("pix_account_id", bank.ID)
+
+		req := Post(t, "/pix/"+dynamicTxID+"/payment", map[string]interface{}{"Value": 500})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Company.PixAccountID"] != api.ErrPixAccountMissing.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrPixAccountMissing, w.Body.String())
+		}
+	})
+
+	t.Run("Pay a different value", func(t *testing.T) {
+		req := Post(t, "/pix/SALE000001000000000000001/payment", map[string]interface{}{"Value": 200})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Value"] != api.ErrPixValueMismatch.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrPixValueMismatch, w.Body.String())
+		}
+
+		if balance(bank) != 0 || balance(receivables) != 0 {
+			t.Errorf("Expected nothing posted, got balances %v and %v", balance(bank), balance(receivables))
+		}
+	})
+
+	t.Run("Pay", func(t *testing.T) {
+		req := Post(t, "/pix/SALE000001000000000000001/payment", map[string]interface{}{
+			"Value":      180,
+			"EndToEndID": "E00000000202503101200abcdef12345",
+			"PaidAt":     "2025-03-10T12:00:00Z",
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var charge *models.PixCharge
+		json.Unmarshal(w.Body.Bytes(), &charge)
+
+		if charge.PaidAt == nil || charge.PaidValue != 180 || charge.EndToEndID != "E00000000202503101200abcdef12345" {
+			t.Errorf("Expected the payment to be registered, got %v", w.Body.String())
+		}
+
+		if charge.Entry == nil || len(charge.Entry.Transactions) != 2 {
+			t.Fatalf("Expected the payment to be posted, got %v", w.Body.String())
+		}
+
+		if balance(bank) != 180 || balance(receivables) != -180 {
+			t.Errorf("Expected balances %v and %v, got %v and %v", 180, -180, balance(bank), balance(receivables))
+		}
+
+		// The sale keeps posting to receivables, the payment settling them
+		var settled *models.Sale
+		db.First(&settled, sale.ID)
+
+		if settled.Paid || settled.PaymentAccountID != nil {
+			t.Errorf("Expected the sale to stay receivable, got %v %v", settled.Paid, settled.PaymentAccountID)
+		}
+	})
+
+	t.Run("Pay for a document paid otherwise", func(t *testing.T) {
+		db.Model(performed).Updates(map[string]interface{}{"paid": true, "payment_account_id": bank.ID})
+
+		req := Post(t, "/pix/"+dynamicTxID+"/payment", map[string]interface{}{"Value": 500})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["TxID"] != api.ErrPixNotReceivable.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrPixNotReceivable, w.Body.String())
+		}
+	})
+
+	t.Run("Pay twice", func(t *testing.T) {
+		req := Post(t, "/pix/SALE000001000000000000001/payment", map[string]interface{}{"Value": 180})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["TxID"] != api.ErrPixAlreadyPaid.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrPixAlreadyPaid, w.Body.String())
+		}
+
+		if balance(bank) != 180 {
+			t.Errorf("Expected balance %v, got %v", 180, balance(bank))
+		}
+	})
+}
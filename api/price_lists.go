@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterPriceListEndpoints(router *gin.Engine) {
+	group := router.Group("/price-lists")
+
+	group.POST("", createPriceList)
+	group.GET("", listPriceLists)
+	group.GET("/:id", viewPriceList)
+	group.PUT("/:id", updatePriceList)
+	group.DELETE("/:id", deletePriceList)
+}
+
+func createPriceList(context *gin.Context) {
+	var priceList *models.PriceList
+	if err := context.ShouldBindJSON(&priceList); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	priceList.CompanyID = context.Value("CompanyID").(uint)
+
+	if db.Create(&priceList).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Preload("Prices.Product").First(&priceList)
+	context.JSON(http.StatusOK, priceList)
+}
+
+func listPriceLists(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var priceLists []*models.PriceList
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Find(&priceLists).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, priceLists)
+}
+
+func viewPriceList(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var priceList *models.PriceList
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Prices.Product")
+	if tx.First(&priceList, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, priceList)
+}
+
+func updatePriceList(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var priceList *models.PriceList
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Prices")
+	if tx.First(&priceList, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	prices := priceList.Prices
+	priceList.Prices = []*models.Price{}
+
+	if err := context.ShouldBindJSON(&priceList); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	// Prices are replaced as a whole
+	for _, price := range prices {
+		db.Unscoped().Delete(price)
+	}
+
+	if db.Save(&priceList).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Preload("Prices.Product").First(&priceList)
+	context.JSON(http.StatusOK, priceList)
+}
+
+func deletePriceList(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&models.PriceList{}, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if db.Delete(&models.PriceList{}, id).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
@@ -0,0 +1,231 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+)
+
+func TestPriceList(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Component{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.StockReservation{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+	db.AutoMigrate(&models.PriceList{})
+	db.AutoMigrate(&models.Price{})
+	db.AutoMigrate(&models.Customer{})
+	db.AutoMigrate(&models.Sale{})
+	db.AutoMigrate(&models.Item{})
+	db.AutoMigrate(&models.Serial{})
+
+	t.Cleanup(database.Cleanup)
+
+	// Stock isn't what's under test here
+	db.Create(&models.Company{Name: "Testing Company", NegativeStock: models.AllowNegativeStock})
+
+	cash := &models.Account{Name: "Cash", Type: models.Asset, CompanyID: 1}
+	db.Create(cash)
+
+	inventory := &models.Account{Name: "Inventory", Type: models.Asset, CompanyID: 1}
+	db.Create(inventory)
+
+	db.Create(&models.Product{Name: "Coffee", Price: 20, InventoryAccountID: inventory.ID, CompanyID: 1})
+	db.Create(&models.Product{Name: "Tea", Price: 15, InventoryAccountID: inventory.ID, CompanyID: 1})
+
+	router := api.GetRouter()
+
+	t.Run("Create", func(t *testing.T) {
+		req := Post(t, "/price-lists", map[string]interface{}{
+			"Name": "Wholesale",
+			"Prices": []map[string]interface{}{
+				{"ProductID": 1, "Value": 16},
+				{"ProductID": 1, "Value": 14, "MinQty": 10},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var priceList *models.PriceList
+		if err := json.Unmarshal(w.Body.Bytes(), &priceList); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(priceList.Prices) != 2 {
+			t.Errorf("Expected %v prices, got %v", 2, len(priceList.Prices))
+		}
+	})
+
+	t.Run("Create without price", func(t *testing.T) {
+		req := Post(t, "/price-lists", map[string]interface{}{
+			"Name": "Retail",
+			"Prices": []map[string]interface{}{
+				{"ProductID": 1},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	wholesale := uint(1)
+	db.Create(&models.Customer{Name: "Wholesaler", PriceListID: &wholesale, CompanyID: 1})
+	db.Create(&models.Customer{Name: "Walk-in", CompanyID: 1})
+
+	sell := func(customerID uint, item map[string]interface{}) *models.Sale {
+		req := Post(t, "/sales", map[string]interface{}{
+			"Paid":             true,
+			"CustomerID":       customerID,
+			"PaymentAccountID": cash.ID,
+			"Items":            []map[string]interface{}{item},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var sale *models.Sale
+		if err := json.Unmarshal(w.Body.Bytes(), &sale); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+		return sale
+	}
+
+	t.Run("Sell from the customer's price list", func(t *testing.T) {
+		for qty, expected := range map[float64]float64{5: 16, 10: 14, 20: 14} {
+			sale := sell(1, map[string]interface{}{"Qty": qty, "ProductID": 1})
+
+			if sale.Items[0].Price != expected {
+				t.Errorf("Expected price %v for %v units, got %v", expected, qty, sale.Items[0].Price)
+			}
+		}
+	})
+
+	t.Run("Sell product missing from the price list", func(t *testing.T) {
+		sale := sell(1, map[string]interface{}{"Qty": 1, "ProductID": 2})
+
+		if sale.Items[0].Price != 15 {
+			t.Errorf("Expected price %v, got %v", 15, sale.Items[0].Price)
+		}
+	})
+
+	t.Run("Sell to customer without price list", func(t *testing.T) {
+		sale := sell(2, map[string]interface{}{"Qty": 10, "ProductID": 1})
+
+		if sale.Items[0].Price != 20 {
+			t.Errorf("Expected price %v, got %v", 20, sale.Items[0].Price)
+		}
+	})
+
+	t.Run("Sell with given price", func(t *testing.T) {
+		sale := sell(1, map[string]interface{}{"Qty": 10, "Price": 18, "ProductID": 1})
+
+		if sale.Items[0].Price != 18 {
+			t.Errorf("Expected price %v, got %v", 18, sale.Items[0].Price)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		req := Put(t, "/price-lists/1", map[string]interface{}{
+			"Name":       "Wholesale",
+			"ValidUntil": time.Now().AddDate(0, 0, -1),
+			"Prices": []map[string]interface{}{
+				{"ProductID": 1, "Value": 15},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var priceList *models.PriceList
+		if err := json.Unmarshal(w.Body.Bytes(), &priceList); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(priceList.Prices) != 1 {
+			t.Errorf("Expected %v price, got %v", 1, len(priceList.Prices))
+		}
+
+		// The list is no longer valid
+		sale := sell(1, map[string]interface{}{"Qty": 1, "ProductID": 1})
+
+		if sale.Items[0].Price != 20 {
+			t.Errorf("Expected price %v, got %v", 20, sale.Items[0].Price)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := Get(t, "/price-lists")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var priceLists []*models.PriceList
+		if err := json.Unmarshal(w.Body.Bytes(), &priceLists); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(priceLists) != 1 {
+			t.Errorf("Expected %v price list, got %v", 1, len(priceLists))
+		}
+	})
+
+	t.Run("Get from another company", func(t *testing.T) {
+		req := Get(t, "/price-lists/1")
+		req.Header.Set("CompanyID", "2")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %v, got %v", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := Delete(t, "/price-lists/1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected status %v, got %v", http.StatusNoContent, w.Code)
+		}
+	})
+}
@@ -2,17 +2,30 @@ package api
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"example.com/accounting/database"
 	"example.com/accounting/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 var (
 	ErrRevenueAccountMissing    = errors.New("Revenue account is required")
 	ErrCostOfSaleAccountMissing = errors.New("Cost of sale account is required")
+	ErrKitContainsItself        = errors.New("A kit can't be a component of itself")
+	ErrInvalidDays              = errors.New("Days must be a positive number")
+	ErrNoAttributes             = errors.New("Product has no attributes to vary")
+	ErrOptionsMismatch          = errors.New("Options must match the product attributes")
+	ErrVariantExists            = errors.New("A variant with these options already exists")
+	ErrAttributesInUse          = errors.New("Attributes can't change while the product has variants")
+	ErrSKUExists                = errors.New("SKU is already used by another product")
+	ErrGTINExists               = errors.New("GTIN is already used by another product")
+	ErrBarcodeMissing           = errors.New("Barcode is required")
 )
 
 func RegisterProductEndpoints(router *gin.Engine) {
@@ -20,9 +33,13 @@ func RegisterProductEndpoints(router *gin.Engine) {
 
 	group.POST("", createProduct)
 	group.GET("", listProducts)
+	group.GET("/reorder", listReorder)
+	group.GET("/expiring", listExpiring)
+	group.GET("/lookup", lookupProduct)
 	group.GET("/:id", viewProduct)
 	group.PUT("/:id", updateProduct)
 	group.DELETE("/:id", deleteProduct)
+	group.POST("/:id/variants", createVariant)
 }
 
 func createProduct(context *gin.Context) {
@@ -40,12 +57,30 @@ func createProduct(context *gin.Context) {
 
 	product.CompanyID = context.Value("CompanyID").(uint)
 
+	// Variants are created from their parent
+	product.ParentID = nil
+	product.Options = nil
+	product.Variants = nil
+
+	if idx := kitCycle(db, product.ID, product.Components); idx >= 0 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			fmt.Sprintf("Components.%d.ComponentID", idx): ErrKitContainsItself.Error(),
+		})
+		return
+	}
+
+	if errs := checkProductCodes(db, product); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
 	if db.Create(&product).Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
 	}
 
-	tx := db.Joins("InventoryAccount").Joins("Vendor")
+	tx := db.Joins("InventoryAccount").Joins("Vendor").Preload("Components.Component")
+	tx = tx.Preload("Attributes").Preload("Options").Preload("Variants.Options")
 	tx = tx.Joins("RevenueAccount").Joins("CostOfSaleAccount").First(&product)
 
 	context.JSON(http.StatusOK, product)
@@ -61,18 +96,163 @@ func listProducts(context *gin.Context) {
 	var products []*models.Product
 	companyID := context.Value("CompanyID").(uint)
 
-	tx := db.Scopes(models.FromCompany(companyID))
-	tx = tx.Joins("InventoryAccount").Joins("Vendor")
-	tx = tx.Joins("RevenueAccount").Joins("CostOfSaleAccount")
+	// Variants are listed under their parent, which holds their total stock
+	tx := db.Scopes(models.FromCompany(companyID)).Where("products.parent_id IS NULL")
+	tx = tx.Joins("InventoryAccount").Joins("Vendor").Preload("Components")
+	tx = tx.Preload("Attributes").Preload("Variants.Options").Preload("Variants.StockEntries.StockUsages")
+	tx = tx.Preload("StockEntries.StockUsages").Joins("RevenueAccount").Joins("CostOfSaleAccount")
 
 	if tx.Find(&products).Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
 	}
 
+	for _, product := range products {
+		product.Stock = product.TotalInventory()
+		for _, variant := range product.Variants {
+			variant.Stock = variant.Inventory()
+		}
+	}
+
 	context.JSON(http.StatusOK, products)
 }
 
+type reorder struct {
+	Product      *models.Product
+	Inventory    float64
+	SuggestedQty float64
+}
+
+func listReorder(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var products []*models.Product
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Where("min_stock > 0")
+	tx = tx.Joins("Vendor").Preload("StockEntries.StockUsages")
+
+	if tx.Find(&products).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	reorders := []*reorder{}
+	for _, product := range products {
+		if product.BelowMinimum() {
+			reorders = append(reorders, &reorder{
+				Product:      product,
+				Inventory:    product.Inventory(),
+				SuggestedQty: product.SuggestedPurchase(),
+			})
+		}
+	}
+
+	context.JSON(http.StatusOK, reorders)
+}
+
+type expiringLot struct {
+	Product    *models.Product
+	Lot        string
+	ExpiryDate time.Time
+	Stock      float64
+	Expired    bool
+}
+
+// listExpiring lists the lots in stock expiring in the next days, 30 unless
+// given, along the ones already expired.
+func listExpiring(context *gin.Context) {
+	days, err := strconv.Atoi(context.DefaultQuery("days", "30"))
+	if err != nil || days < 0 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"days": ErrInvalidDays.Error(),
+		})
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var entries []*models.StockEntry
+	companyID := context.Value("CompanyID").(uint)
+
+	now := time.Now()
+	limit := now.AddDate(0, 0, days)
+
+	tx := db.Joins("Product").Preload("StockUsages")
+	tx = tx.Where("Product.company_id = ?", companyID)
+	tx = tx.Where("stock_entries.expiry_date <= ?", limit).Order("stock_entries.expiry_date")
+
+	if tx.Find(&entries).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	lots := []*expiringLot{}
+	for _, entry := range entries {
+		if entry.Stock() > 0 {
+			lots = append(lots, &expiringLot{
+				Product:    entry.Product,
+				Lot:        entry.Lot,
+				ExpiryDate: *entry.ExpiryDate,
+				Stock:      entry.Stock(),
+				Expired:    entry.Expired(now),
+			})
+		}
+	}
+
+	context.JSON(http.StatusOK, lots)
+}
+
+// lookupProduct finds the product with the given barcode, which is either
+// its GTIN or its SKU, for scanners at the point of sale.
+func lookupProduct(context *gin.Context) {
+	barcode := context.Query("barcode")
+	if barcode == "" {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"barcode": ErrBarcodeMissing.Error(),
+		})
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var product *models.Product
+	companyID := context.Value("CompanyID").(uint)
+
+	// GTINs are looked up first, as SKUs are free form and could collide
+	// with another product's GTIN
+	found := false
+	for _, column := range []string{"products.gtin", "products.sku"} {
+		tx := db.Scopes(models.FromCompany(companyID)).Where(column+" = ?", barcode)
+		tx = tx.Joins("InventoryAccount").Joins("Vendor").Preload("Options")
+		tx = tx.Joins("RevenueAccount").Joins("CostOfSaleAccount")
+
+		if tx.First(&product).Error == nil {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, product)
+}
+
 func viewProduct(context *gin.Context) {
 	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
 	if err != nil {
@@ -90,7 +270,8 @@ func viewProduct(context *gin.Context) {
 	companyID := context.Value("CompanyID").(uint)
 
 	tx := db.Scopes(models.FromCompany(companyID))
-	tx = db.Joins("InventoryAccount").Joins("Vendor")
+	tx = db.Joins("InventoryAccount").Joins("Vendor").Preload("Components.Component")
+	tx = tx.Preload("Attributes").Preload("Options").Preload("Variants.Options")
 	tx = tx.Joins("RevenueAccount").Joins("CostOfSaleAccount")
 
 	if tx.First(&product, id).Error != nil {
@@ -117,22 +298,87 @@ func updateProduct(context *gin.Context) {
 	var product models.Product
 	companyID := context.Value("CompanyID").(uint)
 
-	if db.Scopes(models.FromCompany(companyID)).First(&product, id).Error != nil {
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Components")
+	tx = tx.Preload("Attributes").Preload("Options").Preload("Variants").Preload("Parent")
+	if tx.First(&product, id).Error != nil {
 		context.Status(http.StatusNotFound)
 		return
 	}
 
+	components := product.Components
+	product.Components = []*models.Component{}
+
+	attributes := product.Attributes
+	product.Attributes = nil
+
+	parent, variants := product.Parent, product.Variants
+	parentID, options := product.ParentID, product.Options
+
 	if err := context.ShouldBindJSON(&product); err != nil {
 		context.JSON(http.StatusBadRequest, Errors(err))
 		return
 	}
 
+	// Options are set when the variant is created, and variants are
+	// updated on their own
+	product.ParentID, product.Options = parentID, options
+	product.Parent, product.Variants = nil, nil
+
+	// Attributes are kept unless given, and can only be replaced while no
+	// variants depend on them
+	changed := product.Attributes != nil && !sameAttributes(attributes, product.Attributes)
+	if !changed {
+		product.Attributes = attributes
+	} else if len(variants) > 0 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Attributes": ErrAttributesInUse.Error(),
+		})
+		return
+	}
+
+	// Variants share the accounts of their parent
+	if parent != nil {
+		product.RevenueAccountID = parent.RevenueAccountID
+		product.CostOfSaleAccountID = parent.CostOfSaleAccountID
+		product.InventoryAccountID = parent.InventoryAccountID
+	}
+
+	if idx := kitCycle(db, product.ID, product.Components); idx >= 0 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			fmt.Sprintf("Components.%d.ComponentID", idx): ErrKitContainsItself.Error(),
+		})
+		return
+	}
+
+	if errs := checkProductCodes(db, &product); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	// The bill of materials is replaced as a whole
+	for _, component := range components {
+		db.Unscoped().Delete(component)
+	}
+
+	if changed {
+		for _, attribute := range attributes {
+			db.Unscoped().Delete(attribute)
+		}
+	}
+
 	if db.Save(&product).Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
 	}
 
-	tx := db.Joins("InventoryAccount").Joins("Vendor")
+	db.Model(&models.Product{}).Where("parent_id = ?", product.ID).Updates(map[string]interface{}{
+		"revenue_account_id":      product.RevenueAccountID,
+		"cost_of_sale_account_id": product.CostOfSaleAccountID,
+		"inventory_account_id":    product.InventoryAccountID,
+	})
+
+	tx = db.Joins("InventoryAccount").Joins("Vendor").Preload("Components.Component")
+	tx = tx.Preload("Attributes").Preload("Options").Preload("Variants.Options")
 	tx = tx.Joins("RevenueAccount").Joins("CostOfSaleAccount").First(&product)
 
 	context.JSON(http.StatusOK, product)
@@ -164,5 +410,207 @@ func deleteProduct(context *gin.Context) {
 		return
 	}
 
+	db.Where("parent_id = ?", id).Delete(&models.Product{})
+
 	context.Status(http.StatusNoContent)
 }
+
+type variant struct {
+	Name    string
+	SKU     string
+	GTIN    string           `binding:"omitempty,gtin"`
+	Price   float64          `binding:"min=0"`
+	Options []*models.Option `binding:"required,min=1,dive"`
+}
+
+// createVariant creates a variant of the product, which shares its accounts
+// and units but has its own stock, price and SKU. The name and price default
+// to the parent's.
+func createVariant(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	var request *variant
+	if err := context.ShouldBindJSON(&request); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var parent *models.Product
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Attributes").Preload("Variants.Options")
+	if tx.First(&parent, id).Error != nil || parent.IsVariant() {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if len(parent.Attributes) == 0 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"error": ErrNoAttributes.Error(),
+		})
+		return
+	}
+
+	values, ok := optionValues(parent.Attributes, request.Options)
+	if !ok {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Options": ErrOptionsMismatch.Error(),
+		})
+		return
+	}
+
+	for _, existing := range parent.Variants {
+		if other, _ := optionValues(parent.Attributes, existing.Options); other == values {
+			context.JSON(http.StatusBadRequest, gin.H{
+				"Options": ErrVariantExists.Error(),
+			})
+			return
+		}
+	}
+
+	product := &models.Product{
+		Name:                request.Name,
+		SKU:                 request.SKU,
+		GTIN:                request.GTIN,
+		Price:               request.Price,
+		Purchasable:         parent.Purchasable,
+		Serialized:          parent.Serialized,
+		StockUnit:           parent.StockUnit,
+		PurchaseUnit:        parent.PurchaseUnit,
+		PurchaseFactor:      parent.PurchaseFactor,
+		SaleUnit:            parent.SaleUnit,
+		SaleFactor:          parent.SaleFactor,
+		RevenueAccountID:    parent.RevenueAccountID,
+		CostOfSaleAccountID: parent.CostOfSaleAccountID,
+		InventoryAccountID:  parent.InventoryAccountID,
+		VendorID:            parent.VendorID,
+		ParentID:            &parent.ID,
+		Options:             request.Options,
+		CompanyID:           companyID,
+	}
+
+	if product.Name == "" {
+		product.Name = fmt.Sprintf("%s - %s", parent.Name, values)
+	}
+
+	if product.Price == 0 {
+		product.Price = parent.Price
+	}
+
+	if errs := checkProductCodes(db, product); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	if db.Create(&product).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	tx = db.Joins("InventoryAccount").Joins("Vendor").Preload("Options")
+	tx = tx.Joins("RevenueAccount").Joins("CostOfSaleAccount").First(&product)
+
+	context.JSON(http.StatusOK, product)
+}
+
+// optionValues lists the values of the options in the order of the
+// attributes, and tells whether there's exactly one option per attribute.
+func optionValues(attributes []*models.Attribute, options []*models.Option) (string, bool) {
+	byName := map[string]string{}
+	for _, option := range options {
+		byName[option.Name] = option.Value
+	}
+
+	if len(byName) != len(options) || len(options) != len(attributes) {
+		return "", false
+	}
+
+	values := make([]string, 0, len(attributes))
+	for _, attribute := range attributes {
+		value, ok := byName[attribute.Name]
+		if !ok {
+			return "", false
+		}
+		values = append(values, value)
+	}
+
+	return strings.Join(values, ", "), true
+}
+
+// kitCycle returns the index of the first component containing the kit,
+// directly or through components of its own, or -1 when none does. Stock
+// checks and usages walk the components down, so kits can't contain
+// themselves.
+func kitCycle(db *gorm.DB, kitID uint, components []*models.Component) int {
+	for idx, component := range components {
+		visited := map[uint]bool{}
+		pending := []uint{component.ComponentID}
+
+		for len(pending) > 0 {
+			productID := pending[0]
+			pending = pending[1:]
+
+			if productID == kitID {
+				return idx
+			}
+
+			if visited[productID] {
+				continue
+			}
+			visited[productID] = true
+
+			var ids []uint
+			db.Model(&models.Component{}).Where("product_id = ?", productID).Pluck("component_id", &ids)
+			pending = append(pending, ids...)
+		}
+	}
+	return -1
+}
+
+// checkProductCodes makes sure no other product of the company has the same
+// SKU or GTIN.
+func checkProductCodes(db *gorm.DB, product *models.Product) map[string]string {
+	errs := map[string]string{}
+
+	taken := func(column string, code string) bool {
+		if code == "" {
+			return false
+		}
+
+		tx := db.Scopes(models.FromCompany(product.CompanyID)).Where(column+" = ? AND id <> ?", code, product.ID)
+		return tx.First(&models.Product{}).Error == nil
+	}
+
+	if taken("sku", product.SKU) {
+		errs["SKU"] = ErrSKUExists.Error()
+	}
+
+	if taken("gtin", product.GTIN) {
+		errs["GTIN"] = ErrGTINExists.Error()
+	}
+
+	return errs
+}
+
+func sameAttributes(attributes []*models.Attribute, other []*models.Attribute) bool {
+	if len(attributes) != len(other) {
+		return false
+	}
+
+	for idx, attribute := range attributes {
+		if attribute.Name != other[idx].Name {
+			return false
+		}
+	}
+	return true
+}
@@ -2,6 +2,7 @@ package api_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -19,7 +20,12 @@ func TestProducts(t *testing.T) {
 
 	db.AutoMigrate(&models.Account{})
 	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Component{})
+	db.AutoMigrate(&models.Attribute{})
+	db.AutoMigrate(&models.Option{})
 	db.AutoMigrate(&models.Company{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
 
 	t.Cleanup(database.Cleanup)
 
@@ -561,4 +567,382 @@ func TestProducts(t *testing.T) {
 			t.Errorf("Expected vendor %v, got %v", 1, *prod.VendorID)
 		}
 	})
+
+	t.Run("List reorder", func(t *testing.T) {
+		vendorID := uint(1)
+
+		db.Create(&models.Product{
+			Name:               "Below minimum",
+			Price:              10,
+			MinStock:           50,
+			ReorderQty:         100,
+			InventoryAccountID: 3,
+			VendorID:           &vendorID,
+			CompanyID:          1,
+			StockEntries: []*models.StockEntry{
+				{Qty: 40, Price: 5, StockUsages: []*models.StockUsage{{Qty: 30}}},
+			},
+		})
+
+		db.Create(&models.Product{
+			Name:               "Above minimum",
+			Price:              10,
+			MinStock:           50,
+			ReorderQty:         100,
+			InventoryAccountID: 3,
+			CompanyID:          1,
+			StockEntries: []*models.StockEntry{
+				{Qty: 60, Price: 5},
+			},
+		})
+
+		db.Create(&models.Product{
+			Name:               "Without minimum",
+			Price:              10,
+			InventoryAccountID: 3,
+			CompanyID:          1,
+		})
+
+		req := Get(t, "/products/reorder")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var reorders []struct {
+			Product      *models.Product
+			Inventory    uint
+			SuggestedQty uint
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &reorders); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(reorders) != 1 {
+			t.Fatalf("Expected %v products, got %v", 1, len(reorders))
+		}
+
+		if reorders[0].Product.Name != "Below minimum" {
+			t.Errorf("Expected product %v, got %v", "Below minimum", reorders[0].Product.Name)
+		}
+
+		if reorders[0].Inventory != 10 {
+			t.Errorf("Expected inventory %v, got %v", 10, reorders[0].Inventory)
+		}
+
+		if reorders[0].SuggestedQty != 100 {
+			t.Errorf("Expected suggested qty %v, got %v", 100, reorders[0].SuggestedQty)
+		}
+
+		if reorders[0].Product.Vendor == nil {
+			t.Error("Expected vendor to be retrieved along product")
+		}
+	})
+
+	t.Run("Create kit", func(t *testing.T) {
+		req := Post(t, "/products", map[string]interface{}{
+			"Name":               "Kit",
+			"Price":              50,
+			"InventoryAccountID": 3,
+			"Components": []map[string]interface{}{
+				{"ComponentID": 5, "Qty": 2},
+				{"ComponentID": 6, "Qty": 1},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var kit *models.Product
+		if err := json.Unmarshal(w.Body.Bytes(), &kit); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(kit.Components) != 2 {
+			t.Fatalf("Expected %v components, got %v", 2, len(kit.Components))
+		}
+
+		if kit.Components[0].Component == nil {
+			t.Error("Expected component product to be retrieved")
+		}
+
+		req = Put(t, fmt.Sprintf("/products/%d", kit.ID), map[string]interface{}{
+			"Name":               "Kit",
+			"Price":              50,
+			"InventoryAccountID": 3,
+			"Components": []map[string]interface{}{
+				{"ComponentID": kit.ID, "Qty": 1},
+			},
+		})
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if response["Components.0.ComponentID"] != api.ErrKitContainsItself.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrKitContainsItself.Error(), response["Components.0.ComponentID"])
+		}
+
+		req = Post(t, "/products", map[string]interface{}{
+			"Name":               "Bundle",
+			"Price":              80,
+			"InventoryAccountID": 3,
+			"Components": []map[string]interface{}{
+				{"ComponentID": kit.ID, "Qty": 1},
+			},
+		})
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var bundle *models.Product
+		if err := json.Unmarshal(w.Body.Bytes(), &bundle); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		// The kit would contain itself through the bundle
+		req = Put(t, fmt.Sprintf("/products/%d", kit.ID), map[string]interface{}{
+			"Name":               "Kit",
+			"Price":              50,
+			"InventoryAccountID": 3,
+			"Components": []map[string]interface{}{
+				{"ComponentID": 5, "Qty": 2},
+				{"ComponentID": bundle.ID, "Qty": 1},
+			},
+		})
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		response = map[string]string{}
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if response["Components.1.ComponentID"] != api.ErrKitContainsItself.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrKitContainsItself.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Create variants", func(t *testing.T) {
+		req := Post(t, "/products", map[string]interface{}{
+			"Name":                "T-shirt",
+			"Price":               30,
+			"Purchasable":         true,
+			"RevenueAccountID":    1,
+			"CostOfSaleAccountID": 2,
+			"InventoryAccountID":  3,
+			"Attributes": []map[string]interface{}{
+				{"Name": "Size"},
+				{"Name": "Colour"},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var parent *models.Product
+		if err := json.Unmarshal(w.Body.Bytes(), &parent); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		variant := func(options map[string]string) *httptest.ResponseRecorder {
+			values := []map[string]interface{}{}
+			for name, value := range options {
+				values = append(values, map[string]interface{}{"Name": name, "Value": value})
+			}
+
+			req := Post(t, fmt.Sprintf("/products/%d/variants", parent.ID), map[string]interface{}{
+				"SKU":     "TS-" + options["Size"],
+				"Options": values,
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		w = variant(map[string]string{"Size": "M", "Colour": "Blue"})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var medium *models.Product
+		if err := json.Unmarshal(w.Body.Bytes(), &medium); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if medium.Name != "T-shirt - M, Blue" || medium.Price != 30 || medium.SKU != "TS-M" {
+			t.Errorf("Expected variant to be named after its options, got %v", medium)
+		}
+
+		if medium.ParentID == nil || *medium.ParentID != parent.ID || medium.InventoryAccountID != 3 {
+			t.Errorf("Expected variant to share the accounts of its parent, got %v", medium)
+		}
+
+		w = variant(map[string]string{"Size": "L", "Colour": "Blue"})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		errors := map[string]error{
+			"Duplicated variant": api.ErrVariantExists,
+			"Missing attribute":  api.ErrOptionsMismatch,
+		}
+
+		for name, options := range map[string]map[string]string{
+			"Duplicated variant": {"Size": "M", "Colour": "Blue"},
+			"Missing attribute":  {"Size": "S"},
+		} {
+			w = variant(options)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%v: expected status %v, got %v", name, http.StatusBadRequest, w.Code)
+			}
+
+			var response map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Error("Failed parsing JSON", err)
+			}
+
+			if response["Options"] != errors[name].Error() {
+				t.Errorf("%v: expected error %v, got %v", name, errors[name].Error(), response["Options"])
+			}
+		}
+
+		db.Create(&models.StockEntry{Qty: 5, Price: 10, ProductID: medium.ID})
+		db.Create(&models.StockEntry{Qty: 3, Price: 10, ProductID: medium.ID + 1})
+
+		req = Get(t, "/products")
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var products []*models.Product
+		if err := json.Unmarshal(w.Body.Bytes(), &products); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		for _, product := range products {
+			if product.IsVariant() {
+				t.Errorf("Expected variants to be listed under their parent, got %v", product.Name)
+			}
+
+			if product.ID == parent.ID && (product.Stock != 8 || len(product.Variants) != 2) {
+				t.Errorf("Expected stock %v over %v variants, got %v over %v", 8, 2, product.Stock, len(product.Variants))
+			}
+		}
+
+		req = Put(t, fmt.Sprintf("/products/%d", parent.ID), map[string]interface{}{
+			"Name":                "T-shirt",
+			"Price":               30,
+			"Purchasable":         true,
+			"RevenueAccountID":    1,
+			"CostOfSaleAccountID": 2,
+			"InventoryAccountID":  3,
+			"Attributes": []map[string]interface{}{
+				{"Name": "Size"},
+			},
+		})
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Lookup by barcode", func(t *testing.T) {
+		create := func(sku string, gtin string) *httptest.ResponseRecorder {
+			req := Post(t, "/products", map[string]interface{}{
+				"Name":               "Coffee",
+				"Price":              12,
+				"SKU":                sku,
+				"GTIN":               gtin,
+				"InventoryAccountID": 3,
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		if w := create("COF-1", "7891000000015"); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v for a wrong check digit, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if w := create("COF-1", "7891000000014"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		w := create("COF-1", "7891000000014")
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if response["SKU"] != api.ErrSKUExists.Error() || response["GTIN"] != api.ErrGTINExists.Error() {
+			t.Errorf("Expected SKU and GTIN to be taken, got %v", response)
+		}
+
+		for _, barcode := range []string{"7891000000014", "COF-1"} {
+			req := Get(t, "/products/lookup?barcode="+barcode)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+			}
+
+			var product *models.Product
+			if err := json.Unmarshal(w.Body.Bytes(), &product); err != nil {
+				t.Error("Failed parsing JSON", err)
+			}
+
+			if product.Name != "Coffee" {
+				t.Errorf("Expected product %v, got %v", "Coffee", product.Name)
+			}
+		}
+
+		req := Get(t, "/products/lookup?barcode=0000")
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %v, got %v", http.StatusNotFound, w.Code)
+		}
+	})
 }
@@ -0,0 +1,296 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/accounting/database"
+	"example.com/accounting/events"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrPurchaseOrderReceived = errors.New("Purchase order was already received")
+	ErrLineNotInOrder        = errors.New("Line does not belong to the purchase order")
+	ErrReceivingMoreThanOpen = errors.New("Received quantity is greater than the open quantity")
+)
+
+func RegisterPurchaseOrderEndpoints(router *gin.Engine) {
+	group := router.Group("/purchase-orders")
+
+	group.POST("", createPurchaseOrder)
+	group.GET("", listPurchaseOrders)
+	group.GET("/:id", viewPurchaseOrder)
+	group.PUT("/:id", updatePurchaseOrder)
+	group.DELETE("/:id", deletePurchaseOrder)
+	group.POST("/:id/receive", receivePurchaseOrder)
+}
+
+type receiving struct {
+	InvoiceNumber    string
+	Paid             bool
+	PaymentDate      time.Time       `binding:"required_if=Paid true"`
+	PayableAccountID *uint           `binding:"required_if=Paid false"`
+	PaymentAccountID *uint           `binding:"required_if=Paid true"`
+	Lines            []*receivedLine `binding:"min=1,required,dive,required"`
+}
+
+type receivedLine struct {
+	LineID     uint    `binding:"required"`
+	Qty        float64 `binding:"required,gt=0"`
+	Lot        string
+	ExpiryDate *time.Time
+	Serials    []*models.Serial `binding:"dive"`
+}
+
+func createPurchaseOrder(context *gin.Context) {
+	var order *models.PurchaseOrder
+	if err := context.ShouldBindJSON(&order); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	order.CompanyID = context.Value("CompanyID").(uint)
+
+	if db.Create(&order).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("Vendor").Preload("Lines.Product").Preload("Lines.Receipts").First(&order)
+	context.JSON(http.StatusOK, order)
+}
+
+func listPurchaseOrders(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var orders []*models.PurchaseOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID))
+	tx = tx.Joins("Vendor").Preload("Lines.Product").Preload("Lines.Receipts")
+
+	if tx.Find(&orders).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, orders)
+}
+
+func viewPurchaseOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.PurchaseOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID))
+	tx = tx.Joins("Vendor").Preload("Lines.Product").Preload("Lines.Receipts")
+
+	if tx.First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, order)
+}
+
+func updatePurchaseOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.PurchaseOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Lines.Receipts")
+	if tx.First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	// Lines can only be replaced while nothing was received
+	for _, line := range order.Lines {
+		if line.ReceivedQty() > 0 {
+			context.JSON(http.StatusBadRequest, gin.H{
+				"error": ErrPurchaseOrderReceived.Error(),
+			})
+			return
+		}
+	}
+
+	lines := order.Lines
+	lineIDs := []uint{}
+	for _, line := range lines {
+		lineIDs = append(lineIDs, line.ID)
+	}
+	order.Lines = []*models.PurchaseOrderLine{}
+
+	if err := context.ShouldBindJSON(&order); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db.Unscoped().Delete(&lines, lineIDs)
+
+	if db.Save(&order).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("Vendor").Preload("Lines.Product").Preload("Lines.Receipts").First(&order)
+	context.JSON(http.StatusOK, order)
+}
+
+func deletePurchaseOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.PurchaseOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	// Purchases already received are kept, only the order goes away
+	if db.Unscoped().Select("Lines").Delete(&order).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
+
+func receivePurchaseOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.PurchaseOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Lines.Receipts")
+	if tx.First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	var received *receiving
+	if err := context.ShouldBindJSON(&received); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	lines := map[uint]*models.PurchaseOrderLine{}
+	for _, line := range order.Lines {
+		lines[line.ID] = line
+	}
+
+	purchase := &models.Purchase{
+		InvoiceNumber:    received.InvoiceNumber,
+		Paid:             received.Paid,
+		PaymentDate:      received.PaymentDate,
+		PayableAccountID: received.PayableAccountID,
+		PaymentAccountID: received.PaymentAccountID,
+		VendorID:         order.VendorID,
+		CompanyID:        order.CompanyID,
+	}
+	quantities := map[uint]float64{}
+
+	for idx, receivedLine := range received.Lines {
+		line, ok := lines[receivedLine.LineID]
+		if !ok {
+			context.JSON(http.StatusBadRequest, gin.H{
+				fmt.Sprintf("Lines.%d.LineID", idx): ErrLineNotInOrder.Error(),
+			})
+			return
+		}
+
+		quantities[line.ID] = models.RoundQty(quantities[line.ID] + receivedLine.Qty)
+
+		if quantities[line.ID] > line.OpenQty() {
+			context.JSON(http.StatusBadRequest, gin.H{
+				fmt.Sprintf("Lines.%d.Qty", idx): ErrReceivingMoreThanOpen.Error(),
+			})
+			return
+		}
+
+		purchase.Lines = append(purchase.Lines, &models.PurchaseLine{
+			Qty:                 receivedLine.Qty,
+			Price:               line.Price,
+			Lot:                 receivedLine.Lot,
+			ExpiryDate:          receivedLine.ExpiryDate,
+			Serials:             receivedLine.Serials,
+			ProductID:           line.ProductID,
+			PurchaseOrderLineID: &line.ID,
+		})
+	}
+
+	if errs, _ := checkPurchaseSerials(db, purchase, nil); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	chargePurchaseCredits(db, purchase)
+
+	if db.Create(&purchase).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	events.Dispatch(events.PurchaseCreated, purchase)
+
+	db.Joins("Vendor").Preload("Lines.Product").Preload("Lines.Receipts").First(&order)
+	context.JSON(http.StatusOK, order)
+}
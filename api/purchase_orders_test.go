@@ -0,0 +1,407 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/events"
+	"example.com/accounting/models"
+)
+
+type purchaseOrderLine struct {
+	ID          uint
+	Qty         uint
+	Price       float64
+	ProductID   uint
+	ReceivedQty uint
+	OpenQty     uint
+}
+
+type purchaseOrder struct {
+	ID       uint
+	VendorID uint
+	Vendor   *models.Vendor
+	Lines    []*purchaseOrderLine
+}
+
+func TestPurchaseOrders(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Company{})
+	db.AutoMigrate(&models.Account{})
+	db.AutoMigrate(&models.Vendor{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Purchase{}, &models.PurchaseLine{})
+	db.AutoMigrate(&models.PurchaseOrder{})
+	db.AutoMigrate(&models.PurchaseOrderLine{})
+	db.AutoMigrate(&models.Serial{})
+
+	t.Cleanup(database.Cleanup)
+
+	var received []*models.Purchase
+	events.Handle(events.PurchaseCreated, func(data interface{}) {
+		received = append(received, data.(*models.Purchase))
+	})
+
+	db.Create(&models.Company{Name: "Testing Company"})
+	db.Create(&models.Company{Name: "Other company"})
+
+	db.Create(&models.Vendor{Name: "Vendor", Cnpj: "70.463.497/0001-60", CompanyID: 1})
+
+	inventory := &models.Account{Name: "Inventory", Type: models.Asset, CompanyID: 1}
+	db.Create(inventory)
+
+	payables := &models.Account{Name: "Payables", Type: models.Liability, CompanyID: 1}
+	db.Create(payables)
+
+	db.Create(&models.Product{Name: "Screws", Price: 1, InventoryAccountID: inventory.ID, CompanyID: 1})
+	db.Create(&models.Product{Name: "Nails", Price: 2, InventoryAccountID: inventory.ID, CompanyID: 1})
+
+	router := api.GetRouter()
+
+	t.Run("Create", func(t *testing.T) {
+		req := Post(t, "/purchase-orders", map[string]interface{}{
+			"VendorID":     1,
+			"ExpectedDate": time.Now().AddDate(0, 0, 7),
+			"Lines": []map[string]interface{}{
+				{"ProductID": 1, "Qty": 100, "Price": 0.5},
+				{"ProductID": 2, "Qty": 50, "Price": 1.5},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var order *purchaseOrder
+		if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if order.ID != 1 {
+			t.Errorf("Expected ID %v, got %v", 1, order.ID)
+		}
+
+		if order.Vendor == nil {
+			t.Error("Expected vendor to be retrieved along order")
+		}
+
+		if len(order.Lines) != 2 {
+			t.Fatalf("Expected %v lines, got %v", 2, len(order.Lines))
+		}
+
+		if order.Lines[0].OpenQty != 100 {
+			t.Errorf("Expected open qty %v, got %v", 100, order.Lines[0].OpenQty)
+		}
+
+		if len(received) != 0 {
+			t.Errorf("Expected no purchases, got %v", len(received))
+		}
+	})
+
+	t.Run("Create without lines", func(t *testing.T) {
+		req := Post(t, "/purchase-orders", map[string]interface{}{
+			"VendorID": 1,
+			"Lines":    []map[string]interface{}{},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Receive partially", func(t *testing.T) {
+		req := Post(t, "/purchase-orders/1/receive", map[string]interface{}{
+			"Paid":             false,
+			"PayableAccountID": payables.ID,
+			"Lines": []map[string]interface{}{
+				{"LineID": 1, "Qty": 60, "Lot": "L2301", "ExpiryDate": "2026-06-30T00:00:00Z"},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var order *purchaseOrder
+		if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if order.Lines[0].ReceivedQty != 60 {
+			t.Errorf("Expected received qty %v, got %v", 60, order.Lines[0].ReceivedQty)
+		}
+
+		if order.Lines[0].OpenQty != 40 {
+			t.Errorf("Expected open qty %v, got %v", 40, order.Lines[0].OpenQty)
+		}
+
+		if order.Lines[1].OpenQty != 50 {
+			t.Errorf("Expected open qty %v, got %v", 50, order.Lines[1].OpenQty)
+		}
+
+		if len(received) != 1 {
+			t.Fatalf("Expected %v purchase, got %v", 1, len(received))
+		}
+
+		purchase := received[0]
+
+		if purchase.VendorID != 1 {
+			t.Errorf("Expected vendor %v, got %v", 1, purchase.VendorID)
+		}
+
+		if len(purchase.Lines) != 1 {
+			t.Fatalf("Expected %v line, got %v", 1, len(purchase.Lines))
+		}
+
+		line := purchase.Lines[0]
+
+		if line.ProductID != 1 || line.Qty != 60 || line.Price != 0.5 {
+			t.Errorf("Expected 60 of product 1 at 0.5, got %v of product %v at %v", line.Qty, line.ProductID, line.Price)
+		}
+
+		expiry := time.Date(2026, 6, 30, 0, 0, 0, 0, time.UTC)
+		if line.Lot != "L2301" || line.ExpiryDate == nil || !line.ExpiryDate.Equal(expiry) {
+			t.Errorf("Expected lot %v expiring on %v, got %v expiring on %v", "L2301", expiry, line.Lot, line.ExpiryDate)
+		}
+
+		if *purchase.PayableAccountID != payables.ID {
+			t.Errorf("Expected payable account %v, got %v", payables.ID, *purchase.PayableAccountID)
+		}
+	})
+
+	t.Run("Receive more than open", func(t *testing.T) {
+		req := Post(t, "/purchase-orders/1/receive", map[string]interface{}{
+			"Paid":             false,
+			"PayableAccountID": payables.ID,
+			"Lines": []map[string]interface{}{
+				{"LineID": 1, "Qty": 30},
+				{"LineID": 1, "Qty": 30},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if response["Lines.1.Qty"] != api.ErrReceivingMoreThanOpen.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrReceivingMoreThanOpen.Error(), response["Lines.1.Qty"])
+		}
+
+		if len(received) != 1 {
+			t.Errorf("Expected %v purchase, got %v", 1, len(received))
+		}
+	})
+
+	t.Run("Receive line from another order", func(t *testing.T) {
+		req := Post(t, "/purchase-orders/1/receive", map[string]interface{}{
+			"Paid":             false,
+			"PayableAccountID": payables.ID,
+			"Lines": []map[string]interface{}{
+				{"LineID": 15, "Qty": 1},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Update received", func(t *testing.T) {
+		req := Put(t, "/purchase-orders/1", map[string]interface{}{
+			"VendorID": 1,
+			"Lines": []map[string]interface{}{
+				{"ProductID": 1, "Qty": 10, "Price": 0.5},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Receive remaining", func(t *testing.T) {
+		req := Post(t, "/purchase-orders/1/receive", map[string]interface{}{
+			"Paid":             false,
+			"PayableAccountID": payables.ID,
+			"Lines": []map[string]interface{}{
+				{"LineID": 1, "Qty": 40},
+				{"LineID": 2, "Qty": 50},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var order *models.PurchaseOrder
+		if db.Preload("Lines.Receipts").First(&order, 1).Error != nil {
+			t.Error("Should retrieve purchase order")
+		}
+
+		if !order.Received() {
+			t.Error("Expected order to be received")
+		}
+
+		if len(received) != 2 {
+			t.Fatalf("Expected %v purchases, got %v", 2, len(received))
+		}
+
+		if len(received[1].Lines) != 2 {
+			t.Errorf("Expected %v lines, got %v", 2, len(received[1].Lines))
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		db.Create(&models.PurchaseOrder{
+			VendorID:  1,
+			CompanyID: 2,
+			Lines:     []*models.PurchaseOrderLine{{ProductID: 1, Qty: 1, Price: 1}},
+		})
+
+		req := Get(t, "/purchase-orders")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var orders []*purchaseOrder
+		if err := json.Unmarshal(w.Body.Bytes(), &orders); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(orders) != 1 {
+			t.Errorf("Expected %v orders, got %v", 1, len(orders))
+		}
+	})
+
+	t.Run("Get from another company", func(t *testing.T) {
+		req := Get(t, "/purchase-orders/2")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %v, got %v", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := Delete(t, "/purchase-orders/1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected status %v, got %v", http.StatusNoContent, w.Code)
+		}
+
+		if db.First(&models.PurchaseOrder{}, 1).Error == nil {
+			t.Error("Should not find purchase order")
+		}
+
+		var count int64
+		db.Model(&models.Purchase{}).Count(&count)
+
+		if count != 2 {
+			t.Errorf("Expected received purchases to be kept, got %v", count)
+		}
+	})
+
+	t.Run("Receive serialized products", func(t *testing.T) {
+		phone := &models.Product{Name: "Phone", Price: 1000, Serialized: true, InventoryAccountID: inventory.ID, CompanyID: 1}
+		db.Create(phone)
+
+		req := Post(t, "/purchase-orders", map[string]interface{}{
+			"VendorID": 1,
+			"Lines": []map[string]interface{}{
+				{"ProductID": phone.ID, "Qty": 2, "Price": 600},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var order *purchaseOrder
+		json.Unmarshal(w.Body.Bytes(), &order)
+
+		receive := func(line map[string]interface{}) *httptest.ResponseRecorder {
+			req := Post(t, fmt.Sprintf("/purchase-orders/%d/receive", order.ID), map[string]interface{}{
+				"PayableAccountID": payables.ID,
+				"Lines":            []map[string]interface{}{line},
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		w = receive(map[string]interface{}{"LineID": order.Lines[0].ID, "Qty": 2})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		var errs map[string]string
+		json.Unmarshal(w.Body.Bytes(), &errs)
+
+		if errs["Lines.0.Serials"] != api.ErrSerialsMismatch.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrSerialsMismatch.Error(), w.Body.String())
+		}
+
+		w = receive(map[string]interface{}{
+			"LineID":  order.Lines[0].ID,
+			"Qty":     2,
+			"Serials": []map[string]interface{}{{"Number": "SN1"}, {"Number": "SN2"}},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var serials []*models.Serial
+		db.Where("product_id = ? AND purchase_line_id IS NOT NULL", phone.ID).Find(&serials)
+
+		if len(serials) != 2 || serials[0].Number != "SN1" || serials[0].CompanyID != 1 {
+			t.Errorf("Expected serials %v and %v received, got %v", "SN1", "SN2", serials)
+		}
+	})
+}
@@ -30,10 +30,21 @@ func CreateStockEntry(data interface{}) {
 	db, _ := database.GetConnection()
 	purchase := data.(*models.Purchase)
 
-	purchase.StockEntry = &models.StockEntry{
-		Price:     purchase.Price,
-		Qty:       purchase.Qty,
-		ProductID: purchase.ProductID,
+	for _, line := range purchase.Lines {
+		var product *models.Product
+		db.First(&product, line.ProductID)
+
+		// Lines are in the purchase unit, stock is kept in the stock unit
+		qty := product.PurchaseToStock(line.Qty)
+
+		// Recoverable taxes aren't part of the cost
+		line.StockEntry = &models.StockEntry{
+			Price:      line.NetSubtotal() / qty,
+			Qty:        qty,
+			Lot:        line.Lot,
+			ExpiryDate: line.ExpiryDate,
+			ProductID:  line.ProductID,
+		}
 	}
 
 	db.Save(&purchase)
@@ -43,45 +54,112 @@ func UpdateStockEntry(data interface{}) {
 	db, _ := database.GetConnection()
 	purchase := data.(*models.Purchase)
 
-	if purchase.StockEntryID != nil {
-		if purchase.StockEntry == nil {
-			db.Find(&models.StockEntry{}).Where("ID", purchase.StockEntryID).First(&purchase.StockEntry)
+	for _, line := range purchase.Lines {
+		if line.StockEntryID != nil && line.StockEntry == nil {
+			db.First(&line.StockEntry, *line.StockEntryID)
+		}
+
+		// Lines added to the purchase get their own stock entry
+		if line.StockEntry == nil {
+			line.StockEntry = &models.StockEntry{}
+		}
+
+		// Keep the landed costs allocated to the entry in its price
+		allocated := 0.0
+		if line.StockEntry.ID != 0 {
+			db.Model(&models.LandedCostAllocation{}).
+				Where("stock_entry_id = ?", line.StockEntry.ID).
+				Select("COALESCE(SUM(value), 0)").
+				Scan(&allocated)
+		}
+
+		var product *models.Product
+		db.First(&product, line.ProductID)
+
+		qty := product.PurchaseToStock(line.Qty)
+
+		line.StockEntry.Qty = qty
+		line.StockEntry.Price = (line.NetSubtotal() + allocated) / qty
+		line.StockEntry.Lot = line.Lot
+		line.StockEntry.ExpiryDate = line.ExpiryDate
+		line.StockEntry.ProductID = line.ProductID
+	}
+
+	db.Save(purchase)
+}
+
+// inventoryTransactions debits each line's net subtotal to the inventory
+// account of its product and its tax credits to the recoverable accounts of
+// the rules that gave them, one transaction per account.
+func inventoryTransactions(purchase *models.Purchase) []*models.Transaction {
+	db, _ := database.GetConnection()
+
+	accounts := []uint{}
+	values := map[uint]float64{}
+
+	debit := func(account uint, value float64) {
+		if _, ok := values[account]; !ok {
+			accounts = append(accounts, account)
 		}
+		values[account] += value
+	}
+
+	for _, line := range purchase.Lines {
+		var product *models.Product
+		db.First(&product, line.ProductID)
 
-		purchase.StockEntry.Qty = purchase.Qty
-		purchase.StockEntry.Price = purchase.Price
-		purchase.StockEntry.ProductID = purchase.ProductID
+		debit(product.InventoryAccountID, line.NetSubtotal())
 
-		db.Save(purchase)
+		for _, tax := range line.Taxes {
+			var rule *models.TaxRule
+			if tax.TaxRuleID != nil {
+				db.Unscoped().First(&rule, *tax.TaxRuleID)
+			}
+
+			// Credits whose rule is gone stay in the inventory
+			if rule == nil || rule.RecoverableAccountID == nil {
+				debit(product.InventoryAccountID, tax.Value)
+			} else {
+				debit(*rule.RecoverableAccountID, tax.Value)
+			}
+		}
 	}
+
+	transactions := []*models.Transaction{}
+	for _, account := range accounts {
+		transactions = append(transactions, &models.Transaction{
+			Value:     values[account],
+			AccountID: account,
+		})
+	}
+
+	return transactions
 }
 
 func CreateAccountingEntry(data interface{}) {
 	db, _ := database.GetConnection()
 	purchase := data.(*models.Purchase)
 
-	var product *models.Product
-	db.First(&product, purchase.ProductID)
-
-	price := purchase.Price * float64(purchase.Qty)
+	price := purchase.Total()
+	transactions := inventoryTransactions(purchase)
 
 	if purchase.Paid {
 		purchase.PaymentEntry = &models.Entry{
 			CompanyID:   purchase.CompanyID,
-			Description: "Purchase of product",
-			Transactions: []*models.Transaction{
-				{Value: price, AccountID: product.InventoryAccountID},
-				{Value: -price, AccountID: *purchase.PaymentAccountID},
-			},
+			Description: "Purchase of products",
+			Transactions: append(transactions, &models.Transaction{
+				Value:     -price,
+				AccountID: *purchase.PaymentAccountID,
+			}),
 		}
 	} else {
 		purchase.PayableEntry = &models.Entry{
 			CompanyID:   purchase.CompanyID,
-			Description: "Purchase of product",
-			Transactions: []*models.Transaction{
-				{Value: price, AccountID: product.InventoryAccountID},
-				{Value: price, AccountID: *purchase.PayableAccountID},
-			},
+			Description: "Purchase of products",
+			Transactions: append(transactions, &models.Transaction{
+				Value:     price,
+				AccountID: *purchase.PayableAccountID,
+			}),
 		}
 	}
 
@@ -92,48 +170,55 @@ func UpdateAccountingEntry(data interface{}) {
 	db, _ := database.GetConnection()
 	purchase := data.(*models.Purchase)
 
-	var product *models.Product
-	db.First(&product, purchase.ProductID)
-
-	price := purchase.Price * float64(purchase.Qty)
+	price := purchase.Total()
 
 	if purchase.PayableEntry != nil {
 		// update existing payable entry
-		purchase.PayableEntry.Transactions[0].AccountID = product.InventoryAccountID
-		purchase.PayableEntry.Transactions[0].Value = price
+		db.Unscoped().Where("entry_id = ?", purchase.PayableEntry.ID).Delete(&models.Transaction{})
 
-		purchase.PayableEntry.Transactions[1].AccountID = *purchase.PayableAccountID
-		purchase.PayableEntry.Transactions[1].Value = price
+		purchase.PayableEntry.Transactions = append(inventoryTransactions(purchase), &models.Transaction{
+			Value:     price,
+			AccountID: *purchase.PayableAccountID,
+		})
 	}
 
 	if purchase.Paid {
+		var transactions []*models.Transaction
+
+		if purchase.PayableEntry != nil {
+			// pays off the payable
+			transactions = []*models.Transaction{
+				{Value: -price, AccountID: *purchase.PayableAccountID},
+				{Value: -price, AccountID: *purchase.PaymentAccountID},
+			}
+		} else {
+			transactions = append(inventoryTransactions(purchase), &models.Transaction{
+				Value:     -price,
+				AccountID: *purchase.PaymentAccountID,
+			})
+		}
+
 		if purchase.PaymentEntry != nil {
 			// update existing payment entry
-			purchase.PaymentEntry.Transactions[0].AccountID = product.InventoryAccountID
-			purchase.PaymentEntry.Transactions[0].Value = price
-
-			purchase.PaymentEntry.Transactions[1].AccountID = *purchase.PaymentAccountID
-			purchase.PaymentEntry.Transactions[1].Value = -price
+			db.Unscoped().Where("entry_id = ?", purchase.PaymentEntry.ID).Delete(&models.Transaction{})
+			purchase.PaymentEntry.Transactions = transactions
 		} else {
 			// create payment entry
 			purchase.PaymentEntry = &models.Entry{
-				CompanyID:   purchase.CompanyID,
-				Description: "Payment of purchase of product",
-				Transactions: []*models.Transaction{
-					{Value: -price, AccountID: *purchase.PayableAccountID},
-					{Value: -price, AccountID: *purchase.PaymentAccountID},
-				},
+				CompanyID:    purchase.CompanyID,
+				Description:  "Payment of purchase of products",
+				Transactions: transactions,
 			}
 		}
 	} else {
 		if purchase.PayableEntry == nil {
 			purchase.PayableEntry = &models.Entry{
 				CompanyID:   purchase.CompanyID,
-				Description: "Purchase of product",
-				Transactions: []*models.Transaction{
-					{Value: price, AccountID: product.InventoryAccountID},
-					{Value: price, AccountID: *purchase.PayableAccountID},
-				},
+				Description: "Purchase of products",
+				Transactions: append(inventoryTransactions(purchase), &models.Transaction{
+					Value:     price,
+					AccountID: *purchase.PayableAccountID,
+				}),
 			}
 		}
 
@@ -163,6 +248,13 @@ func createPurchase(context *gin.Context) {
 
 	purchase.CompanyID = context.Value("CompanyID").(uint)
 
+	if errs, _ := checkPurchaseSerials(db, purchase, nil); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	chargePurchaseCredits(db, purchase)
+
 	if result := db.Create(&purchase); result.Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
@@ -173,7 +265,10 @@ func createPurchase(context *gin.Context) {
 	db.
 		Joins("PaymentAccount").
 		Joins("PayableAccount").
-		Preload("Product.Vendor").
+		Joins("Vendor").
+		Preload("Lines.Product").
+		Preload("Lines.Serials").
+		Preload("Lines.Taxes").
 		Preload("PaymentEntry.Transactions.Account").
 		Preload("PayableEntry.Transactions.Account").
 		First(&purchase)
@@ -194,7 +289,7 @@ func listPurchases(context *gin.Context) {
 	tx := db.Scopes(models.FromCompany(companyID))
 	tx = tx.Preload("PaymentEntry.Transactions.Account")
 	tx = tx.Preload("PayableEntry.Transactions.Account")
-	tx = tx.Preload("Product.Vendor").Joins("PaymentAccount").Joins("PayableAccount")
+	tx = tx.Preload("Lines.Product").Joins("Vendor").Joins("PaymentAccount").Joins("PayableAccount")
 
 	if tx.Find(&purchases).Error != nil {
 		context.Status(http.StatusInternalServerError)
@@ -223,7 +318,7 @@ func viewPurchase(context *gin.Context) {
 	tx := db.Scopes(models.FromCompany(companyID))
 	tx = tx.Preload("PaymentEntry.Transactions.Account")
 	tx = tx.Preload("PayableEntry.Transactions.Account")
-	tx = tx.Preload("Product.Vendor").Joins("PaymentAccount").Joins("PayableAccount")
+	tx = tx.Preload("Lines.Product").Preload("Lines.Taxes").Joins("Vendor").Joins("PaymentAccount").Joins("PayableAccount")
 
 	if tx.First(&purchase, id).Error != nil {
 		context.Status(http.StatusNotFound)
@@ -250,6 +345,7 @@ func updatePurchase(context *gin.Context) {
 	companyID := context.Value("CompanyID").(uint)
 
 	query := db.Scopes(models.FromCompany(companyID))
+	query = query.Preload("Lines.StockEntry").Preload("Lines.Serials")
 	query = query.Preload("PaymentEntry.Transactions")
 	query = query.Preload("PayableEntry.Transactions")
 
@@ -258,11 +354,52 @@ func updatePurchase(context *gin.Context) {
 		return
 	}
 
+	lines := map[uint]*models.PurchaseLine{}
+	for _, line := range purchase.Lines {
+		lines[line.ID] = line
+	}
+	purchase.Lines = []*models.PurchaseLine{}
+
 	if err := context.ShouldBindJSON(&purchase); err != nil {
 		context.JSON(http.StatusBadRequest, Errors(err))
 		return
 	}
 
+	errs, removed := checkPurchaseSerials(db, purchase, lines)
+	if len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	for _, serial := range removed {
+		db.Unscoped().Delete(serial)
+	}
+
+	// Lines kept in the purchase keep their stock entries, so the stock
+	// already used from them stays in place
+	for _, line := range purchase.Lines {
+		if current, ok := lines[line.ID]; ok {
+			line.StockEntryID = current.StockEntryID
+			line.StockEntry = current.StockEntry
+			delete(lines, line.ID)
+
+			// Credits are charged again from the current rules
+			db.Unscoped().Where("purchase_line_id = ?", line.ID).Delete(&models.Tax{})
+		} else {
+			line.ID = 0
+		}
+	}
+
+	chargePurchaseCredits(db, purchase)
+
+	// Remove lines no longer in the purchase
+	for _, line := range lines {
+		if line.StockEntryID != nil {
+			db.Unscoped().Delete(&models.StockEntry{}, *line.StockEntryID)
+		}
+		db.Unscoped().Delete(line)
+	}
+
 	if db.Save(&purchase).Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
@@ -273,7 +410,10 @@ func updatePurchase(context *gin.Context) {
 	db.
 		Joins("PaymentAccount").
 		Joins("PayableAccount").
-		Preload("Product.Vendor").
+		Joins("Vendor").
+		Preload("Lines.Product").
+		Preload("Lines.Serials").
+		Preload("Lines.Taxes").
 		Preload("PaymentEntry.Transactions.Account").
 		Preload("PayableEntry.Transactions.Account").
 		First(&purchase)
@@ -297,7 +437,7 @@ func deletePurchase(context *gin.Context) {
 	var purchase *models.Purchase
 	companyID := context.Value("CompanyID").(uint)
 
-	query := db.Scopes(models.FromCompany(companyID))
+	query := db.Scopes(models.FromCompany(companyID)).Preload("Lines")
 	query = query.Preload("PaymentEntry.Transactions")
 	query = query.Preload("PayableEntry.Transactions")
 
@@ -306,8 +446,11 @@ func deletePurchase(context *gin.Context) {
 		return
 	}
 
-	if purchase.StockEntryID != nil {
-		db.Unscoped().Delete(&models.StockEntry{}, *purchase.StockEntryID)
+	for _, line := range purchase.Lines {
+		if line.StockEntryID != nil {
+			db.Unscoped().Delete(&models.StockEntry{}, *line.StockEntryID)
+		}
+		db.Unscoped().Delete(line)
 	}
 
 	if purchase.PaymentEntry != nil {
@@ -2,6 +2,7 @@ package api_test
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -22,8 +23,13 @@ func TestPurchase(t *testing.T) {
 	db.AutoMigrate(&models.Entry{})
 	db.AutoMigrate(&models.Product{})
 	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
 	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
 	db.AutoMigrate(&models.Purchase{})
+	db.AutoMigrate(&models.PurchaseLine{})
+	db.AutoMigrate(&models.Serial{})
 
 	t.Cleanup(database.Cleanup)
 
@@ -87,15 +93,18 @@ func TestPurchase(t *testing.T) {
 		CostOfSaleAccountID: &cogs.ID,
 	})
 
+	db.Create(&models.Vendor{Name: "Vendor", CompanyID: 1})
+
 	t.Run("Create without payment account", func(t *testing.T) {
 		req := Post(t, "/purchases", map[string]interface{}{
-			"Qty":              5,
-			"Price":            155.75,
+			"VendorID":         1,
 			"Paid":             true,
-			"ProductID":        1,
 			"PaymentDate":      time.Now(),
 			"PaymentAccountID": nil,
 			"PayableAccountID": nil,
+			"Lines": []map[string]interface{}{
+				{"Qty": 5, "Price": 155.75, "ProductID": 1},
+			},
 		})
 
 		w := httptest.NewRecorder()
@@ -108,13 +117,14 @@ func TestPurchase(t *testing.T) {
 
 	t.Run("Create without payable account", func(t *testing.T) {
 		req := Post(t, "/purchases", map[string]interface{}{
-			"Qty":              5,
-			"Price":            155.75,
+			"VendorID":         1,
 			"Paid":             false,
-			"ProductID":        1,
 			"PaymentDate":      nil,
 			"PaymentAccountID": nil,
 			"PayableAccountID": nil,
+			"Lines": []map[string]interface{}{
+				{"Qty": 5, "Price": 155.75, "ProductID": 1},
+			},
 		})
 
 		w := httptest.NewRecorder()
@@ -127,13 +137,14 @@ func TestPurchase(t *testing.T) {
 
 	t.Run("Create paid", func(t *testing.T) {
 		req := Post(t, "/purchases", map[string]interface{}{
-			"Qty":              5,
-			"Price":            155.75,
+			"VendorID":         1,
 			"Paid":             true,
-			"ProductID":        1,
 			"PaymentDate":      time.Now(),
 			"PaymentAccountID": cash.ID,
 			"PayableAccountID": nil,
+			"Lines": []map[string]interface{}{
+				{"Qty": 5, "Price": 155.75, "ProductID": 1},
+			},
 		})
 
 		w := httptest.NewRecorder()
@@ -152,8 +163,8 @@ func TestPurchase(t *testing.T) {
 			t.Errorf("Expected ID %v, got %v", 1, purchase.ID)
 		}
 
-		if purchase.Qty != 5 {
-			t.Errorf("Expected qty %v, got %v", 5, purchase.Qty)
+		if len(purchase.Lines) != 1 || purchase.Lines[0].Qty != 5 {
+			t.Errorf("Expected a line with qty %v, got %v", 5, purchase.Lines)
 		}
 
 		if *purchase.PaymentAccountID != cash.ID {
@@ -193,13 +204,14 @@ func TestPurchase(t *testing.T) {
 
 	t.Run("Create not paid", func(t *testing.T) {
 		req := Post(t, "/purchases", map[string]interface{}{
-			"Qty":              5,
-			"Price":            155.75,
+			"VendorID":         1,
 			"Paid":             false,
-			"ProductID":        1,
 			"PaymentDate":      nil,
 			"PaymentAccountID": nil,
 			"PayableAccountID": receivables.ID,
+			"Lines": []map[string]interface{}{
+				{"Qty": 5, "Price": 155.75, "ProductID": 1},
+			},
 		})
 
 		w := httptest.NewRecorder()
@@ -218,8 +230,8 @@ func TestPurchase(t *testing.T) {
 			t.Errorf("Expected ID %v, got %v", 2, purchase.ID)
 		}
 
-		if purchase.Qty != 5 {
-			t.Errorf("Expected qty %v, got %v", 5, purchase.Qty)
+		if len(purchase.Lines) != 1 || purchase.Lines[0].Qty != 5 {
+			t.Errorf("Expected a line with qty %v, got %v", 5, purchase.Lines)
 		}
 
 		if *purchase.PayableAccountID != receivables.ID {
@@ -261,11 +273,14 @@ func TestPurchase(t *testing.T) {
 		db.Create(&models.Company{Name: "Other company"})
 
 		// This should not be retrieved
+		db.Create(&models.Vendor{Name: "Other vendor", CompanyID: 2})
+
 		db.Create(&models.Purchase{
-			Qty:       1,
-			Price:     1,
+			VendorID:  2,
 			CompanyID: 2,
-			ProductID: 1,
+			Lines: []*models.PurchaseLine{
+				{Qty: 1, Price: 1, ProductID: 1},
+			},
 		})
 
 		req := Get(t, "/purchases")
@@ -342,13 +357,14 @@ func TestPurchase(t *testing.T) {
 
 	t.Run("Update paid", func(t *testing.T) {
 		req := Put(t, "/purchases/1", map[string]interface{}{
-			"Qty":              10,
-			"Price":            155.75,
+			"VendorID":         1,
 			"Paid":             true,
-			"ProductID":        1,
 			"PaymentDate":      time.Now(),
 			"PaymentAccountID": cash.ID,
 			"PayableAccountID": nil,
+			"Lines": []map[string]interface{}{
+				{"ID": 1, "Qty": 10, "Price": 155.75, "ProductID": 1},
+			},
 		})
 
 		w := httptest.NewRecorder()
@@ -363,8 +379,8 @@ func TestPurchase(t *testing.T) {
 			t.Error("failed parsing JSON", err)
 		}
 
-		if purchase.Qty != 10 {
-			t.Errorf("Expected qty %v, got %v", 10, purchase.Qty)
+		if len(purchase.Lines) != 1 || purchase.Lines[0].Qty != 10 {
+			t.Errorf("Expected a line with qty %v, got %v", 10, purchase.Lines)
 		}
 
 		// Check if stock entries are updated
@@ -400,13 +416,14 @@ func TestPurchase(t *testing.T) {
 
 	t.Run("Update not paid", func(t *testing.T) {
 		req := Put(t, "/purchases/2", map[string]interface{}{
-			"Qty":              10,
-			"Price":            155.75,
+			"VendorID":         1,
 			"Paid":             false,
-			"ProductID":        1,
 			"PaymentDate":      nil,
 			"PaymentAccountID": nil,
 			"PayableAccountID": receivables.ID,
+			"Lines": []map[string]interface{}{
+				{"ID": 2, "Qty": 10, "Price": 155.75, "ProductID": 1},
+			},
 		})
 
 		w := httptest.NewRecorder()
@@ -421,8 +438,8 @@ func TestPurchase(t *testing.T) {
 			t.Error("failed parsing JSON", err)
 		}
 
-		if purchase.Qty != 10 {
-			t.Errorf("Expected qty %v, got %v", 10, purchase.Qty)
+		if len(purchase.Lines) != 1 || purchase.Lines[0].Qty != 10 {
+			t.Errorf("Expected a line with qty %v, got %v", 10, purchase.Lines)
 		}
 
 		// Check if stock entries are updated
@@ -468,13 +485,14 @@ func TestPurchase(t *testing.T) {
 
 	t.Run("Update to paid", func(t *testing.T) {
 		req := Put(t, "/purchases/2", map[string]interface{}{
-			"Qty":              10,
-			"Price":            155.75,
+			"VendorID":         1,
 			"Paid":             true,
-			"ProductID":        1,
 			"PaymentDate":      time.Now(),
 			"PaymentAccountID": cash.ID,
 			"PayableAccountID": receivables.ID,
+			"Lines": []map[string]interface{}{
+				{"ID": 2, "Qty": 10, "Price": 155.75, "ProductID": 1},
+			},
 		})
 
 		w := httptest.NewRecorder()
@@ -526,13 +544,14 @@ func TestPurchase(t *testing.T) {
 
 	t.Run("Update to not paid", func(t *testing.T) {
 		req := Put(t, "/purchases/1", map[string]interface{}{
-			"Qty":              10,
-			"Price":            155.75,
+			"VendorID":         1,
 			"Paid":             false,
-			"ProductID":        1,
 			"PaymentDate":      time.Now(),
 			"PaymentAccountID": cash.ID,
 			"PayableAccountID": receivables.ID,
+			"Lines": []map[string]interface{}{
+				{"ID": 1, "Qty": 10, "Price": 155.75, "ProductID": 1},
+			},
 		})
 
 		w := httptest.NewRecorder()
@@ -692,4 +711,292 @@ func TestPurchase(t *testing.T) {
 			t.Errorf("Expected status %v, got %v", http.StatusNotFound, w.Code)
 		}
 	})
+
+	t.Run("Create with multiple lines", func(t *testing.T) {
+		db.Create(&models.Product{
+			CompanyID:          1,
+			Name:               "Other product",
+			Price:              50,
+			Purchasable:        true,
+			InventoryAccountID: inventory.ID,
+		})
+
+		req := Post(t, "/purchases", map[string]interface{}{
+			"VendorID":         1,
+			"InvoiceNumber":    "1234",
+			"Paid":             true,
+			"PaymentDate":      time.Now(),
+			"PaymentAccountID": cash.ID,
+			"Lines": []map[string]interface{}{
+				{"Qty": 5, "Price": 10, "ProductID": 1},
+				{"Qty": 2, "Price": 25, "ProductID": 2},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var purchase *models.Purchase
+		if err := json.Unmarshal(w.Body.Bytes(), &purchase); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(purchase.Lines) != 2 {
+			t.Fatalf("Expected %v lines, got %v", 2, len(purchase.Lines))
+		}
+
+		if purchase.Total() != 100 {
+			t.Errorf("Expected total %v, got %v", 100, purchase.Total())
+		}
+
+		// A single entry is booked for the whole purchase
+		if purchase.PaymentEntry == nil {
+			t.Fatal("Expected payment entry")
+		}
+
+		if len(purchase.PaymentEntry.Transactions) != 2 {
+			t.Errorf("Expected %v transactions, got %v", 2, len(purchase.PaymentEntry.Transactions))
+		}
+
+		// Each line brings its own stock
+		var products []*models.Product
+		db.Preload("StockEntries").Find(&products, []uint{1, 2})
+
+		if products[0].Inventory() != 5 {
+			t.Errorf("Expected stock %v, got %v", 5, products[0].Inventory())
+		}
+
+		if products[1].Inventory() != 2 {
+			t.Errorf("Expected stock %v, got %v", 2, products[1].Inventory())
+		}
+
+		var inv *models.Account
+		db.Preload("Transactions").First(&inv, inventory.ID)
+
+		if inv.Balance() != 100 {
+			t.Errorf("Expected balance %v, got %v", 100, inv.Balance())
+		}
+	})
+
+	t.Run("Create in the purchase unit", func(t *testing.T) {
+		// Bought by the box of 12
+		db.Model(&models.Product{}).Where("id = ?", 2).Updates(map[string]interface{}{
+			"PurchaseUnit":   "box",
+			"PurchaseFactor": 12,
+		})
+
+		req := Post(t, "/purchases", map[string]interface{}{
+			"VendorID":         1,
+			"Paid":             true,
+			"PaymentDate":      time.Now(),
+			"PaymentAccountID": cash.ID,
+			"Lines": []map[string]interface{}{
+				{"Qty": 2, "Price": 60, "ProductID": 2},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var purchase *models.Purchase
+		if err := json.Unmarshal(w.Body.Bytes(), &purchase); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		var entry *models.StockEntry
+		db.First(&entry, purchase.Lines[0].StockEntryID)
+
+		if entry.Qty != 24 {
+			t.Errorf("Expected stock entry qty %v, got %v", 24, entry.Qty)
+		}
+
+		if entry.Price != 5 {
+			t.Errorf("Expected stock entry price %v, got %v", 5, entry.Price)
+		}
+	})
+
+	t.Run("List expiring lots", func(t *testing.T) {
+		req := Post(t, "/purchases", map[string]interface{}{
+			"VendorID":         1,
+			"Paid":             true,
+			"PaymentDate":      time.Now(),
+			"PaymentAccountID": cash.ID,
+			"Lines": []map[string]interface{}{
+				{"Qty": 5, "Price": 10, "ProductID": 1, "Lot": "L1", "ExpiryDate": time.Now().AddDate(0, 0, 10)},
+				{"Qty": 5, "Price": 10, "ProductID": 1, "Lot": "L2", "ExpiryDate": time.Now().AddDate(0, 0, 60)},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		req = Get(t, "/products/expiring?days=30")
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var lots []struct {
+			Product *models.Product
+			Lot     string
+			Stock   float64
+			Expired bool
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &lots); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(lots) != 1 {
+			t.Fatalf("Expected %v lot, got %v", 1, len(lots))
+		}
+
+		if lots[0].Lot != "L1" || lots[0].Stock != 5 || lots[0].Expired {
+			t.Errorf("Expected 5 in stock of lot L1, got %v of %v", lots[0].Stock, lots[0].Lot)
+		}
+
+		if lots[0].Product == nil || lots[0].Product.ID != 1 {
+			t.Error("Expected product to be retrieved along the lot")
+		}
+	})
+
+	t.Run("List expiring lots with invalid days", func(t *testing.T) {
+		req := Get(t, "/products/expiring?days=soon")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Create with recoverable taxes", func(t *testing.T) {
+		db.Model(&models.Company{}).Where("id = ?", 1).Update("regime", models.NonCumulativeRegime)
+
+		stock := &models.Account{Name: "Raw materials", Type: models.Asset, CompanyID: 1}
+		db.Create(stock)
+
+		credits := &models.Account{Name: "Recoverable taxes", Type: models.Asset, CompanyID: 1}
+		db.Create(credits)
+
+		taxes := &models.Account{Name: "Taxes", Type: models.Expense, CompanyID: 1}
+		db.Create(taxes)
+
+		product := &models.Product{
+			Name:               "Steel",
+			Price:              200,
+			NCM:                "72085100",
+			Purchasable:        true,
+			InventoryAccountID: stock.ID,
+			CompanyID:          1,
+		}
+		db.Create(product)
+
+		rule := func(taxType models.TaxType, rate float64, recoverable *uint) {
+			db.Create(&models.TaxRule{
+				Type:                 taxType,
+				Rate:                 rate,
+				Regime:               models.NonCumulativeRegime,
+				ExpenseAccountID:     taxes.ID,
+				PayableAccountID:     receivables.ID,
+				RecoverableAccountID: recoverable,
+				CompanyID:            1,
+			})
+		}
+
+		rule(models.ICMS, 12, &credits.ID)
+		rule(models.PIS, 1.65, &credits.ID)
+		rule(models.COFINS, 7.6, nil)
+
+		req := Post(t, "/purchases", map[string]interface{}{
+			"VendorID":         1,
+			"Paid":             true,
+			"PaymentDate":      time.Now(),
+			"PaymentAccountID": cash.ID,
+			"Lines": []map[string]interface{}{
+				{"Qty": 10, "Price": 100, "ProductID": product.ID},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var purchase *models.Purchase
+		if err := json.Unmarshal(w.Body.Bytes(), &purchase); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		// ICMS and PIS are recovered, COFINS has no recoverable account
+		if len(purchase.Lines[0].Taxes) != 2 {
+			t.Errorf("Expected %v taxes, got %v", 2, len(purchase.Lines[0].Taxes))
+		}
+
+		balance := func(id uint) float64 {
+			var account *models.Account
+			db.Preload("Transactions").First(&account, id)
+			return models.RoundMoney(account.Balance())
+		}
+
+		if balance(stock.ID) != 863.5 {
+			t.Errorf("Expected inventory of %v, got %v", 863.5, balance(stock.ID))
+		}
+
+		if balance(credits.ID) != 136.5 {
+			t.Errorf("Expected credits of %v, got %v", 136.5, balance(credits.ID))
+		}
+
+		var entry *models.StockEntry
+		db.Where("product_id = ?", product.ID).First(&entry)
+
+		if models.RoundMoney(entry.Price) != 86.35 {
+			t.Errorf("Expected net price %v, got %v", 86.35, entry.Price)
+		}
+
+		req = Put(t, fmt.Sprintf("/purchases/%d", purchase.ID), map[string]interface{}{
+			"VendorID":         1,
+			"Paid":             true,
+			"PaymentDate":      time.Now(),
+			"PaymentAccountID": cash.ID,
+			"Lines": []map[string]interface{}{
+				{"ID": purchase.Lines[0].ID, "Qty": 20, "Price": 100, "ProductID": product.ID},
+			},
+		})
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		if balance(credits.ID) != 273 {
+			t.Errorf("Expected credits of %v, got %v", 273, balance(credits.ID))
+		}
+
+		var count int64
+		db.Model(&models.Tax{}).Where("purchase_line_id = ?", purchase.Lines[0].ID).Count(&count)
+
+		if count != 2 {
+			t.Errorf("Expected %v taxes, got %v", 2, count)
+		}
+	})
 }
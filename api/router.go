@@ -45,15 +45,30 @@ func registerRoutes(router *gin.Engine) {
 	RegisterCustomerEndpoints(router)
 	RegisterVendorEndpoints(router)
 	RegisterProductEndpoints(router)
+	RegisterPriceListEndpoints(router)
 	RegisterPurchaseEndpoints(router)
+	RegisterPurchaseOrderEndpoints(router)
+	RegisterLandedCostEndpoints(router)
+	RegisterAssemblyEndpoints(router)
+	RegisterTaxRuleEndpoints(router)
+	RegisterSimplesEndpoints(router)
+	RegisterWithholdingEndpoints(router)
 	RegisterEntriesEndpoint(router)
+	RegisterSpedEndpoints(router)
 	RegisterSalesEndpoints(router)
+	RegisterSalesOrderEndpoints(router)
+	RegisterInvoiceEndpoints(router)
 	RegisterServicesEndpoints(router)
+	RegisterRPSEndpoints(router)
+	RegisterBoletoEndpoints(router)
+	RegisterPixEndpoints(router)
+	RegisterSerialEndpoints(router)
 }
 
 func registerValidation() {
 	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
 		v.RegisterValidation("cpf_cnpj", validCpfCpnj)
+		v.RegisterValidation("gtin", validGtin)
 		v.RegisterValidation("unique", databaseUnique)
 	}
 }
@@ -61,6 +76,7 @@ func registerValidation() {
 func RegisterEvents() {
 	events.Handle(events.PurchaseCreated, CreateStockEntry)
 	events.Handle(events.PurchaseCreated, CreateAccountingEntry)
+	events.Handle(events.PurchaseCreated, CostStockShortages)
 	events.Handle(events.PurchaseUpdated, UpdateStockEntry)
 	events.Handle(events.PurchaseUpdated, UpdateAccountingEntry)
 
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"example.com/accounting/nfe"
+	"example.com/accounting/nfse"
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	ErrRPSExists                    = errors.New("Service performed already has an RPS")
+	ErrMunicipalRegistrationMissing = errors.New("Municipal registration is required to issue RPS")
+	ErrServiceCodeMissing           = errors.New("Service code is required to issue RPS")
+	ErrNoRPSToBatch                 = errors.New("There are no RPS to batch")
+	ErrRPSAlreadyBatched            = errors.New("RPS was already batched")
+	ErrPerformedHasRPS              = errors.New("Services performed with an RPS can't be changed")
+)
+
+func RegisterRPSEndpoints(router *gin.Engine) {
+	performed := router.Group("/services/performed/:id/rps")
+
+	performed.POST("", issueRPS)
+	performed.GET("", viewRPS)
+	performed.GET("/xml", viewRPSXML)
+
+	batches := router.Group("/rps/batches")
+
+	batches.POST("", createRPSBatch)
+	batches.GET("", listRPSBatches)
+	batches.GET("/:id/xml", viewRPSBatchXML)
+}
+
+type rpsBatching struct {
+	// RPSIDs are the RPS to batch, all of those not batched yet when empty
+	RPSIDs []uint
+}
+
+// checkRPSData returns the errors of the service performed missing data the
+// RPS requires from the company or the service.
+func checkRPSData(performed *models.ServicePerformed) gin.H {
+	if len(nfe.Digits(performed.Company.Cnpj)) != 14 {
+		return gin.H{"Company.Cnpj": ErrCnpjMissing.Error()}
+	}
+
+	if performed.Company.MunicipalRegistration == "" {
+		return gin.H{"Company.MunicipalRegistration": ErrMunicipalRegistrationMissing.Error()}
+	}
+
+	if errs := checkAddress(performed.Company.Address, "Company.Address"); errs != nil {
+		return errs
+	}
+
+	if performed.Service.Code == "" {
+		return gin.H{"Service.Code": ErrServiceCodeMissing.Error()}
+	}
+
+	return nil
+}
+
+func issueRPS(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var performed *models.ServicePerformed
+	companyID := context.Value("CompanyID").(uint)
+
+	query := db.Scopes(models.FromCompany(companyID))
+	query = query.Preload("Company").Preload("Service").Preload("Customer").Preload("Taxes").Preload("Withholdings")
+
+	if query.First(&performed, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if db.Where("service_performed_id = ?", performed.ID).First(&models.RPS{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"ServicePerformedID": ErrRPSExists.Error(),
+		})
+		return
+	}
+
+	if errs := checkRPSData(performed); errs != nil {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	series := performed.Company.RPSSeries
+
+	// Numbers are never reused, not even those of deleted RPS
+	number := 0
+	tx := db.Unscoped().Model(&models.RPS{}).Scopes(models.FromCompany(companyID))
+	tx.Where("series = ?", series).Select("COALESCE(MAX(number), 0)").Scan(&number)
+
+	rps := &models.RPS{
+		Series:             series,
+		Number:             number + 1,
+		IssuedAt:           time.Now(),
+		ServicePerformedID: performed.ID,
+		CompanyID:          companyID,
+	}
+
+	document, err := nfse.Build(performed, rps).Marshal()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	rps.XML = string(document)
+
+	if db.Create(&rps).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, rps)
+}
+
+func viewRPS(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var rps *models.RPS
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Where("service_performed_id = ?", id).First(&rps).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, rps)
+}
+
+func viewRPSXML(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var rps *models.RPS
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).Where("service_performed_id = ?", id).First(&rps).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.Data(http.StatusOK, "application/xml", []byte(rps.XML))
+}
+
+func createRPSBatch(context *gin.Context) {
+	batching := &rpsBatching{}
+	if err := context.ShouldBindJSON(batching); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	var pending []*models.RPS
+	tx := db.Scopes(models.FromCompany(companyID)).Order("series, number")
+
+	if len(batching.RPSIDs) > 0 {
+		tx.Find(&pending, batching.RPSIDs)
+
+		if len(pending) != len(batching.RPSIDs) {
+			context.JSON(http.StatusBadRequest, gin.H{
+				"RPSIDs": ErrNoRPSToBatch.Error(),
+			})
+			return
+		}
+
+		for _, rps := range pending {
+			if rps.RPSBatchID != nil {
+				context.JSON(http.StatusBadRequest, gin.H{
+					"RPSIDs": ErrRPSAlreadyBatched.Error(),
+				})
+				return
+			}
+		}
+	} else {
+		tx.Where("rps_batch_id IS NULL").Find(&pending)
+	}
+
+	if len(pending) == 0 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"RPSIDs": ErrNoRPSToBatch.Error(),
+		})
+		return
+	}
+
+	var company *models.Company
+	db.First(&company, companyID)
+
+	number := 0
+	db.Model(&models.RPSBatch{}).Scopes(models.FromCompany(companyID)).Select("COALESCE(MAX(number), 0)").Scan(&number)
+
+	batch := &models.RPSBatch{
+		Number:    number + 1,
+		RPS:       pending,
+		CompanyID: companyID,
+	}
+
+	document, err := nfse.Batch(company, batch).Marshal()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	batch.XML = string(document)
+
+	if db.Create(&batch).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, batch)
+}
+
+func listRPSBatches(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var batches []*models.RPSBatch
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("RPS").Order("number")
+	if tx.Find(&batches).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, batches)
+}
+
+func viewRPSBatchXML(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var batch *models.RPSBatch
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&batch, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.Data(http.StatusOK, "application/xml", []byte(batch.XML))
+}
@@ -0,0 +1,370 @@
+package api_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"example.com/accounting/nfse"
+)
+
+func TestRPS(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Account{})
+	db.AutoMigrate(&models.Company{})
+	db.AutoMigrate(&models.Customer{})
+	db.AutoMigrate(&models.Service{})
+	db.AutoMigrate(&models.ServicePerformed{})
+	db.AutoMigrate(&models.Consumption{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+	db.AutoMigrate(&models.WithholdingRule{})
+	db.AutoMigrate(&models.Withholding{})
+	db.AutoMigrate(&models.RPSBatch{})
+	db.AutoMigrate(&models.RPS{})
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+
+	t.Cleanup(database.Cleanup)
+
+	company := &models.Company{
+		Name:                  "Testing Company",
+		Cnpj:                  "11.222.333/0001-81",
+		MunicipalRegistration: "12345678",
+		RPSSeries:             "A",
+		Address: &models.Address{
+			Street:       "Avenida Paulista",
+			Number:       "1000",
+			Neighborhood: "Bela Vista",
+			City:         "São Paulo",
+			State:        "SP",
+			Postcode:     "01310-100",
+			CityCode:     "3550308",
+		},
+	}
+	db.Create(company)
+
+	customer := &models.Customer{
+		Name:      "Customer Ltda",
+		Cpf:       "11.444.777/0001-61",
+		Email:     "customer@example.com",
+		CompanyID: 1,
+		Address: &models.Address{
+			Street:       "Rua da Assembleia",
+			Number:       "10",
+			Neighborhood: "Centro",
+			City:         "Rio de Janeiro",
+			State:        "RJ",
+			Postcode:     "20011-000",
+			CityCode:     "3304557",
+		},
+	}
+	db.Create(customer)
+
+	revenue := &models.Account{Name: "Revenue", Type: models.Revenue, CompanyID: 1}
+	db.Create(revenue)
+
+	cost := &models.Account{Name: "Cost of services", Type: models.Expense, CompanyID: 1}
+	db.Create(cost)
+
+	receivables := &models.Account{Name: "Receivables", Type: models.Asset, CompanyID: 1}
+	db.Create(receivables)
+
+	repair := &models.Service{
+		Name:                   "Repair",
+		Code:                   "14.01",
+		RevenueAccountID:       revenue.ID,
+		CostOfServiceAccountID: cost.ID,
+		CompanyID:              1,
+	}
+	db.Create(repair)
+
+	uncoded := &models.Service{
+		Name:                   "Consulting",
+		RevenueAccountID:       revenue.ID,
+		CostOfServiceAccountID: cost.ID,
+		CompanyID:              1,
+	}
+	db.Create(uncoded)
+
+	perform := func(service *models.Service, customerID *uint) *models.ServicePerformed {
+		performed := &models.ServicePerformed{
+			Value:               1000,
+			ServiceID:           service.ID,
+			CustomerID:          customerID,
+			CompanyID:           1,
+			ReceivableAccountID: &receivables.ID,
+			Taxes: []*models.Tax{
+				{Type: models.ISS, Rate: 2, Base: 1000, Value: 20},
+			},
+			Withholdings: []*models.Withholding{
+				{Type: models.IRRF, Rate: 1.5, Base: 1000, Value: 15},
+				{Type: models.CSRF, Rate: 4.65, Base: 1000, Value: 46.5},
+				{Type: models.WithheldISS, Rate: 2, Base: 1000, Value: 20},
+			},
+		}
+		db.Create(performed)
+		return performed
+	}
+
+	performed := perform(repair, &customer.ID)
+	anonymous := perform(repair, nil)
+
+	router := api.GetRouter()
+
+	errors := func(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+		return response
+	}
+
+	t.Run("Issue", func(t *testing.T) {
+		req := Post(t, fmt.Sprintf("/services/performed/%d/rps", performed.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var rps *models.RPS
+		json.Unmarshal(w.Body.Bytes(), &rps)
+
+		if rps.Series != "A" || rps.Number != 1 {
+			t.Errorf("Expected RPS %v in series %v, got %v in %v", 1, "A", rps.Number, rps.Series)
+		}
+	})
+
+	t.Run("Issue twice", func(t *testing.T) {
+		req := Post(t, fmt.Sprintf("/services/performed/%d/rps", performed.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["ServicePerformedID"] != api.ErrRPSExists.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrRPSExists.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("View XML", func(t *testing.T) {
+		req := Get(t, fmt.Sprintf("/services/performed/%d/rps/xml", performed.ID))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var rps *nfse.Rps
+		if err := xml.Unmarshal(w.Body.Bytes(), &rps); err != nil {
+			t.Fatal("Failed parsing XML", err)
+		}
+
+		servico := rps.InfDeclaracaoPrestacaoServico.Servico
+		if servico.ItemListaServico != "14.01" || servico.IssRetido != "1" {
+			t.Errorf("Expected service %v with ISS withheld, got %v %v", "14.01", servico.ItemListaServico, servico.IssRetido)
+		}
+
+		valores := servico.Valores
+		if valores.ValorServicos != "1000.00" || valores.ValorIss != "20.00" || valores.Aliquota != "2.00" {
+			t.Errorf("Expected value %v and ISS %v, got %v and %v", "1000.00", "20.00", valores.ValorServicos, valores.ValorIss)
+		}
+
+		if valores.ValorIr != "15.00" || valores.ValorPis != "6.50" || valores.ValorCofins != "30.00" || valores.ValorCsll != "10.00" {
+			t.Errorf("Expected withheld IR 15, PIS 6.5, COFINS 30 and CSLL 10, got %+v", valores)
+		}
+
+		tomador := rps.InfDeclaracaoPrestacaoServico.TomadorServico
+		if tomador == nil || tomador.IdentificacaoTomador.CpfCnpj.Cnpj != "11444777000161" {
+			t.Fatalf("Expected customer %v, got %+v", "11444777000161", tomador)
+		}
+
+		if tomador.Endereco.CodigoMunicipio != "3304557" || tomador.Contato.Email != "customer@example.com" {
+			t.Errorf("Expected customer address and contact, got %+v %+v", tomador.Endereco, tomador.Contato)
+		}
+	})
+
+	t.Run("Issue without customer", func(t *testing.T) {
+		req := Post(t, fmt.Sprintf("/services/performed/%d/rps", anonymous.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var rps *models.RPS
+		json.Unmarshal(w.Body.Bytes(), &rps)
+
+		if rps.Number != 2 {
+			t.Errorf("Expected number %v, got %v", 2, rps.Number)
+		}
+
+		req = Get(t, fmt.Sprintf("/services/performed/%d/rps/xml", anonymous.ID))
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if strings.Contains(w.Body.String(), "TomadorServico") {
+			t.Errorf("Expected no customer, got %v", w.Body.String())
+		}
+	})
+
+	t.Run("Issue without service code", func(t *testing.T) {
+		other := perform(uncoded, nil)
+
+		req := Post(t, fmt.Sprintf("/services/performed/%d/rps", other.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Service.Code"] != api.ErrServiceCodeMissing.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrServiceCodeMissing.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		req := Post(t, "/rps/batches", nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var batch *models.RPSBatch
+		json.Unmarshal(w.Body.Bytes(), &batch)
+
+		if batch.Number != 1 || len(batch.RPS) != 2 {
+			t.Errorf("Expected batch %v with %v RPS, got %v with %v", 1, 2, batch.Number, len(batch.RPS))
+		}
+
+		req = Get(t, fmt.Sprintf("/rps/batches/%d/xml", batch.ID))
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var envio *nfse.EnviarLoteRpsEnvio
+		if err := xml.Unmarshal(w.Body.Bytes(), &envio); err != nil {
+			t.Fatal("Failed parsing XML", err)
+		}
+
+		lote := envio.LoteRps
+		if lote.NumeroLote != 1 || lote.QuantidadeRps != 2 || lote.Prestador.CpfCnpj.Cnpj != "11222333000181" {
+			t.Errorf("Expected batch 1 of 2 RPS from %v, got %+v", "11222333000181", lote)
+		}
+
+		if strings.Count(lote.ListaRps.Rps, "<InfDeclaracaoPrestacaoServico") != 2 {
+			t.Errorf("Expected 2 RPS in the batch, got %v", lote.ListaRps.Rps)
+		}
+	})
+
+	t.Run("Batch without pending RPS", func(t *testing.T) {
+		req := Post(t, "/rps/batches", nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if errors(t, w)["RPSIDs"] != api.ErrNoRPSToBatch.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrNoRPSToBatch.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Batch already batched", func(t *testing.T) {
+		req := Post(t, "/rps/batches", map[string]interface{}{
+			"RPSIDs": []uint{1},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if errors(t, w)["RPSIDs"] != api.ErrRPSAlreadyBatched.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrRPSAlreadyBatched.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("List batches", func(t *testing.T) {
+		req := Get(t, "/rps/batches")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var batches []*models.RPSBatch
+		json.Unmarshal(w.Body.Bytes(), &batches)
+
+		if len(batches) != 1 || len(batches[0].RPS) != 2 {
+			t.Errorf("Expected 1 batch with 2 RPS, got %v", w.Body.String())
+		}
+	})
+
+	t.Run("Update performed with RPS", func(t *testing.T) {
+		req := Put(t, fmt.Sprintf("/services/performed/%d", performed.ID), map[string]interface{}{})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["error"] != api.ErrPerformedHasRPS.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrPerformedHasRPS.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Delete performed with RPS", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, Delete(t, fmt.Sprintf("/services/performed/%d", performed.ID)))
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if db.Where("service_performed_id = ?", performed.ID).First(&models.RPS{}).Error != nil {
+			t.Error("Expected the RPS to be kept")
+		}
+	})
+
+	t.Run("Issue after a deleted RPS", func(t *testing.T) {
+		var last *models.RPS
+		db.Order("number DESC").First(&last)
+		db.Delete(last)
+
+		next := perform(repair, &customer.ID)
+
+		req := Post(t, fmt.Sprintf("/services/performed/%d/rps", next.ID), nil)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var rps *models.RPS
+		json.Unmarshal(w.Body.Bytes(), &rps)
+
+		if rps.Number != last.Number+1 {
+			t.Errorf("Expected number %v, got %v: %v", last.Number+1, rps.Number, w.Body.String())
+		}
+	})
+}
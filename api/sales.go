@@ -5,16 +5,19 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"example.com/accounting/database"
 	"example.com/accounting/events"
 	"example.com/accounting/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 var (
 	ErrReceivableAccountMissing = errors.New("Receivables account is required")
 	ErrNotEnoughStock           = errors.New("Not enough stock")
+	ErrDiscountTooLarge         = errors.New("Discount can't exceed the value it applies to")
 )
 
 func RegisterSalesEndpoints(router *gin.Engine) {
@@ -32,35 +35,73 @@ func CreateAccountingEntries(data interface{}) {
 	sale := data.(*models.Sale)
 	db.Joins("Company").First(&sale, sale.ID)
 
+	// Cost against the stock as it was before this sale consumed it
+	loader := newStockLoader(db, "source_type <> ? OR source_id <> ?", "sales", sale.ID)
+
+	// Discounts go to the company's contra-revenue account when it has one,
+	// so revenue is posted at gross value. It usually is a debit account, but
+	// may also be a revenue account taking negative values.
+	var discountAccount *models.Account
+	if sale.Company != nil && sale.Company.DiscountAccountID != nil {
+		db.First(&discountAccount, *sale.Company.DiscountAccountID)
+	}
+
+	rules := companyTaxRules(db, sale.CompanyID)
+
 	for _, item := range sale.Items {
-		var product *models.Product
-		db.Joins("Company").Preload("StockEntries").First(&product, item.ProductID)
+		product := loader.product(item.ProductID)
 
-		costOfSale := product.Cost(item.Qty)
+		costOfSale := 0.0
+		transactions := []*models.Transaction{}
 
-		transactions := []*models.Transaction{
-			{
-				Value:     -costOfSale,
-				AccountID: product.InventoryAccountID,
-			},
-			{
+		// Kits take the stock out of each component's inventory account
+		for _, draw := range loader.consume(item.ProductID, product.SaleToStock(item.Qty)) {
+			costOfSale += draw.Cost
+			transactions = append(transactions, &models.Transaction{
+				Value:     -draw.Cost,
+				AccountID: draw.Product.InventoryAccountID,
+			})
+		}
+
+		net := item.Subtotal() - sale.ItemDiscountAmount(item)
+		revenue := net
+
+		if discount := item.Gross() - net; discount > 0 && discountAccount != nil {
+			revenue = item.Gross()
+
+			if discountAccount.TransactionType() == models.Credit {
+				discount = -discount
+			}
+
+			transactions = append(transactions, &models.Transaction{
+				Value:     discount,
+				AccountID: discountAccount.ID,
+			})
+		}
+
+		transactions = append(transactions,
+			&models.Transaction{
 				Value:     costOfSale,
 				AccountID: *product.CostOfSaleAccountID,
 			},
-			{
-				Value:     item.Subtotal(),
+			&models.Transaction{
+				Value:     revenue,
 				AccountID: *product.RevenueAccountID,
 			},
-		}
+		)
+
+		// Taxes are charged on what the customer actually pays
+		item.Taxes = models.ProductTaxes(rules, product, net)
+		transactions = append(transactions, taxTransactions(rules, item.Taxes)...)
 
 		if sale.Paid {
 			transactions = append(transactions, &models.Transaction{
-				Value:     item.Subtotal(),
+				Value:     net,
 				AccountID: *sale.PaymentAccountID,
 			})
 		} else {
 			transactions = append(transactions, &models.Transaction{
-				Value:     item.Subtotal(),
+				Value:     net,
 				AccountID: *sale.ReceivableAccountID,
 			})
 		}
@@ -79,17 +120,77 @@ func ReduceProductStock(data interface{}) {
 	sale := data.(*models.Sale)
 	db, _ := database.GetConnection()
 
+	loader := newStockLoader(db)
 	for _, item := range sale.Items {
-		var product models.Product
-		db.Joins("Company").Preload("StockEntries").First(&product, item.ProductID)
+		product := loader.product(item.ProductID)
+
+		for _, draw := range loader.consume(item.ProductID, product.SaleToStock(item.Qty)) {
+			sale.StockUsages = append(sale.StockUsages, draw.Usages...)
 
-		usages := product.Consume(item.Qty)
-		sale.StockUsages = append(sale.StockUsages, usages...)
+			if shortage := stockShortage(draw.Usages, draw.Qty, draw.Product, *product.CostOfSaleAccountID); shortage != nil {
+				sale.StockShortages = append(sale.StockShortages, shortage)
+			}
+		}
+	}
+
+	for _, product := range loader.droppedBelowMinimum() {
+		events.Dispatch(events.ProductBelowMinimum, product)
 	}
 
 	db.Save(&sale)
 }
 
+// checkSaleDiscounts makes sure no discount takes more than the value it
+// applies to, or more than 100%.
+func checkSaleDiscounts(sale *models.Sale) map[string]string {
+	errs := map[string]string{}
+
+	exceeds := func(value float64, discountType models.DiscountType, amount float64) bool {
+		if discountType == models.PercentageDiscount {
+			return value > 100
+		}
+		return value > amount
+	}
+
+	for idx, item := range sale.Items {
+		if exceeds(item.Discount, item.DiscountType, item.Gross()) {
+			errs[fmt.Sprintf("Items.%d.Discount", idx)] = ErrDiscountTooLarge.Error()
+		}
+	}
+
+	if exceeds(sale.Discount, sale.DiscountType, sale.Subtotal()) {
+		errs["Discount"] = ErrDiscountTooLarge.Error()
+	}
+
+	return errs
+}
+
+// fillItemPrices prices the items sent without a price from the customer's
+// price list, as it stood on the given date, falling back to the price of
+// the product when the list has none.
+func fillItemPrices(db *gorm.DB, sale *models.Sale, date time.Time) {
+	var customer *models.Customer
+	db.Scopes(models.FromCompany(sale.CompanyID)).Preload("PriceList.Prices").First(&customer, sale.CustomerID)
+
+	for _, item := range sale.Items {
+		if item.Price != 0 {
+			continue
+		}
+
+		if customer != nil && customer.PriceList != nil && customer.PriceList.CompanyID == sale.CompanyID {
+			if price, ok := customer.PriceList.PriceFor(item.ProductID, item.Qty, date); ok {
+				item.Price = price
+				continue
+			}
+		}
+
+		var product *models.Product
+		if db.First(&product, item.ProductID).Error == nil {
+			item.Price = product.Price
+		}
+	}
+}
+
 func createSale(context *gin.Context) {
 	var sale *models.Sale
 	if err := context.ShouldBindJSON(&sale); err != nil {
@@ -103,20 +204,38 @@ func createSale(context *gin.Context) {
 		return
 	}
 
-	for idx, item := range sale.Items {
-		if item.Product == nil {
-			db.Preload("StockEntries").First(&item.Product, item.ProductID)
-		}
+	sale.CompanyID = context.Value("CompanyID").(uint)
 
-		if item.Product.Inventory() < item.Qty {
-			context.JSON(http.StatusBadRequest, gin.H{
-				fmt.Sprintf("Items.%d.Qty", idx): ErrNotEnoughStock.Error(),
-			})
-			return
-		}
+	fillItemPrices(db, sale, time.Now())
+
+	// Taxes are charged when the sale is posted
+	for _, item := range sale.Items {
+		item.Taxes = nil
 	}
 
-	sale.CompanyID = context.Value("CompanyID").(uint)
+	if errs := checkSaleDiscounts(sale); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	if errs := checkSaleSerials(db, sale, nil); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	var company *models.Company
+	db.First(&company, sale.CompanyID)
+
+	shortages := checkStock(db, sale.CompanyID, "sales", 0, saleStockRequests(db, sale))
+
+	if len(shortages) > 0 && company.NegativeStock == models.RejectNegativeStock {
+		context.JSON(http.StatusBadRequest, shortages)
+		return
+	}
+
+	if company.NegativeStock == models.WarnNegativeStock {
+		sale.Warnings = shortages
+	}
 
 	if db.Create(sale).Error != nil {
 		context.Status(http.StatusInternalServerError)
@@ -125,7 +244,7 @@ func createSale(context *gin.Context) {
 
 	events.Dispatch(events.SaleCreated, sale)
 
-	tx := db.Preload("Items.Product").Preload("Entries.Transactions.Account")
+	tx := db.Preload("Items.Product").Preload("Items.Serials").Preload("Entries.Transactions.Account")
 	tx = tx.Joins("Customer").Joins("PaymentAccount").Joins("ReceivableAccount")
 	tx.First(&sale)
 
@@ -173,7 +292,7 @@ func viewSale(context *gin.Context) {
 	query := db.Scopes(models.FromCompany(companyID))
 	query = query.Joins("PaymentAccount").Joins("ReceivableAccount")
 
-	if query.Preload("Items.Product").Joins("Customer").First(&sale, id).Error != nil {
+	if query.Preload("Items.Product").Preload("Items.Serials").Joins("Customer").First(&sale, id).Error != nil {
 		context.Status(http.StatusNotFound)
 		return
 	}
@@ -198,42 +317,90 @@ func updateSale(context *gin.Context) {
 	companyID := context.Value("CompanyID").(uint)
 
 	query := db.Scopes(models.FromCompany(companyID))
-	query = query.Preload("Items").Preload("Entries").Preload("StockUsages")
+	query = query.Preload("Items").Preload("Entries").Preload("StockUsages").Preload("StockShortages")
 
 	if query.First(&sale, id).Error != nil {
 		context.Status(http.StatusNotFound)
 		return
 	}
 
-	// Remove current items
+	if db.Where("sale_id = ?", sale.ID).First(&models.Invoice{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"error": ErrSaleInvoiced.Error(),
+		})
+		return
+	}
+
+	items := sale.Items
 	itemIDs := []uint{}
-	for _, item := range sale.Items {
+	for _, item := range items {
 		itemIDs = append(itemIDs, item.ID)
 	}
-	db.Unscoped().Delete(&sale.Items, itemIDs)
 	sale.Items = []*models.Item{}
 
-	// Remove current accounting entries
+	entries := sale.Entries
 	entryIDs := []uint{}
-	for _, entry := range sale.Entries {
+	for _, entry := range entries {
 		entryIDs = append(entryIDs, entry.ID)
 	}
-	db.Unscoped().Select("Transactions").Delete(&sale.Entries, entryIDs)
 	sale.Entries = []*models.Entry{}
 
-	// Remove current stock usages
+	usages := sale.StockUsages
 	usageIDs := []uint{}
-	for _, usage := range sale.StockUsages {
+	for _, usage := range usages {
 		usageIDs = append(usageIDs, usage.ID)
 	}
-	db.Unscoped().Delete(&sale.StockUsages, usageIDs)
 	sale.StockUsages = []*models.StockUsage{}
 
+	pending := sale.StockShortages
+	shortageIDs := []uint{}
+	for _, shortage := range pending {
+		shortageIDs = append(shortageIDs, shortage.ID)
+	}
+	sale.StockShortages = []*models.StockShortage{}
+
 	if err := context.ShouldBindJSON(&sale); err != nil {
 		context.JSON(http.StatusBadRequest, Errors(err))
 		return
 	}
 
+	fillItemPrices(db, sale, sale.CreatedAt)
+
+	for _, item := range sale.Items {
+		item.Taxes = nil
+	}
+
+	if errs := checkSaleDiscounts(sale); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	if errs := checkSaleSerials(db, sale, itemIDs); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	var company *models.Company
+	db.First(&company, companyID)
+
+	// The stock currently used by the sale is available to its new items
+	shortages := checkStock(db, companyID, "sales", sale.ID, saleStockRequests(db, sale))
+
+	if len(shortages) > 0 && company.NegativeStock == models.RejectNegativeStock {
+		context.JSON(http.StatusBadRequest, shortages)
+		return
+	}
+
+	if company.NegativeStock == models.WarnNegativeStock {
+		sale.Warnings = shortages
+	}
+
+	// Remove current items, accounting entries, stock usages and shortages
+	db.Unscoped().Delete(&items, itemIDs)
+	db.Unscoped().Select("Transactions").Delete(&entries, entryIDs)
+	db.Unscoped().Delete(&usages, usageIDs)
+	db.Unscoped().Delete(&pending, shortageIDs)
+
 	if db.Save(&sale).Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
@@ -268,10 +435,34 @@ func deleteSale(context *gin.Context) {
 		return
 	}
 
-	if db.Unscoped().Select("StockUsages", "Entries").Delete(&sale).Error != nil {
+	if db.Where("sale_id = ?", sale.ID).First(&models.Invoice{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"error": ErrSaleInvoiced.Error(),
+		})
+		return
+	}
+
+	if db.Unscoped().Select("StockUsages", "StockShortages", "Entries").Delete(&sale).Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
 	}
 
 	context.Status(http.StatusNoContent)
 }
+
+// saleStockRequests returns the stock requested by the items of the sale,
+// converted from the sale unit to the stock unit of their products.
+func saleStockRequests(db *gorm.DB, sale *models.Sale) []stockRequest {
+	requests := []stockRequest{}
+	for idx, item := range sale.Items {
+		var product *models.Product
+		db.First(&product, item.ProductID)
+
+		requests = append(requests, stockRequest{
+			Field:     fmt.Sprintf("Items.%d.Qty", idx),
+			ProductID: item.ProductID,
+			Qty:       product.SaleToStock(item.Qty),
+		})
+	}
+	return requests
+}
@@ -0,0 +1,432 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/accounting/database"
+	"example.com/accounting/events"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidStatusChange = errors.New("Sales order can't change to this status")
+	ErrQuoteExpired        = errors.New("Quote has expired")
+	ErrOrderNotQuote       = errors.New("Only quotes can be changed")
+)
+
+func RegisterSalesOrderEndpoints(router *gin.Engine) {
+	group := router.Group("/sales-orders")
+
+	group.POST("", createSalesOrder)
+	group.GET("", listSalesOrders)
+	group.GET("/:id", viewSalesOrder)
+	group.PUT("/:id", updateSalesOrder)
+	group.DELETE("/:id", deleteSalesOrder)
+	group.POST("/:id/confirm", confirmSalesOrder)
+	group.POST("/:id/invoice", invoiceSalesOrder)
+	group.POST("/:id/cancel", cancelSalesOrder)
+}
+
+// invoicing is how the sale invoicing the order is paid, and the serials
+// of its items, given in the order's item order, for serialized products.
+type invoicing struct {
+	Paid                bool
+	PaymentAccountID    *uint            `binding:"required_if=Paid true"`
+	ReceivableAccountID *uint            `binding:"required_if=Paid false"`
+	Items               []*invoicingItem `binding:"dive,required"`
+}
+
+type invoicingItem struct {
+	Serials []*models.Serial `binding:"dive"`
+}
+
+// priceSalesOrder fills the prices the items were sent without, the same
+// way sales do, and checks their discounts.
+func priceSalesOrder(db *gorm.DB, order *models.SalesOrder) map[string]string {
+	sale := order.ToSale()
+	fillItemPrices(db, sale, time.Now())
+
+	for idx, item := range sale.Items {
+		order.Items[idx].Price = item.Price
+	}
+
+	return checkSaleDiscounts(sale)
+}
+
+func createSalesOrder(context *gin.Context) {
+	var order *models.SalesOrder
+	if err := context.ShouldBindJSON(&order); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	order.CompanyID = context.Value("CompanyID").(uint)
+	order.Status = models.Quoted
+	order.SaleID = nil
+	order.Sale = nil
+
+	if errs := priceSalesOrder(db, order); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	if db.Create(&order).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	tx := db.Joins("Customer").Preload("Items.Product").Preload("Sale.Items").Preload("Sale.Entries.Transactions.Account")
+	tx.First(&order)
+
+	context.JSON(http.StatusOK, order)
+}
+
+func listSalesOrders(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var orders []*models.SalesOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Joins("Customer").Preload("Items.Product")
+	if status, ok := context.GetQuery("status"); ok {
+		tx = tx.Where("status = ?", status)
+	}
+
+	if tx.Find(&orders).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, orders)
+}
+
+func viewSalesOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.SalesOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID))
+	tx = tx.Joins("Customer").Preload("Items.Product").Preload("Sale.Items").Preload("Sale.Entries.Transactions.Account")
+
+	if tx.First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, order)
+}
+
+func updateSalesOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.SalesOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Items")
+	if tx.First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	// Confirmed orders have stock reserved for their items
+	if order.Status != models.Quoted {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"error": ErrOrderNotQuote.Error(),
+		})
+		return
+	}
+
+	items := order.Items
+	order.Items = []*models.SalesOrderItem{}
+
+	if err := context.ShouldBindJSON(&order); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	order.Status = models.Quoted
+	order.SaleID = nil
+	order.Sale = nil
+
+	if errs := priceSalesOrder(db, order); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	// Items are replaced as a whole
+	for _, item := range items {
+		db.Unscoped().Delete(item)
+	}
+
+	if db.Save(&order).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	tx = db.Joins("Customer").Preload("Items.Product").Preload("Sale.Items").Preload("Sale.Entries.Transactions.Account")
+	tx.First(&order)
+
+	context.JSON(http.StatusOK, order)
+}
+
+func deleteSalesOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.SalesOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	// The sale invoicing the order, if any, is kept
+	if db.Unscoped().Select("Items", "Reservations").Delete(&order).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
+
+// confirmSalesOrder turns a quote into an order, reserving the stock of its
+// items as far as the company's negative stock policy allows.
+func confirmSalesOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.SalesOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Items")
+	if tx.First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if !order.CanChangeTo(models.Confirmed) {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Status": ErrInvalidStatusChange.Error(),
+		})
+		return
+	}
+
+	if order.Expired(time.Now()) {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"ExpiryDate": ErrQuoteExpired.Error(),
+		})
+		return
+	}
+
+	var company *models.Company
+	db.First(&company, companyID)
+
+	requests := saleStockRequests(db, order.ToSale())
+	shortages := checkStock(db, companyID, "sales_orders", order.ID, requests)
+
+	if len(shortages) > 0 && company.NegativeStock == models.RejectNegativeStock {
+		context.JSON(http.StatusBadRequest, shortages)
+		return
+	}
+
+	for _, request := range requests {
+		order.Reservations = append(order.Reservations, &models.StockReservation{
+			Qty:       request.Qty,
+			ProductID: request.ProductID,
+		})
+	}
+
+	order.Status = models.Confirmed
+
+	if db.Save(&order).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	tx = db.Joins("Customer").Preload("Items.Product").Preload("Sale.Items").Preload("Sale.Entries.Transactions.Account")
+	tx.First(&order)
+
+	context.JSON(http.StatusOK, order)
+}
+
+// invoiceSalesOrder turns the order into a sale, which takes the stock the
+// order had reserved.
+func invoiceSalesOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.SalesOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Items").Preload("Reservations")
+	if tx.First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	var invoice *invoicing
+	if err := context.ShouldBindJSON(&invoice); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	if !order.CanChangeTo(models.Invoiced) {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Status": ErrInvalidStatusChange.Error(),
+		})
+		return
+	}
+
+	sale := order.ToSale()
+	sale.Paid = invoice.Paid
+	sale.PaymentAccountID = invoice.PaymentAccountID
+	sale.ReceivableAccountID = invoice.ReceivableAccountID
+
+	for idx, item := range invoice.Items {
+		if idx < len(sale.Items) {
+			sale.Items[idx].Serials = item.Serials
+		}
+	}
+
+	if errs := checkSaleSerials(db, sale, nil); len(errs) > 0 {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	if db.Create(&sale).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	for _, reservation := range order.Reservations {
+		db.Unscoped().Delete(reservation)
+	}
+
+	order.Status = models.Invoiced
+	order.SaleID = &sale.ID
+	order.Reservations = nil
+
+	if db.Save(&order).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	events.Dispatch(events.SaleCreated, sale)
+
+	tx = db.Joins("Customer").Preload("Items.Product").Preload("Sale.Items").Preload("Sale.Entries.Transactions.Account")
+	tx.First(&order)
+
+	context.JSON(http.StatusOK, order)
+}
+
+// cancelSalesOrder cancels a quote or an order, releasing its stock.
+func cancelSalesOrder(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var order *models.SalesOrder
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("Reservations")
+	if tx.First(&order, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if !order.CanChangeTo(models.Cancelled) {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Status": ErrInvalidStatusChange.Error(),
+		})
+		return
+	}
+
+	for _, reservation := range order.Reservations {
+		db.Unscoped().Delete(reservation)
+	}
+
+	order.Status = models.Cancelled
+	order.Reservations = nil
+
+	if db.Save(&order).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	tx = db.Joins("Customer").Preload("Items.Product").Preload("Sale.Items").Preload("Sale.Entries.Transactions.Account")
+	tx.First(&order)
+
+	context.JSON(http.StatusOK, order)
+}
@@ -0,0 +1,383 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/events"
+	"example.com/accounting/models"
+)
+
+func TestSalesOrder(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Component{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.StockReservation{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+	db.AutoMigrate(&models.PriceList{})
+	db.AutoMigrate(&models.Price{})
+	db.AutoMigrate(&models.Customer{})
+	db.AutoMigrate(&models.Sale{})
+	db.AutoMigrate(&models.Item{})
+	db.AutoMigrate(&models.Serial{})
+	db.AutoMigrate(&models.SalesOrder{})
+	db.AutoMigrate(&models.SalesOrderItem{})
+
+	t.Cleanup(database.Cleanup)
+
+	db.Create(&models.Company{Name: "Testing Company"})
+
+	cash := &models.Account{Name: "Cash", Type: models.Asset, CompanyID: 1}
+	db.Create(cash)
+
+	inventory := &models.Account{Name: "Inventory", Type: models.Asset, CompanyID: 1}
+	db.Create(inventory)
+
+	db.Create(&models.Customer{Name: "Customer", CompanyID: 1})
+
+	db.Create(&models.Product{
+		Name:               "Chair",
+		Price:              100,
+		InventoryAccountID: inventory.ID,
+		CompanyID:          1,
+		StockEntries:       []*models.StockEntry{{Qty: 10, Price: 60}},
+	})
+
+	invoiced := 0
+	events.Handle(events.SaleCreated, func(data interface{}) {
+		invoiced++
+	})
+
+	router := api.GetRouter()
+
+	quote := func(qty float64, expiryDate time.Time) *models.SalesOrder {
+		req := Post(t, "/sales-orders", map[string]interface{}{
+			"CustomerID": 1,
+			"ExpiryDate": expiryDate,
+			"Items": []map[string]interface{}{
+				{"Qty": qty, "ProductID": 1},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var order *models.SalesOrder
+		if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+		return order
+	}
+
+	post := func(url string, body interface{}) (*httptest.ResponseRecorder, map[string]string) {
+		req := Post(t, url, body)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		response := map[string]string{}
+		if w.Code == http.StatusBadRequest {
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Error("Failed parsing JSON", err)
+			}
+		}
+		return w, response
+	}
+
+	nextWeek := time.Now().AddDate(0, 0, 7)
+
+	t.Run("Create quote", func(t *testing.T) {
+		order := quote(6, nextWeek)
+
+		if order.Status != models.Quoted {
+			t.Errorf("Expected status %v, got %v", models.Quoted, order.Status)
+		}
+
+		if order.Items[0].Price != 100 {
+			t.Errorf("Expected price %v, got %v", 100, order.Items[0].Price)
+		}
+	})
+
+	t.Run("Confirm expired quote", func(t *testing.T) {
+		order := quote(1, time.Now().AddDate(0, 0, -1))
+
+		w, response := post("/sales-orders/2/confirm", nil)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if response["ExpiryDate"] != api.ErrQuoteExpired.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrQuoteExpired.Error(), response["ExpiryDate"])
+		}
+
+		if order.ID != 2 {
+			t.Errorf("Expected order %v, got %v", 2, order.ID)
+		}
+	})
+
+	t.Run("Invoice quote", func(t *testing.T) {
+		w, response := post("/sales-orders/1/invoice", map[string]interface{}{
+			"Paid":             true,
+			"PaymentAccountID": cash.ID,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if response["Status"] != api.ErrInvalidStatusChange.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrInvalidStatusChange.Error(), response["Status"])
+		}
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		w, _ := post("/sales-orders/1/confirm", nil)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var order *models.SalesOrder
+		if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if order.Status != models.Confirmed {
+			t.Errorf("Expected status %v, got %v", models.Confirmed, order.Status)
+		}
+
+		if invoiced != 0 {
+			t.Errorf("Expected no sale, got %v", invoiced)
+		}
+	})
+
+	t.Run("Confirm beyond unreserved stock", func(t *testing.T) {
+		quote(6, nextWeek)
+
+		w, response := post("/sales-orders/3/confirm", nil)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if response["Items.0.Qty"] != api.ErrNotEnoughStock.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrNotEnoughStock.Error(), response["Items.0.Qty"])
+		}
+	})
+
+	t.Run("Sell reserved stock", func(t *testing.T) {
+		w, response := post("/sales", map[string]interface{}{
+			"Paid":             true,
+			"CustomerID":       1,
+			"PaymentAccountID": cash.ID,
+			"Items": []map[string]interface{}{
+				{"Qty": 5, "Price": 100, "ProductID": 1},
+			},
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if response["Items.0.Qty"] != api.ErrNotEnoughStock.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrNotEnoughStock.Error(), response["Items.0.Qty"])
+		}
+	})
+
+	t.Run("Sell stock reserved for kits", func(t *testing.T) {
+		pair := &models.Product{
+			Name:               "Pair of chairs",
+			InventoryAccountID: inventory.ID,
+			CompanyID:          1,
+			Components:         []*models.Component{{Qty: 2, ComponentID: 1}},
+		}
+		db.Create(pair)
+
+		reservation := &models.StockReservation{Qty: 2, SourceID: 99, SourceType: "sales_orders", ProductID: pair.ID}
+		db.Create(reservation)
+		defer db.Unscoped().Delete(reservation)
+
+		w, response := post("/sales", map[string]interface{}{
+			"Paid":             true,
+			"CustomerID":       1,
+			"PaymentAccountID": cash.ID,
+			"Items": []map[string]interface{}{
+				{"Qty": 1, "Price": 100, "ProductID": 1},
+			},
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if response["Items.0.Qty"] != api.ErrNotEnoughStock.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrNotEnoughStock.Error(), response["Items.0.Qty"])
+		}
+	})
+
+	t.Run("Update order", func(t *testing.T) {
+		req := Put(t, "/sales-orders/1", map[string]interface{}{
+			"CustomerID": 1,
+			"Items": []map[string]interface{}{
+				{"Qty": 1, "ProductID": 1},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Invoice", func(t *testing.T) {
+		w, _ := post("/sales-orders/1/invoice", map[string]interface{}{
+			"Paid":             true,
+			"PaymentAccountID": cash.ID,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var order *models.SalesOrder
+		if err := json.Unmarshal(w.Body.Bytes(), &order); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if order.Status != models.Invoiced {
+			t.Errorf("Expected status %v, got %v", models.Invoiced, order.Status)
+		}
+
+		if order.Sale == nil || order.Sale.Total() != 600 {
+			t.Errorf("Expected sale of %v, got %v", 600, order.Sale)
+		}
+
+		if invoiced != 1 {
+			t.Errorf("Expected %v sale created, got %v", 1, invoiced)
+		}
+
+		var reservations []*models.StockReservation
+		db.Find(&reservations)
+
+		if len(reservations) != 0 {
+			t.Errorf("Expected reservations to be released, got %v", len(reservations))
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		if w, _ := post("/sales-orders/1/cancel", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if w, _ := post("/sales-orders/3/cancel", nil); w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := Get(t, "/sales-orders?status=3")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var orders []*models.SalesOrder
+		if err := json.Unmarshal(w.Body.Bytes(), &orders); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(orders) != 1 || orders[0].ID != 3 {
+			t.Errorf("Expected only the cancelled order, got %v", len(orders))
+		}
+	})
+
+	t.Run("Invoice serialized products", func(t *testing.T) {
+		phone := &models.Product{
+			Name:               "Phone",
+			Price:              1000,
+			Serialized:         true,
+			InventoryAccountID: inventory.ID,
+			CompanyID:          1,
+			StockEntries:       []*models.StockEntry{{Qty: 2, Price: 600}},
+		}
+		db.Create(phone)
+
+		for _, number := range []string{"SN1", "SN2"} {
+			db.Create(&models.Serial{Number: number, ProductID: phone.ID, CompanyID: 1})
+		}
+
+		req := Post(t, "/sales-orders", map[string]interface{}{
+			"CustomerID": 1,
+			"Items": []map[string]interface{}{
+				{"Qty": 2, "ProductID": phone.ID},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var order *models.SalesOrder
+		json.Unmarshal(w.Body.Bytes(), &order)
+
+		url := fmt.Sprintf("/sales-orders/%d", order.ID)
+		if w, _ := post(url+"/confirm", nil); w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		w, response := post(url+"/invoice", map[string]interface{}{
+			"Paid":             true,
+			"PaymentAccountID": cash.ID,
+		})
+
+		if response["Items.0.Serials"] != api.ErrSerialsMismatch.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrSerialsMismatch.Error(), w.Body.String())
+		}
+
+		w, _ = post(url+"/invoice", map[string]interface{}{
+			"Paid":             true,
+			"PaymentAccountID": cash.ID,
+			"Items": []map[string]interface{}{
+				{"Serials": []map[string]interface{}{{"Number": "SN1"}, {"Number": "SN2"}}},
+			},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		json.Unmarshal(w.Body.Bytes(), &order)
+
+		var serials []*models.Serial
+		db.Where("product_id = ? AND item_id IS NOT NULL", phone.ID).Find(&serials)
+
+		if order.Status != models.Invoiced || len(serials) != 2 {
+			t.Errorf("Expected the order invoiced with %v serials sold, got %v and %v", 2, order.Status, len(serials))
+		}
+	})
+}
@@ -20,12 +20,20 @@ func TestSales(t *testing.T) {
 
 	db.AutoMigrate(&models.Sale{})
 	db.AutoMigrate(&models.Item{})
+	db.AutoMigrate(&models.Component{})
+	db.AutoMigrate(&models.Serial{})
 	db.AutoMigrate(&models.Account{})
 	db.AutoMigrate(&models.Company{})
 	db.AutoMigrate(&models.Customer{})
 	db.AutoMigrate(&models.StockEntry{})
 	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.StockReservation{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
 	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.Purchase{})
+	db.AutoMigrate(&models.PurchaseLine{})
 
 	t.Cleanup(database.Cleanup)
 
@@ -1221,4 +1229,341 @@ func TestSales(t *testing.T) {
 			t.Errorf("Expected status %v, got %v", http.StatusNotFound, w.Code)
 		}
 	})
+
+	t.Run("Create without enough stock allowed", func(t *testing.T) {
+		db.Model(&models.Company{}).Where("id = ?", 2).Update("NegativeStock", models.AllowNegativeStock)
+
+		req := Post(t, "/sales", map[string]interface{}{
+			"Paid":                true,
+			"CustomerID":          2,
+			"PaymentAccountID":    6,
+			"ReceivableAccountID": nil,
+			"Items": []map[string]interface{}{
+				{"Qty": 200, "Price": 600, "ProductID": 3},
+			},
+		})
+
+		req.Header.Set("CompanyID", "2")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var sale models.Sale
+		if err := json.Unmarshal(w.Body.Bytes(), &sale); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(sale.Warnings) != 0 {
+			t.Errorf("Expected no warnings, got %v", sale.Warnings)
+		}
+
+		// Check if product's stock is depleted
+		var product *models.Product
+		if db.Preload("StockEntries.StockUsages").First(&product, 3).Error != nil {
+			t.Error("Should retrieve product")
+		}
+
+		if product.Inventory() != 0 {
+			t.Errorf("Expected %v stock, got %v", 0, product.Inventory())
+		}
+
+		// Check if the missing quantity is pending
+		var shortages []*models.StockShortage
+		db.Where("source_id = ? AND source_type = ?", sale.ID, "sales").Find(&shortages)
+
+		if len(shortages) != 1 {
+			t.Fatalf("Expected %v shortage, got %v", 1, len(shortages))
+		}
+
+		if shortages[0].Qty != 10 {
+			t.Errorf("Expected shortage of %v, got %v", 10, shortages[0].Qty)
+		}
+
+		if shortages[0].CostAccountID != 9 {
+			t.Errorf("Expected cost account %v, got %v", 9, shortages[0].CostAccountID)
+		}
+
+		// Check if only the available stock is costed
+		var cost *models.Account
+		if db.Preload("Transactions").First(&cost, 9).Error != nil {
+			t.Error("Should retrieve account")
+		}
+
+		if cost.Balance() != 85000 {
+			t.Errorf("Expected balance %v, got %v", 85000, cost.Balance())
+		}
+	})
+
+	t.Run("Cost shortages on purchase", func(t *testing.T) {
+		vendor := &models.Vendor{Name: "Vendor", CompanyID: 2}
+		db.Create(vendor)
+
+		purchase := &models.Purchase{
+			Paid:      true,
+			CompanyID: 2,
+			VendorID:  vendor.ID,
+			Lines: []*models.PurchaseLine{
+				{
+					Qty:       50,
+					Price:     500,
+					ProductID: 3,
+					StockEntry: &models.StockEntry{
+						Qty:       50,
+						Price:     500,
+						ProductID: 3,
+					},
+				},
+			},
+		}
+		db.Create(purchase)
+
+		api.CostStockShortages(purchase)
+
+		if db.Where("product_id = ?", 3).First(&models.StockShortage{}).Error == nil {
+			t.Error("Should not find pending shortages")
+		}
+
+		var product *models.Product
+		if db.Preload("StockEntries.StockUsages").First(&product, 3).Error != nil {
+			t.Error("Should retrieve product")
+		}
+
+		if product.Inventory() != 40 {
+			t.Errorf("Expected %v stock, got %v", 40, product.Inventory())
+		}
+
+		var cost *models.Account
+		if db.Preload("Transactions").First(&cost, 9).Error != nil {
+			t.Error("Should retrieve account")
+		}
+
+		if cost.Balance() != 90000 {
+			t.Errorf("Expected balance %v, got %v", 90000, cost.Balance())
+		}
+
+		var inv *models.Account
+		if db.Preload("Transactions").First(&inv, 7).Error != nil {
+			t.Error("Should retrieve account")
+		}
+
+		if inv.Balance() != -90000 {
+			t.Errorf("Expected balance %v, got %v", -90000, inv.Balance())
+		}
+	})
+
+	t.Run("Create without enough stock warned", func(t *testing.T) {
+		db.Model(&models.Company{}).Where("id = ?", 2).Update("NegativeStock", models.WarnNegativeStock)
+
+		req := Post(t, "/sales", map[string]interface{}{
+			"Paid":                true,
+			"CustomerID":          2,
+			"PaymentAccountID":    6,
+			"ReceivableAccountID": nil,
+			"Items": []map[string]interface{}{
+				{"Qty": 50, "Price": 600, "ProductID": 3},
+			},
+		})
+
+		req.Header.Set("CompanyID", "2")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var sale models.Sale
+		if err := json.Unmarshal(w.Body.Bytes(), &sale); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if sale.Warnings["Items.0.Qty"] != api.ErrNotEnoughStock.Error() {
+			t.Errorf("Expected warning %v, got %v", api.ErrNotEnoughStock.Error(), sale.Warnings["Items.0.Qty"])
+		}
+	})
+
+	t.Run("Notify product below minimum", func(t *testing.T) {
+		db.Model(&models.Product{}).Where("id = ?", 1).Update("MinStock", 195)
+
+		var notified []uint
+		events.Handle(events.ProductBelowMinimum, func(data interface{}) {
+			notified = append(notified, data.(*models.Product).ID)
+		})
+
+		for i := 0; i < 2; i++ {
+			req := Post(t, "/sales", map[string]interface{}{
+				"Paid":                true,
+				"CustomerID":          1,
+				"PaymentAccountID":    cash.ID,
+				"ReceivableAccountID": nil,
+				"Items": []map[string]interface{}{
+					{"Qty": 5, "Price": 200, "ProductID": 1},
+					{"Qty": 5, "Price": 250, "ProductID": 2},
+				},
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+			}
+		}
+
+		if len(notified) != 1 {
+			t.Fatalf("Expected %v notification, got %v", 1, len(notified))
+		}
+
+		if notified[0] != 1 {
+			t.Errorf("Expected product %v, got %v", 1, notified[0])
+		}
+	})
+
+	t.Run("Create in the sale unit", func(t *testing.T) {
+		// Sold by the half, with fractions
+		db.Model(&models.Product{}).Where("id = ?", 2).Updates(map[string]interface{}{
+			"SaleUnit":   "half",
+			"SaleFactor": 0.5,
+		})
+
+		inventory := func() float64 {
+			var product *models.Product
+			db.Preload("StockEntries.StockUsages").First(&product, 2)
+			return product.Inventory()
+		}
+
+		before := inventory()
+
+		req := Post(t, "/sales", map[string]interface{}{
+			"Paid":                true,
+			"CustomerID":          1,
+			"PaymentAccountID":    cash.ID,
+			"ReceivableAccountID": nil,
+			"Items": []map[string]interface{}{
+				{"Qty": 1.5, "Price": 125, "ProductID": 2},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var sale models.Sale
+		if err := json.Unmarshal(w.Body.Bytes(), &sale); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if sale.Total() != 187.5 {
+			t.Errorf("Expected total %v, got %v", 187.5, sale.Total())
+		}
+
+		if used := models.RoundQty(before - inventory()); used != 0.75 {
+			t.Errorf("Expected %v of stock used, got %v", 0.75, used)
+		}
+	})
+
+	t.Run("Create with discounts", func(t *testing.T) {
+		discounts := &models.Account{
+			Name:      "Sales discounts",
+			Type:      models.Expense,
+			CompanyID: 1,
+		}
+		db.Create(discounts)
+
+		db.Model(&models.Company{}).Where("id = ?", 1).Update("DiscountAccountID", discounts.ID)
+
+		balance := func(accountID uint) float64 {
+			var account *models.Account
+			db.Preload("Transactions").First(&account, accountID)
+			return account.Balance()
+		}
+
+		revenueBefore, cashBefore := balance(revenue.ID), balance(cash.ID)
+
+		req := Post(t, "/sales", map[string]interface{}{
+			"Paid":             true,
+			"CustomerID":       1,
+			"PaymentAccountID": cash.ID,
+			"Discount":         61,
+			"DiscountType":     models.FixedDiscount,
+			"Items": []map[string]interface{}{
+				{"Qty": 2, "Price": 200, "ProductID": 1, "Discount": 10, "DiscountType": models.PercentageDiscount},
+				{"Qty": 1, "Price": 250, "ProductID": 2},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var sale models.Sale
+		if err := json.Unmarshal(w.Body.Bytes(), &sale); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if sale.Items[0].Subtotal() != 360 {
+			t.Errorf("Expected subtotal %v, got %v", 360, sale.Items[0].Subtotal())
+		}
+
+		if sale.Total() != 549 {
+			t.Errorf("Expected total %v, got %v", 549, sale.Total())
+		}
+
+		// Revenue is posted at gross value, the discounts on their own
+		if revenue := balance(revenue.ID) - revenueBefore; revenue != 650 {
+			t.Errorf("Expected revenue %v, got %v", 650, revenue)
+		}
+
+		if discount := balance(discounts.ID); discount != 101 {
+			t.Errorf("Expected discounts %v, got %v", 101, discount)
+		}
+
+		if received := balance(cash.ID) - cashBefore; received != 549 {
+			t.Errorf("Expected %v received, got %v", 549, received)
+		}
+
+		for _, entry := range sale.Entries {
+			if !entry.IsBalanced() {
+				t.Errorf("Expected entry to be balanced, got %v", entry.Transactions)
+			}
+		}
+	})
+
+	t.Run("Create with discount over 100%", func(t *testing.T) {
+		req := Post(t, "/sales", map[string]interface{}{
+			"Paid":             true,
+			"CustomerID":       1,
+			"PaymentAccountID": cash.ID,
+			"Items": []map[string]interface{}{
+				{"Qty": 1, "Price": 200, "ProductID": 1, "Discount": 110, "DiscountType": models.PercentageDiscount},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if response["Items.0.Discount"] != api.ErrDiscountTooLarge.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrDiscountTooLarge.Error(), response["Items.0.Discount"])
+		}
+	})
 }
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSerialsMismatch = errors.New("Serials must match the quantity")
+	ErrSerialExists    = errors.New("Serial was already purchased")
+	ErrSerialNotFound  = errors.New("Serial not found")
+	ErrSerialSold      = errors.New("Serial was already sold")
+)
+
+func RegisterSerialEndpoints(router *gin.Engine) {
+	group := router.Group("/serials")
+
+	group.GET("/:serial", viewSerial)
+}
+
+// checkPurchaseSerials makes sure the lines of serialized products bring a
+// new serial for each unit. Serials the current lines already had are kept,
+// and the ones no longer listed are returned so they can be removed, unless
+// they were sold.
+func checkPurchaseSerials(db *gorm.DB, purchase *models.Purchase, current map[uint]*models.PurchaseLine) (map[string]string, []*models.Serial) {
+	errs := map[string]string{}
+	removed := []*models.Serial{}
+	seen := map[uint]map[string]bool{}
+
+	for idx, line := range purchase.Lines {
+		var product *models.Product
+		db.First(&product, line.ProductID)
+
+		existing := map[string]*models.Serial{}
+		if old, ok := current[line.ID]; ok {
+			for _, serial := range old.Serials {
+				existing[serial.Number] = serial
+			}
+		}
+
+		if !product.Serialized {
+			line.Serials = nil
+			continue
+		}
+
+		if float64(len(line.Serials)) != product.PurchaseToStock(line.Qty) {
+			errs[fmt.Sprintf("Lines.%d.Serials", idx)] = ErrSerialsMismatch.Error()
+			continue
+		}
+
+		if seen[product.ID] == nil {
+			seen[product.ID] = map[string]bool{}
+		}
+
+		for serialIdx, serial := range line.Serials {
+			field := fmt.Sprintf("Lines.%d.Serials.%d", idx, serialIdx)
+
+			if kept, ok := existing[serial.Number]; ok {
+				line.Serials[serialIdx] = kept
+				delete(existing, serial.Number)
+			} else {
+				query := &models.Serial{
+					Number:    serial.Number,
+					ProductID: product.ID,
+					CompanyID: purchase.CompanyID,
+				}
+
+				if seen[product.ID][serial.Number] || db.Where(query).First(&models.Serial{}).Error == nil {
+					errs[field] = ErrSerialExists.Error()
+					continue
+				}
+
+				serial.ID = 0
+				serial.ItemID = nil
+				serial.ProductID = product.ID
+				serial.CompanyID = purchase.CompanyID
+			}
+
+			seen[product.ID][serial.Number] = true
+		}
+
+		for _, serial := range existing {
+			if serial.Sold() {
+				errs[fmt.Sprintf("Lines.%d.Serials", idx)] = ErrSerialSold.Error()
+			} else {
+				removed = append(removed, serial)
+			}
+		}
+	}
+
+	return errs, removed
+}
+
+// checkSaleSerials makes sure the items of serialized products take a
+// purchased and unsold serial for each unit, and links the items to them.
+// Serials sold by the given items are considered unsold, so updates can
+// sell them again.
+func checkSaleSerials(db *gorm.DB, sale *models.Sale, itemIDs []uint) map[string]string {
+	errs := map[string]string{}
+	seen := map[uint]bool{}
+
+	current := map[uint]bool{}
+	for _, id := range itemIDs {
+		current[id] = true
+	}
+
+	for idx, item := range sale.Items {
+		var product *models.Product
+		db.First(&product, item.ProductID)
+
+		if !product.Serialized {
+			item.Serials = nil
+			continue
+		}
+
+		if float64(len(item.Serials)) != product.SaleToStock(item.Qty) {
+			errs[fmt.Sprintf("Items.%d.Serials", idx)] = ErrSerialsMismatch.Error()
+			continue
+		}
+
+		for serialIdx, serial := range item.Serials {
+			field := fmt.Sprintf("Items.%d.Serials.%d", idx, serialIdx)
+
+			query := &models.Serial{
+				Number:    serial.Number,
+				ProductID: product.ID,
+				CompanyID: sale.CompanyID,
+			}
+
+			var found *models.Serial
+			if db.Where(query).First(&found).Error != nil {
+				errs[field] = ErrSerialNotFound.Error()
+				continue
+			}
+
+			if seen[found.ID] || (found.Sold() && !current[*found.ItemID]) {
+				errs[field] = ErrSerialSold.Error()
+				continue
+			}
+
+			seen[found.ID] = true
+			item.Serials[serialIdx] = found
+		}
+	}
+
+	return errs
+}
+
+type serialHistory struct {
+	Serial   *models.Serial
+	Product  *models.Product
+	Purchase *models.Purchase
+	Vendor   *models.Vendor
+	Sale     *models.Sale
+	Customer *models.Customer
+}
+
+// viewSerial traces the units with the given serial from their purchase to
+// their sale, if any. Different products may share a serial number.
+func viewSerial(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var serials []*models.Serial
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Where(&models.Serial{Number: context.Param("serial"), CompanyID: companyID})
+	tx = tx.Joins("Product").Preload("PurchaseLine.Purchase.Vendor").Preload("Item.Sale.Customer")
+
+	if tx.Find(&serials).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	if len(serials) == 0 {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	history := []*serialHistory{}
+	for _, serial := range serials {
+		entry := &serialHistory{Serial: serial, Product: serial.Product}
+
+		if line := serial.PurchaseLine; line != nil && line.Purchase != nil {
+			entry.Purchase = line.Purchase
+			entry.Vendor = line.Purchase.Vendor
+		}
+
+		if item := serial.Item; item != nil && item.Sale != nil {
+			entry.Sale = item.Sale
+			entry.Customer = item.Sale.Customer
+		}
+
+		history = append(history, entry)
+	}
+
+	context.JSON(http.StatusOK, history)
+}
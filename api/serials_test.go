@@ -0,0 +1,279 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+)
+
+func TestSerial(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Component{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.StockReservation{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+	db.AutoMigrate(&models.Purchase{})
+	db.AutoMigrate(&models.PurchaseLine{})
+	db.AutoMigrate(&models.Sale{})
+	db.AutoMigrate(&models.Item{})
+	db.AutoMigrate(&models.Serial{})
+
+	t.Cleanup(database.Cleanup)
+
+	// Stock isn't what's under test here
+	db.Create(&models.Company{Name: "Testing Company", NegativeStock: models.AllowNegativeStock})
+
+	cash := &models.Account{Name: "Cash", Type: models.Asset, CompanyID: 1}
+	db.Create(cash)
+
+	inventory := &models.Account{Name: "Inventory", Type: models.Asset, CompanyID: 1}
+	db.Create(inventory)
+
+	revenue := &models.Account{Name: "Revenue", Type: models.Revenue, CompanyID: 1}
+	db.Create(revenue)
+
+	cogs := &models.Account{Name: "Cost of Goods Sold", Type: models.Expense, CompanyID: 1}
+	db.Create(cogs)
+
+	db.Create(&models.Vendor{Name: "Vendor", CompanyID: 1})
+	db.Create(&models.Customer{Name: "Customer", CompanyID: 1})
+
+	db.Create(&models.Product{
+		Name:                "Phone",
+		Price:               1000,
+		Purchasable:         true,
+		Serialized:          true,
+		RevenueAccountID:    &revenue.ID,
+		CostOfSaleAccountID: &cogs.ID,
+		InventoryAccountID:  inventory.ID,
+		CompanyID:           1,
+	})
+
+	router := api.GetRouter()
+
+	purchase := func(qty int, serials ...string) *httptest.ResponseRecorder {
+		numbers := []map[string]interface{}{}
+		for _, serial := range serials {
+			numbers = append(numbers, map[string]interface{}{"Number": serial})
+		}
+
+		req := Post(t, "/purchases", map[string]interface{}{
+			"VendorID":         1,
+			"Paid":             true,
+			"PaymentDate":      time.Now(),
+			"PaymentAccountID": cash.ID,
+			"Lines": []map[string]interface{}{
+				{"Qty": qty, "Price": 600, "ProductID": 1, "Serials": numbers},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	sale := func(method string, url string, serial string) *httptest.ResponseRecorder {
+		body := map[string]interface{}{
+			"Paid":             true,
+			"CustomerID":       1,
+			"PaymentAccountID": cash.ID,
+			"Items": []map[string]interface{}{
+				{"Qty": 1, "Price": 1000, "ProductID": 1, "Serials": []map[string]interface{}{
+					{"Number": serial},
+				}},
+			},
+		}
+
+		req := Post(t, url, body)
+		if method == http.MethodPut {
+			req = Put(t, url, body)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	errors := func(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+		return response
+	}
+
+	t.Run("Purchase without a serial per unit", func(t *testing.T) {
+		w := purchase(2, "SN1")
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Lines.0.Serials"] != api.ErrSerialsMismatch.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrSerialsMismatch.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Purchase", func(t *testing.T) {
+		w := purchase(2, "SN1", "SN2")
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var purchase *models.Purchase
+		if err := json.Unmarshal(w.Body.Bytes(), &purchase); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(purchase.Lines[0].Serials) != 2 {
+			t.Errorf("Expected %v serials, got %v", 2, len(purchase.Lines[0].Serials))
+		}
+	})
+
+	t.Run("Purchase serial twice", func(t *testing.T) {
+		w := purchase(1, "SN1")
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Lines.0.Serials.0"] != api.ErrSerialExists.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrSerialExists.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Sell unknown serial", func(t *testing.T) {
+		w := sale(http.MethodPost, "/sales", "SN3")
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Items.0.Serials.0"] != api.ErrSerialNotFound.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrSerialNotFound.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Sell", func(t *testing.T) {
+		w := sale(http.MethodPost, "/sales", "SN1")
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var sale *models.Sale
+		if err := json.Unmarshal(w.Body.Bytes(), &sale); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(sale.Items[0].Serials) != 1 || sale.Items[0].Serials[0].Number != "SN1" {
+			t.Errorf("Expected item to be sold with serial %v, got %v", "SN1", sale.Items[0].Serials)
+		}
+	})
+
+	t.Run("Sell serial twice", func(t *testing.T) {
+		w := sale(http.MethodPost, "/sales", "SN1")
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Items.0.Serials.0"] != api.ErrSerialSold.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrSerialSold.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Update sale keeping its serial", func(t *testing.T) {
+		w := sale(http.MethodPut, "/sales/1", "SN1")
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("Lookup sold serial", func(t *testing.T) {
+		req := Get(t, "/serials/SN1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var history []struct {
+			Serial   *models.Serial
+			Product  *models.Product
+			Purchase *models.Purchase
+			Vendor   *models.Vendor
+			Sale     *models.Sale
+			Customer *models.Customer
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(history) != 1 {
+			t.Fatalf("Expected %v serial, got %v", 1, len(history))
+		}
+
+		if history[0].Product == nil || history[0].Purchase == nil || history[0].Vendor == nil {
+			t.Error("Expected product, purchase and vendor to be retrieved")
+		}
+
+		if history[0].Sale == nil || history[0].Customer == nil {
+			t.Error("Expected sale and customer to be retrieved")
+		}
+	})
+
+	t.Run("Lookup serial in stock", func(t *testing.T) {
+		req := Get(t, "/serials/SN2")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var history []struct {
+			Purchase *models.Purchase
+			Sale     *models.Sale
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(history) != 1 || history[0].Purchase == nil || history[0].Sale != nil {
+			t.Errorf("Expected a purchased and unsold serial, got %v", w.Body.String())
+		}
+	})
+
+	t.Run("Lookup unknown serial", func(t *testing.T) {
+		req := Get(t, "/serials/SN3")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %v, got %v", http.StatusNotFound, w.Code)
+		}
+	})
+}
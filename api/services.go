@@ -1,14 +1,20 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"example.com/accounting/database"
+	"example.com/accounting/events"
 	"example.com/accounting/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
+var ErrCustomerNotFound = errors.New("Customer not found")
+
 func RegisterServicesEndpoints(router *gin.Engine) {
 	group := router.Group("/services")
 	group.POST("", createService)
@@ -172,6 +178,29 @@ func createPerformed(context *gin.Context) {
 
 	performed.CompanyID = context.Value("CompanyID").(uint)
 
+	if errs := checkPerformedCustomer(db, performed); errs != nil {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	// Taxes are charged and withheld when the service is posted
+	performed.Taxes = nil
+	performed.Withholdings = nil
+
+	var company *models.Company
+	db.First(&company, performed.CompanyID)
+
+	shortages := checkStock(db, performed.CompanyID, "service_performeds", 0, consumptionStockRequests(performed))
+
+	if len(shortages) > 0 && company.NegativeStock == models.RejectNegativeStock {
+		context.JSON(http.StatusBadRequest, shortages)
+		return
+	}
+
+	if company.NegativeStock == models.WarnNegativeStock {
+		performed.Warnings = shortages
+	}
+
 	if db.Create(&performed).Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
@@ -200,16 +229,36 @@ func updatePerformed(context *gin.Context) {
 	companyID := context.Value("CompanyID").(uint)
 
 	tx := db.Scopes(models.FromCompany(companyID))
-	if tx.Preload("Entries").Preload("StockUsages").First(&performed, id).Error != nil {
+	tx = tx.Preload("Entries").Preload("StockUsages").Preload("StockShortages").Preload("Taxes").Preload("Withholdings")
+
+	if tx.First(&performed, id).Error != nil {
 		context.Status(http.StatusNotFound)
 		return
 	}
 
+	if db.Where("service_performed_id = ?", performed.ID).First(&models.RPS{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"error": ErrPerformedHasRPS.Error(),
+		})
+		return
+	}
+
+	taxes := performed.Taxes
+	withholdings := performed.Withholdings
+
 	if err := context.ShouldBindJSON(&performed); err != nil {
 		context.JSON(http.StatusBadRequest, Errors(err))
 		return
 	}
 
+	performed.Taxes = []*models.Tax{}
+	performed.Withholdings = []*models.Withholding{}
+
+	if errs := checkPerformedCustomer(db, performed); errs != nil {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
 	if performed.Paid && performed.PaymentAccountID == nil {
 		context.JSON(http.StatusBadRequest, gin.H{
 			"error": ErrPaymentAccountMissing.Error(),
@@ -224,6 +273,35 @@ func updatePerformed(context *gin.Context) {
 		return
 	}
 
+	var company *models.Company
+	db.First(&company, companyID)
+
+	// The stock currently used by the service is available to its consumptions
+	shortages := checkStock(db, companyID, "service_performeds", performed.ID, consumptionStockRequests(performed))
+
+	if len(shortages) > 0 && company.NegativeStock == models.RejectNegativeStock {
+		context.JSON(http.StatusBadRequest, shortages)
+		return
+	}
+
+	if company.NegativeStock == models.WarnNegativeStock {
+		performed.Warnings = shortages
+	}
+
+	// Remove current taxes and withholdings, they're charged again along the
+	// entries
+	taxIDs := []uint{}
+	for _, tax := range taxes {
+		taxIDs = append(taxIDs, tax.ID)
+	}
+	db.Unscoped().Delete(&taxes, taxIDs)
+
+	withholdingIDs := []uint{}
+	for _, withholding := range withholdings {
+		withholdingIDs = append(withholdingIDs, withholding.ID)
+	}
+	db.Unscoped().Delete(&withholdings, withholdingIDs)
+
 	// Remove current accounting entries
 	entryIDs := []uint{}
 	for _, entry := range performed.Entries {
@@ -240,6 +318,14 @@ func updatePerformed(context *gin.Context) {
 	db.Unscoped().Delete(&performed.StockUsages, usageIDs)
 	performed.StockUsages = []*models.StockUsage{}
 
+	// Remove current stock shortages
+	shortageIDs := []uint{}
+	for _, shortage := range performed.StockShortages {
+		shortageIDs = append(shortageIDs, shortage.ID)
+	}
+	db.Unscoped().Delete(&performed.StockShortages, shortageIDs)
+	performed.StockShortages = []*models.StockShortage{}
+
 	if db.Save(performed).Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
@@ -273,7 +359,14 @@ func deletePerformed(context *gin.Context) {
 		return
 	}
 
-	tx = db.Unscoped().Select("Entries", "StockUsages", "Consumptions")
+	if db.Where("service_performed_id = ?", performed.ID).First(&models.RPS{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"error": ErrPerformedHasRPS.Error(),
+		})
+		return
+	}
+
+	tx = db.Unscoped().Select("Entries", "StockUsages", "StockShortages", "Consumptions", "Taxes", "Withholdings")
 	if tx.Delete(&performed).Error != nil {
 		context.Status(http.StatusInternalServerError)
 		return
@@ -295,28 +388,62 @@ func createServiceEntries(performed *models.ServicePerformed) {
 		account = *performed.ReceivableAccountID
 	}
 
+	rules := companyTaxRules(db, performed.CompanyID)
+	performed.Taxes = models.ServiceTaxes(rules, service, performed.Value)
+
+	// Customers withhold taxes from what they pay, which the company
+	// recovers later
+	withholdingRules := []*models.WithholdingRule{}
+	if performed.CustomerID != nil {
+		var customer *models.Customer
+		db.Scopes(models.FromCompany(performed.CompanyID)).First(&customer, *performed.CustomerID)
+
+		db.Scopes(models.FromCompany(performed.CompanyID)).Find(&withholdingRules)
+		performed.Withholdings = models.Withhold(withholdingRules, service, customer, performed.Value)
+	}
+
+	transactions := []*models.Transaction{
+		{AccountID: service.RevenueAccountID, Value: performed.Value},
+		{AccountID: account, Value: performed.Receivable()},
+	}
+
+	for _, withholding := range performed.Withholdings {
+		for _, rule := range withholdingRules {
+			if rule.ID == *withholding.WithholdingRuleID {
+				transactions = append(transactions, &models.Transaction{
+					AccountID: rule.RecoverableAccountID,
+					Value:     withholding.Value,
+				})
+			}
+		}
+	}
+
 	performed.Entries = append(performed.Entries, &models.Entry{
-		Description: "Service performed",
-		CompanyID:   performed.CompanyID,
-		Transactions: []*models.Transaction{
-			{AccountID: service.RevenueAccountID, Value: performed.Value},
-			{AccountID: account, Value: performed.Value},
-		},
+		Description:  "Service performed",
+		CompanyID:    performed.CompanyID,
+		Transactions: append(transactions, taxTransactions(rules, performed.Taxes)...),
 	})
 
+	loader := newStockLoader(db)
 	for _, consumption := range performed.Consumptions {
-		var prod *models.Product
-		db.Joins("Company").Preload("StockEntries").First(&prod, consumption.ProductID)
+		transactions := []*models.Transaction{}
+		cost := 0.0
 
-		cost := prod.Cost(consumption.Qty)
+		for _, draw := range loader.consume(consumption.ProductID, consumption.Qty) {
+			cost += draw.Cost
+			transactions = append(transactions, &models.Transaction{
+				AccountID: draw.Product.InventoryAccountID,
+				Value:     -draw.Cost,
+			})
+		}
 
 		performed.Entries = append(performed.Entries, &models.Entry{
 			Description: "Usage for service",
 			CompanyID:   performed.CompanyID,
-			Transactions: []*models.Transaction{
-				{AccountID: prod.InventoryAccountID, Value: -cost},
-				{AccountID: service.CostOfServiceAccountID, Value: cost},
-			},
+			Transactions: append(transactions, &models.Transaction{
+				AccountID: service.CostOfServiceAccountID,
+				Value:     cost,
+			}),
 		})
 	}
 
@@ -326,13 +453,48 @@ func createServiceEntries(performed *models.ServicePerformed) {
 func reduceConsumptionStock(performed *models.ServicePerformed) {
 	db, _ := database.GetConnection()
 
+	var service *models.Service
+	db.First(&service, performed.ServiceID)
+
+	loader := newStockLoader(db)
 	for _, item := range performed.Consumptions {
-		var product *models.Product
-		db.Joins("Company").Preload("StockEntries").First(&product, item.ProductID)
+		for _, draw := range loader.consume(item.ProductID, item.Qty) {
+			performed.StockUsages = append(performed.StockUsages, draw.Usages...)
+
+			if shortage := stockShortage(draw.Usages, draw.Qty, draw.Product, service.CostOfServiceAccountID); shortage != nil {
+				performed.StockShortages = append(performed.StockShortages, shortage)
+			}
+		}
+	}
 
-		usages := product.Consume(item.Qty)
-		performed.StockUsages = append(performed.StockUsages, usages...)
+	for _, product := range loader.droppedBelowMinimum() {
+		events.Dispatch(events.ProductBelowMinimum, product)
 	}
 
 	db.Save(&performed)
 }
+
+func consumptionStockRequests(performed *models.ServicePerformed) []stockRequest {
+	requests := []stockRequest{}
+	for idx, consumption := range performed.Consumptions {
+		requests = append(requests, stockRequest{
+			Field:     fmt.Sprintf("Consumptions.%d.Qty", idx),
+			ProductID: consumption.ProductID,
+			Qty:       consumption.Qty,
+		})
+	}
+	return requests
+}
+
+// checkPerformedCustomer returns an error when the customer of the service
+// isn't one of the company's.
+func checkPerformedCustomer(db *gorm.DB, performed *models.ServicePerformed) gin.H {
+	if performed.CustomerID == nil {
+		return nil
+	}
+
+	if db.Scopes(models.FromCompany(performed.CompanyID)).First(&models.Customer{}, *performed.CustomerID).Error != nil {
+		return gin.H{"CustomerID": ErrCustomerNotFound.Error()}
+	}
+	return nil
+}
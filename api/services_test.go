@@ -23,10 +23,18 @@ func TestServices(t *testing.T) {
 	db.AutoMigrate(&models.Service{})
 	db.AutoMigrate(&models.Account{})
 	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Component{})
 	db.AutoMigrate(&models.StockEntry{})
 	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.StockReservation{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+	db.AutoMigrate(&models.WithholdingRule{})
+	db.AutoMigrate(&models.Withholding{})
 	db.AutoMigrate(&models.Transaction{})
 	db.AutoMigrate(&models.Consumption{})
+	db.AutoMigrate(&models.Customer{})
 	db.AutoMigrate(&models.ServicePerformed{})
 
 	db.Create(&models.Company{Name: "Testing Company"})
@@ -393,6 +401,74 @@ func TestServices(t *testing.T) {
 		}
 	})
 
+	t.Run("Perform without enough stock", func(t *testing.T) {
+		req := Post(t, "/services/performed", map[string]interface{}{
+			"Paid":                true,
+			"Value":               122,
+			"ServiceID":           1,
+			"PaymentAccountID":    &cash.ID,
+			"ReceivableAccountID": nil,
+			"Consumptions": []map[string]interface{}{
+				{"ProductID": 1, "Qty": 200},
+				{"ProductID": 1, "Qty": 200},
+			},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if _, ok := response["Consumptions.0.Qty"]; ok {
+			t.Error("Expected first consumption to have enough stock")
+		}
+
+		if response["Consumptions.1.Qty"] != api.ErrNotEnoughStock.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrNotEnoughStock.Error(), response["Consumptions.1.Qty"])
+		}
+	})
+
+	t.Run("Perform for a customer of another company", func(t *testing.T) {
+		customer := &models.Customer{Name: "Other customer", CompanyID: 2}
+		db.Create(customer)
+
+		body := map[string]interface{}{
+			"Paid":             true,
+			"Value":            122,
+			"ServiceID":        1,
+			"PaymentAccountID": &cash.ID,
+			"CustomerID":       customer.ID,
+			"Consumptions": []map[string]interface{}{
+				{"ProductID": 1, "Qty": 1},
+			},
+		}
+
+		for _, req := range []*http.Request{Post(t, "/services/performed", body), Put(t, "/services/performed/1", body)} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+			}
+
+			var response map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Error("Failed parsing JSON", err)
+			}
+
+			if response["CustomerID"] != api.ErrCustomerNotFound.Error() {
+				t.Errorf("Expected error %v, got %v", api.ErrCustomerNotFound.Error(), response["CustomerID"])
+			}
+		}
+	})
+
 	t.Run("Update performed", func(t *testing.T) {
 		bank := &models.Account{Name: "Bank", Type: models.Asset, CompanyID: 1}
 		db.Create(bank)
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotSimples        = errors.New("Company is not under Simples Nacional")
+	ErrAnnexMissing      = errors.New("Company has no Simples Nacional annex")
+	ErrRevenueOverLimit  = errors.New("Revenue is over the Simples Nacional limit")
+	ErrDASAlreadyPosted  = errors.New("DAS was already posted for this month")
+	ErrDASAccountMissing = errors.New("Account is required")
+)
+
+func RegisterSimplesEndpoints(router *gin.Engine) {
+	group := router.Group("/simples/das")
+
+	group.POST("", postDAS)
+	group.GET("", listDAS)
+	group.GET("/:month", viewDAS)
+	group.DELETE("/:month", deleteDAS)
+}
+
+type dasPosting struct {
+	Month            string `binding:"required"`
+	ExpenseAccountID uint   `binding:"required"`
+	PayableAccountID uint   `binding:"required"`
+}
+
+// companyRevenue sums what was posted to the revenue accounts of the company
+// between the given dates.
+func companyRevenue(db *gorm.DB, companyID uint, from time.Time, to time.Time) float64 {
+	revenue := 0.0
+
+	db.Model(&models.Transaction{}).
+		Joins("JOIN accounts ON accounts.id = transactions.account_id").
+		Joins("JOIN entries ON entries.id = transactions.entry_id AND entries.deleted_at IS NULL").
+		Where("accounts.type = ? AND accounts.company_id = ?", models.Revenue, companyID).
+		Where("entries.created_at >= ? AND entries.created_at < ?", from, to).
+		Select("COALESCE(SUM(transactions.value), 0)").
+		Scan(&revenue)
+
+	return revenue
+}
+
+// activityMonths returns the months of activity of the company before the
+// month starting at start, counted from its first revenue, up to 12.
+func activityMonths(db *gorm.DB, companyID uint, start time.Time) int {
+	var first *models.Entry
+	err := db.Model(&models.Entry{}).
+		Joins("JOIN transactions ON transactions.entry_id = entries.id").
+		Joins("JOIN accounts ON accounts.id = transactions.account_id").
+		Where("accounts.type = ? AND accounts.company_id = ?", models.Revenue, companyID).
+		Where("entries.created_at < ?", start).
+		Order("entries.created_at").
+		First(&first).Error
+	if err != nil {
+		return 0
+	}
+
+	since := first.CreatedAt.In(start.Location())
+	months := (start.Year()-since.Year())*12 + int(start.Month()-since.Month())
+	if months > 12 {
+		months = 12
+	}
+	return months
+}
+
+// calculateDAS computes the DAS of the company for the month, given as
+// YYYY-MM, from the revenue of the month and the 12 months before.
+func calculateDAS(db *gorm.DB, companyID uint, month string) (*models.DAS, gin.H) {
+	start, err := time.ParseInLocation("2006-01", month, time.Local)
+	if err != nil {
+		return nil, gin.H{"Month": ErrInvalidMonth.Error()}
+	}
+
+	var company *models.Company
+	db.First(&company, companyID)
+
+	if company.Regime != models.SimplesNacional {
+		return nil, gin.H{"Regime": ErrNotSimples.Error()}
+	}
+
+	if _, ok := models.SimplesTable[company.Annex]; !ok {
+		return nil, gin.H{"Annex": ErrAnnexMissing.Error()}
+	}
+
+	end := start.AddDate(0, 1, 0)
+	trailing := companyRevenue(db, companyID, start.AddDate(-1, 0, 0), start)
+	revenue := companyRevenue(db, companyID, start, end)
+
+	das := models.NewDAS(company.Annex, start, trailing, activityMonths(db, companyID, start), revenue)
+	if das == nil {
+		return nil, gin.H{"TrailingRevenue": ErrRevenueOverLimit.Error()}
+	}
+
+	das.CompanyID = companyID
+	return das, nil
+}
+
+func postDAS(context *gin.Context) {
+	var posting *dasPosting
+	if err := context.ShouldBindJSON(&posting); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	das, errs := calculateDAS(db, companyID, posting.Month)
+	if errs != nil {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	tx := db.Scopes(models.FromCompany(companyID)).Where("month = ?", das.Month)
+	if tx.First(&models.DAS{}).Error == nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Month": ErrDASAlreadyPosted.Error(),
+		})
+		return
+	}
+
+	accounts := map[string]uint{
+		"ExpenseAccountID": posting.ExpenseAccountID,
+		"PayableAccountID": posting.PayableAccountID,
+	}
+
+	for field, id := range accounts {
+		if db.Scopes(models.FromCompany(companyID)).First(&models.Account{}, id).Error != nil {
+			context.JSON(http.StatusBadRequest, gin.H{
+				field: ErrDASAccountMissing.Error(),
+			})
+			return
+		}
+	}
+
+	das.ExpenseAccountID = &posting.ExpenseAccountID
+	das.PayableAccountID = &posting.PayableAccountID
+
+	if das.Value > 0 {
+		das.Entry = &models.Entry{
+			CompanyID:   companyID,
+			Description: fmt.Sprintf("DAS %s", das.Month.Format("01/2006")),
+			Transactions: []*models.Transaction{
+				{Value: das.Value, AccountID: posting.ExpenseAccountID},
+				{Value: das.Value, AccountID: posting.PayableAccountID},
+			},
+		}
+	}
+
+	if db.Create(&das).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("ExpenseAccount").Joins("PayableAccount").Preload("Entry.Transactions.Account").First(&das)
+	context.JSON(http.StatusOK, das)
+}
+
+func listDAS(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var payments []*models.DAS
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Order("month")
+	if tx.Find(&payments).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, payments)
+}
+
+// viewDAS returns the DAS posted for the month, or computes it when it
+// wasn't posted yet.
+func viewDAS(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	das, errs := calculateDAS(db, companyID, context.Param("month"))
+	if errs != nil {
+		context.JSON(http.StatusBadRequest, errs)
+		return
+	}
+
+	var posted *models.DAS
+	tx := db.Scopes(models.FromCompany(companyID)).Where("month = ?", das.Month)
+	tx = tx.Joins("ExpenseAccount").Joins("PayableAccount").Preload("Entry.Transactions.Account")
+
+	if tx.First(&posted).Error == nil {
+		context.JSON(http.StatusOK, posted)
+		return
+	}
+
+	context.JSON(http.StatusOK, das)
+}
+
+func deleteDAS(context *gin.Context) {
+	start, err := time.ParseInLocation("2006-01", context.Param("month"), time.Local)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var das *models.DAS
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Where("month = ?", start).Preload("Entry")
+	if tx.First(&das).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if das.Entry != nil {
+		db.Unscoped().Delete(das.Entry)
+	}
+
+	if db.Unscoped().Delete(&das).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
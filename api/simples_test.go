@@ -0,0 +1,279 @@
+package api_test
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+)
+
+func TestSimples(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.DAS{})
+
+	t.Cleanup(database.Cleanup)
+
+	db.Create(&models.Company{Name: "Testing Company", Regime: models.SimplesNacional, Annex: models.AnnexI})
+	db.Create(&models.Company{Name: "Other company", Regime: models.SimplesNacional, Annex: models.AnnexI})
+
+	cash := &models.Account{Name: "Cash", Type: models.Asset, CompanyID: 1}
+	db.Create(cash)
+
+	revenue := &models.Account{Name: "Revenue", Type: models.Revenue, CompanyID: 1}
+	db.Create(revenue)
+
+	expense := &models.Account{Name: "Simples Nacional", Type: models.Expense, CompanyID: 1}
+	db.Create(expense)
+
+	payable := &models.Account{Name: "DAS payable", Type: models.Liability, CompanyID: 1}
+	db.Create(payable)
+
+	otherRevenue := &models.Account{Name: "Revenue", Type: models.Revenue, CompanyID: 2}
+	db.Create(otherRevenue)
+
+	earn := func(value float64, accountID uint, companyID uint, date time.Time) {
+		entry := &models.Entry{
+			Description: "Sale",
+			CompanyID:   companyID,
+			Transactions: []*models.Transaction{
+				{Value: value, AccountID: accountID},
+				{Value: value, AccountID: cash.ID},
+			},
+		}
+		db.Create(entry)
+		db.Model(entry).Update("created_at", date)
+	}
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.Local)
+	}
+
+	earn(1000000, revenue.ID, 1, date(2025, time.August, 20))
+	earn(300000, revenue.ID, 1, date(2026, time.March, 10))
+	earn(30000, revenue.ID, 1, date(2026, time.September, 15))
+	earn(60000, otherRevenue.ID, 2, date(2026, time.July, 5))
+	earn(90000, otherRevenue.ID, 2, date(2026, time.August, 5))
+	earn(500000, otherRevenue.ID, 2, date(2026, time.September, 15))
+
+	router := api.GetRouter()
+
+	errors := func(t *testing.T, w *httptest.ResponseRecorder) map[string]string {
+		var response map[string]string
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+		return response
+	}
+
+	t.Run("Calculate", func(t *testing.T) {
+		req := Get(t, "/simples/das/2026-09")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var das *models.DAS
+		if err := json.Unmarshal(w.Body.Bytes(), &das); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if das.TrailingRevenue != 300000 || das.Revenue != 30000 {
+			t.Errorf("Expected revenues %v and %v, got %v and %v", 300000, 30000, das.TrailingRevenue, das.Revenue)
+		}
+
+		// (300000 * 7.3% - 5940) / 300000
+		if math.Round(das.EffectiveRate*100)/100 != 5.32 {
+			t.Errorf("Expected effective rate %v, got %v", 5.32, das.EffectiveRate)
+		}
+
+		if das.Value != 1596 {
+			t.Errorf("Expected value %v, got %v", 1596, das.Value)
+		}
+
+		if das.ID != 0 {
+			t.Error("Expected DAS not to be posted")
+		}
+	})
+
+	t.Run("Calculate without previous revenue", func(t *testing.T) {
+		req := Get(t, "/simples/das/2025-08")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		// Annualized to 12 million, which is over the limit
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["TrailingRevenue"] != api.ErrRevenueOverLimit.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrRevenueOverLimit.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Calculate in the third month", func(t *testing.T) {
+		req := Get(t, "/simples/das/2026-09")
+		req.Header.Set("CompanyID", "2")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		var das *models.DAS
+		if err := json.Unmarshal(w.Body.Bytes(), &das); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		// The average of July and August, annualized
+		if das.TrailingRevenue != 900000 || das.Revenue != 500000 {
+			t.Errorf("Expected revenues %v and %v, got %v and %v", 900000, 500000, das.TrailingRevenue, das.Revenue)
+		}
+
+		// (900000 * 10.7% - 22500) / 900000
+		if math.Round(das.EffectiveRate*100)/100 != 8.2 {
+			t.Errorf("Expected effective rate %v, got %v", 8.2, das.EffectiveRate)
+		}
+
+		if das.Value != 41000 {
+			t.Errorf("Expected value %v, got %v", 41000, das.Value)
+		}
+	})
+
+	t.Run("Calculate invalid month", func(t *testing.T) {
+		req := Get(t, "/simples/das/september")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Month"] != api.ErrInvalidMonth.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrInvalidMonth.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("Post", func(t *testing.T) {
+		req := Post(t, "/simples/das", map[string]interface{}{
+			"Month":            "2026-09",
+			"ExpenseAccountID": expense.ID,
+			"PayableAccountID": payable.ID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var das *models.DAS
+		if err := json.Unmarshal(w.Body.Bytes(), &das); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if das.Entry == nil || len(das.Entry.Transactions) != 2 {
+			t.Fatal("Expected entry with expense and payable transactions")
+		}
+
+		var account *models.Account
+		db.Preload("Transactions").First(&account, payable.ID)
+
+		if account.Balance() != 1596 {
+			t.Errorf("Expected balance %v, got %v", 1596, account.Balance())
+		}
+	})
+
+	t.Run("Post twice", func(t *testing.T) {
+		req := Post(t, "/simples/das", map[string]interface{}{
+			"Month":            "2026-09",
+			"ExpenseAccountID": expense.ID,
+			"PayableAccountID": payable.ID,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Month"] != api.ErrDASAlreadyPosted.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrDASAlreadyPosted.Error(), w.Body.String())
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := Get(t, "/simples/das")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var payments []*models.DAS
+		if err := json.Unmarshal(w.Body.Bytes(), &payments); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(payments) != 1 || payments[0].Value != 1596 {
+			t.Errorf("Expected the posted DAS, got %v", w.Body.String())
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := Delete(t, "/simples/das/2026-09")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected status %v, got %v", http.StatusNoContent, w.Code)
+		}
+
+		var account *models.Account
+		db.Preload("Transactions").First(&account, payable.ID)
+
+		if account.Balance() != 0 {
+			t.Errorf("Expected balance %v, got %v", 0, account.Balance())
+		}
+	})
+
+	t.Run("Company not under Simples", func(t *testing.T) {
+		db.Model(&models.Company{}).Where("id = ?", 1).Update("regime", models.CumulativeRegime)
+
+		req := Get(t, "/simples/das/2026-09")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if errors(t, w)["Regime"] != api.ErrNotSimples.Error() {
+			t.Errorf("Expected error %v, got %v", api.ErrNotSimples.Error(), w.Body.String())
+		}
+	})
+}
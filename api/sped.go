@@ -0,0 +1,182 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"example.com/accounting/nfe"
+	"example.com/accounting/sped"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+var ErrResultAccountMissing = errors.New("Equity account is required to close the results")
+
+func RegisterSpedEndpoints(router *gin.Engine) {
+	group := router.Group("/sped")
+
+	group.GET("/ecd", exportECD)
+	group.GET("/efd/inventory", exportEFDInventory)
+}
+
+type accountBalance struct {
+	AccountID uint
+	Value     float64
+}
+
+// accountBalances sums the transactions of each account of the company
+// posted between the given dates.
+func accountBalances(db *gorm.DB, companyID uint, from time.Time, to time.Time) map[uint]float64 {
+	var rows []*accountBalance
+
+	db.Model(&models.Transaction{}).
+		Joins("JOIN entries ON entries.id = transactions.entry_id AND entries.deleted_at IS NULL").
+		Where("entries.company_id = ?", companyID).
+		Where("entries.created_at >= ? AND entries.created_at < ?", from, to).
+		Group("transactions.account_id").
+		Select("transactions.account_id, SUM(transactions.value) AS value").
+		Scan(&rows)
+
+	balances := map[uint]float64{}
+	for _, row := range rows {
+		balances[row.AccountID] = row.Value
+	}
+	return balances
+}
+
+// exportECD writes the SPED Contábil of the fiscal year of the company, its
+// results closed into the given equity account.
+func exportECD(context *gin.Context) {
+	year, err := strconv.Atoi(context.DefaultQuery("year", strconv.Itoa(time.Now().Year()-1)))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"year": ErrInvalidYear.Error(),
+		})
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	var company *models.Company
+	db.First(&company, companyID)
+
+	if len(nfe.Digits(company.Cnpj)) != 14 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Company.Cnpj": ErrCnpjMissing.Error(),
+		})
+		return
+	}
+
+	// The equity account the results are closed into
+	var resultAccount *models.Account
+	resultAccountID, err := strconv.ParseUint(context.Query("result_account"), 10, 64)
+	tx := db.Scopes(models.FromCompany(companyID)).Where("type = ?", models.Equity)
+	if err != nil || tx.First(&resultAccount, resultAccountID).Error != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"result_account": ErrResultAccountMissing.Error(),
+		})
+		return
+	}
+
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+	end := start.AddDate(1, 0, 0)
+
+	// Deleted accounts may still have postings in the period
+	var accounts []*models.Account
+	db.Unscoped().Scopes(models.FromCompany(companyID)).Order("code, id").Find(&accounts)
+
+	var entries []*models.Entry
+	tx = db.Scopes(models.FromCompany(companyID)).Preload("Transactions")
+	tx.Where("created_at >= ? AND created_at < ?", start, end).Order("created_at, id").Find(&entries)
+
+	file := sped.ECD(&sped.Ledger{
+		Company:         company,
+		Start:           start,
+		End:             end,
+		Accounts:        accounts,
+		Opening:         accountBalances(db, companyID, time.Time{}, start),
+		Previous:        accountBalances(db, companyID, start.AddDate(-1, 0, 0), start),
+		Entries:         entries,
+		ResultAccountID: resultAccount.ID,
+	})
+
+	context.Header("Content-Disposition", fmt.Sprintf("attachment; filename=ECD-%d.txt", year))
+	context.Data(http.StatusOK, "text/plain; charset=utf-8", file)
+}
+
+// exportEFDInventory writes the EFD ICMS/IPI declaring the inventory at the
+// date, valued from the stock layers as they were then. The inventory of
+// the end of the year is declared in the file of February, two months
+// later, which is the default period.
+func exportEFDInventory(context *gin.Context) {
+	lastYear := time.Date(time.Now().Year()-1, time.December, 31, 0, 0, 0, 0, time.Local)
+
+	date, err := time.ParseInLocation("2006-01-02", context.DefaultQuery("date", lastYear.Format("2006-01-02")), time.Local)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"date": ErrInvalidDate.Error(),
+		})
+		return
+	}
+
+	february := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.Local).AddDate(0, 2, 0)
+
+	start, err := time.ParseInLocation("2006-01", context.DefaultQuery("month", february.Format("2006-01")), time.Local)
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"month": ErrInvalidMonth.Error(),
+		})
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	var company *models.Company
+	db.First(&company, companyID)
+
+	if len(nfe.Digits(company.Cnpj)) != 14 {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"Company.Cnpj": ErrCnpjMissing.Error(),
+		})
+		return
+	}
+
+	until := date.AddDate(0, 0, 1)
+
+	var products []*models.Product
+	tx := db.Scopes(models.FromCompany(companyID)).Preload("InventoryAccount")
+	tx = tx.Preload("StockEntries", "created_at < ?", until).Preload("StockEntries.StockUsages", "created_at < ?", until)
+
+	if tx.Order("id").Find(&products).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	file := sped.EFDInventory(&sped.Inventory{
+		Company:  company,
+		Start:    start,
+		End:      start.AddDate(0, 1, 0),
+		Date:     date,
+		Products: products,
+	})
+
+	context.Header("Content-Disposition", fmt.Sprintf("attachment; filename=EFD-%s.txt", start.Format("2006-01")))
+	context.Data(http.StatusOK, "text/plain; charset=utf-8", file)
+}
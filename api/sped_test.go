@@ -0,0 +1,363 @@
+package api_test
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+)
+
+func TestECD(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Company{})
+	db.AutoMigrate(&models.Account{})
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+
+	t.Cleanup(database.Cleanup)
+
+	db.Create(&models.Company{
+		Name:   "Testing Company",
+		Cnpj:   "11.222.333/0001-81",
+		Regime: models.NonCumulativeRegime,
+		Address: &models.Address{
+			City:     "São Paulo",
+			State:    "SP",
+			CityCode: "3550308",
+		},
+	})
+
+	assets := &models.Account{Name: "Ativo", Code: "1", Type: models.Asset, CompanyID: 1}
+	db.Create(assets)
+
+	cash := &models.Account{Name: "Caixa", Code: "1.1", ReferentialCode: "1.01.01.01.01", Type: models.Asset, ParentID: &assets.ID, CompanyID: 1}
+	db.Create(cash)
+
+	capital := &models.Account{Name: "Capital social", Code: "2.1", Type: models.Equity, CompanyID: 1}
+	db.Create(capital)
+
+	retained := &models.Account{Name: "Lucros acumulados", Code: "2.2", Type: models.Equity, CompanyID: 1}
+	db.Create(retained)
+
+	revenue := &models.Account{Name: "Receita de vendas", Code: "3.1", Type: models.Revenue, CompanyID: 1}
+	db.Create(revenue)
+
+	expense := &models.Account{Name: "Despesas gerais", Code: "4.1", Type: models.Expense, CompanyID: 1}
+	db.Create(expense)
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.Local)
+	}
+
+	entry := func(description string, created time.Time, transactions ...*models.Transaction) {
+		entry := &models.Entry{Description: description, CompanyID: 1, Transactions: transactions}
+		entry.CreatedAt = created
+		db.Create(entry)
+	}
+
+	entry("Capital", date(2025, time.March, 10),
+		&models.Transaction{Value: 1000, AccountID: cash.ID},
+		&models.Transaction{Value: 1000, AccountID: capital.ID})
+	entry("Sale", date(2025, time.June, 5),
+		&models.Transaction{Value: 300, AccountID: cash.ID},
+		&models.Transaction{Value: 300, AccountID: revenue.ID})
+	entry("Sale", date(2026, time.January, 15),
+		&models.Transaction{Value: 500, AccountID: cash.ID},
+		&models.Transaction{Value: 500, AccountID: revenue.ID})
+	entry("Rent", date(2026, time.February, 20),
+		&models.Transaction{Value: 200, AccountID: expense.ID},
+		&models.Transaction{Value: -200, AccountID: cash.ID})
+
+	router := api.GetRouter()
+
+	t.Run("Export", func(t *testing.T) {
+		req := Get(t, fmt.Sprintf("/sped/ecd?year=2026&result_account=%d", retained.ID))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		file := w.Body.String()
+		lines := strings.Split(strings.TrimSuffix(file, "\r\n"), "\r\n")
+
+		expected := []string{
+			"|0000|LECD|01012026|31122026|Testing Company|11222333000181|SP||3550308||",
+			fmt.Sprintf("|I030|TERMO DE ABERTURA|1|DIARIO GERAL|%d|Testing Company|", len(lines)),
+			"|I050|" + cash.CreatedAt.Format("02012006") + "|01|A|2|1.1|1|Caixa|",
+			"|I051||1.01.01.01.01|",
+			"|I150|01012026|31012026|",
+			"|I155|1.1||1300,00|D|500,00|0,00|1800,00|D|",
+			"|I155|2.2||300,00|C|0,00|0,00|300,00|C|",
+			"|I155|3.1||0,00|D|0,00|500,00|500,00|C|",
+			"|I155|1.1||1800,00|D|0,00|200,00|1600,00|D|",
+			"|I200|4|20022026|200,00|N||",
+			"|I250|1.1||200,00|C|||Rent||",
+			"|I155|2.2||300,00|C|0,00|300,00|600,00|C|",
+			"|I155|3.1||500,00|C|500,00|0,00|0,00|D|",
+			"|I155|4.1||200,00|D|0,00|200,00|0,00|D|",
+			"|I200|E2026|31122026|500,00|E||",
+			"|I250|3.1||500,00|D|||Encerramento do exercicio||",
+			"|I250|4.1||200,00|C|||Encerramento do exercicio||",
+			"|I250|2.2||300,00|C|||Encerramento do exercicio||",
+			"|I350|31122026|",
+			"|I355|3.1||500,00|C|",
+			"|I355|4.1||200,00|D|",
+			"|J100|1|T|1||A|Ativo|1300,00|D|1600,00|D||",
+			"|J100|2.1|D|1||P|Capital social|1000,00|C|1000,00|C||",
+			"|J100|2.2|D|1||P|Lucros acumulados|300,00|C|600,00|C||",
+			"|J150|1|3.1|D|1||Receita de vendas|300,00|C|500,00|C|R||",
+			"|J150|2|4.1|D|1||Despesas gerais|0,00|D|200,00|D|D||",
+			fmt.Sprintf("|J900|TERMO DE ENCERRAMENTO|1|DIARIO GERAL|Testing Company|%d|01012026|31122026|", len(lines)),
+			"|9900|I155|59|",
+			fmt.Sprintf("|9999|%d|", len(lines)),
+		}
+
+		for _, line := range expected {
+			if !strings.Contains(file, line) {
+				t.Errorf("Expected line %v in:\n%v", line, file)
+			}
+		}
+
+		counts := map[string]int{}
+		for _, line := range lines {
+			counts[line[1:2]]++
+		}
+
+		// Assets balance liabilities and equity at both ends of the year
+		initial, final := 0.0, 0.0
+		for _, line := range lines {
+			fields := strings.Split(line, "|")
+			if fields[1] != "J100" || fields[4] != "1" {
+				continue
+			}
+
+			value := func(amount string, side string) float64 {
+				parsed, _ := strconv.ParseFloat(strings.Replace(amount, ",", ".", 1), 64)
+				if side == "C" {
+					return -parsed
+				}
+				return parsed
+			}
+
+			initial += value(fields[8], fields[9])
+			final += value(fields[10], fields[11])
+		}
+
+		if math.Abs(initial) > 0.001 || math.Abs(final) > 0.001 {
+			t.Errorf("Expected J100 to balance, got differences of %v and %v", initial, final)
+		}
+
+		for block, count := range counts {
+			if block == "9" {
+				continue
+			}
+
+			closing := fmt.Sprintf("|%s990|%d|", block, count)
+			if !strings.Contains(file, closing) {
+				t.Errorf("Expected block %v to close with %v", block, closing)
+			}
+		}
+	})
+
+	t.Run("Export without result account", func(t *testing.T) {
+		req := Get(t, fmt.Sprintf("/sped/ecd?year=2026&result_account=%d", revenue.ID))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+
+		if !strings.Contains(w.Body.String(), api.ErrResultAccountMissing.Error()) {
+			t.Errorf("Expected error %v, got %v", api.ErrResultAccountMissing, w.Body.String())
+		}
+	})
+
+	t.Run("Export invalid year", func(t *testing.T) {
+		req := Get(t, "/sped/ecd?year=last")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Export with a deleted account", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, Delete(t, fmt.Sprintf("/accounts/%d", expense.ID)))
+
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("Expected status %v, got %v", http.StatusNoContent, w.Code)
+		}
+
+		req := Get(t, fmt.Sprintf("/sped/ecd?year=2026&result_account=%d", retained.ID))
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		expected := []string{
+			"|I250|4.1||200,00|D|||Rent||",
+			"|I250|4.1||200,00|C|||Encerramento do exercicio||",
+			"|J150|2|4.1|D|1||Despesas gerais|0,00|D|200,00|D|D||",
+		}
+
+		for _, line := range expected {
+			if !strings.Contains(w.Body.String(), line) {
+				t.Errorf("Expected line %v in:\n%v", line, w.Body.String())
+			}
+		}
+	})
+}
+
+func TestEFDInventory(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Company{})
+	db.AutoMigrate(&models.Account{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
+
+	t.Cleanup(database.Cleanup)
+
+	db.Create(&models.Company{
+		Name: "Testing Company",
+		Cnpj: "11.222.333/0001-81",
+		Address: &models.Address{
+			Street:   "Avenida Paulista",
+			Number:   "1000",
+			City:     "São Paulo",
+			State:    "SP",
+			Postcode: "01310-100",
+			CityCode: "3550308",
+		},
+	})
+
+	inventory := &models.Account{Name: "Estoques", Code: "1.1.3", Type: models.Asset, CompanyID: 1}
+	db.Create(inventory)
+
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 12, 0, 0, 0, time.Local)
+	}
+
+	entry := func(created time.Time, qty float64, price float64, usages ...*models.StockUsage) *models.StockEntry {
+		entry := &models.StockEntry{Qty: qty, Price: price, StockUsages: usages}
+		entry.CreatedAt = created
+		return entry
+	}
+
+	usage := func(created time.Time, qty float64) *models.StockUsage {
+		usage := &models.StockUsage{Qty: qty}
+		usage.CreatedAt = created
+		return usage
+	}
+
+	db.Create(&models.Product{
+		Name:               "Chair",
+		SKU:                "CAD-1",
+		NCM:                "9401.79.00",
+		Price:              100,
+		InventoryAccountID: inventory.ID,
+		CompanyID:          1,
+		StockEntries: []*models.StockEntry{
+			entry(date(2025, time.November, 1), 10, 50,
+				usage(date(2025, time.December, 10), 4),
+				usage(date(2026, time.January, 5), 3)),
+		},
+	})
+
+	db.Create(&models.Product{
+		Name:               "Table",
+		Price:              300,
+		InventoryAccountID: inventory.ID,
+		CompanyID:          1,
+		StockEntries:       []*models.StockEntry{entry(date(2026, time.January, 10), 5, 20)},
+	})
+
+	db.Create(&models.Product{
+		Name:               "Flour",
+		NCM:                "11010010",
+		StockUnit:          "KG",
+		Price:              10,
+		InventoryAccountID: inventory.ID,
+		CompanyID:          1,
+		StockEntries:       []*models.StockEntry{entry(date(2025, time.December, 1), 2.5, 8)},
+	})
+
+	router := api.GetRouter()
+
+	t.Run("Export", func(t *testing.T) {
+		req := Get(t, "/sped/efd/inventory?date=2025-12-31")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v: %v", http.StatusOK, w.Code, w.Body.String())
+		}
+
+		file := w.Body.String()
+		lines := strings.Split(strings.TrimSuffix(file, "\r\n"), "\r\n")
+
+		expected := []string{
+			"|0000|019|0|01022026|28022026|Testing Company|11222333000181||SP||3550308||",
+			"|0190|UN|UN|",
+			"|0190|KG|KG|",
+			"|0200|CAD-1|Chair|||UN|00|94017900|",
+			"|0200|3|Flour|||KG|00|11010010|",
+			"|C001|1|",
+			"|C990|2|",
+			"|H005|31122025|320,00|01|",
+			"|H010|CAD-1|UN|6,000|50,000000|300,00|0|||1.1.3|300,00|",
+			"|H010|3|KG|2,500|8,000000|20,00|0|||1.1.3|20,00|",
+			"|H990|5|",
+			fmt.Sprintf("|9999|%d|", len(lines)),
+		}
+
+		for _, line := range expected {
+			if !strings.Contains(file, line) {
+				t.Errorf("Expected line %v in:\n%v", line, file)
+			}
+		}
+
+		if strings.Contains(file, "Table") {
+			t.Errorf("Expected products acquired after the date to be left out, got:\n%v", file)
+		}
+	})
+
+	t.Run("Export invalid date", func(t *testing.T) {
+		req := Get(t, "/sped/efd/inventory?date=31/12/2025")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+}
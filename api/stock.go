@@ -0,0 +1,285 @@
+package api
+
+import (
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"gorm.io/gorm"
+)
+
+// stockRequest is a quantity of a product a document wants to consume, along
+// with the field it came from so shortages can be reported against it.
+type stockRequest struct {
+	Field     string
+	ProductID uint
+	Qty       float64
+}
+
+// checkStock returns the fields requesting more than the available stock of
+// their products. Quantities for the same product are summed up, and stock
+// used by the given source is considered available, so updates can reuse it.
+// Stock reserved by other sources of the company isn't available. Kits are
+// available as far as their own stock or their components go.
+func checkStock(db *gorm.DB, companyID uint, sourceType string, sourceID uint, requests []stockRequest) map[string]string {
+	shortages := map[string]string{}
+	requested := map[uint]float64{}
+	loader := newStockLoader(db, "source_type <> ? OR source_id <> ?", sourceType, sourceID)
+
+	var request func(field string, productID uint, qty float64)
+	request = func(field string, productID uint, qty float64) {
+		product := loader.product(productID)
+
+		if product.IsKit() {
+			assembled := 0.0
+			if inventory := product.Inventory(); inventory > requested[productID] {
+				assembled = inventory - requested[productID]
+			}
+
+			if assembled >= qty {
+				requested[productID] = models.RoundQty(requested[productID] + qty)
+				return
+			}
+
+			requested[productID] = models.RoundQty(requested[productID] + assembled)
+			for _, component := range product.Components {
+				request(field, component.ComponentID, models.RoundQty((qty-assembled)*component.Qty))
+			}
+			return
+		}
+
+		requested[productID] = models.RoundQty(requested[productID] + qty)
+
+		if product.Inventory() < requested[productID] {
+			shortages[field] = ErrNotEnoughStock.Error()
+		}
+	}
+
+	products := db.Model(&models.Product{}).Scopes(models.FromCompany(companyID))
+	products = products.Where("id IN ?", reservedProducts(db, requests)).Select("id")
+
+	var reservations []*models.StockReservation
+	tx := db.Where("source_type <> ? OR source_id <> ?", sourceType, sourceID)
+	tx.Where("product_id IN (?)", products).Find(&reservations)
+
+	for _, reservation := range reservations {
+		request("", reservation.ProductID, reservation.Qty)
+	}
+	delete(shortages, "")
+
+	for _, r := range requests {
+		request(r.Field, r.ProductID, r.Qty)
+	}
+
+	return shortages
+}
+
+// reservedProducts returns the products whose reservations compete with the
+// requests: the requested ones, their components, and the kits made of any
+// of them.
+func reservedProducts(db *gorm.DB, requests []stockRequest) []uint {
+	visited := map[uint]bool{}
+	pending := []uint{}
+	for _, r := range requests {
+		pending = append(pending, r.ProductID)
+	}
+
+	for len(pending) > 0 {
+		productID := pending[0]
+		pending = pending[1:]
+
+		if visited[productID] {
+			continue
+		}
+		visited[productID] = true
+
+		var components []uint
+		db.Model(&models.Component{}).Where("product_id = ?", productID).Pluck("component_id", &components)
+		pending = append(pending, components...)
+	}
+
+	ids := []uint{}
+	for productID := range visited {
+		ids = append(ids, productID)
+	}
+
+	pending = ids
+	for len(pending) > 0 {
+		var kits []uint
+		db.Model(&models.Component{}).Where("component_id IN ?", pending).Distinct().Pluck("product_id", &kits)
+
+		pending = []uint{}
+		for _, kitID := range kits {
+			if !visited[kitID] {
+				visited[kitID] = true
+				ids = append(ids, kitID)
+				pending = append(pending, kitID)
+			}
+		}
+	}
+
+	return ids
+}
+
+// stockLoader loads products along their stock and components, each one
+// only once, so consecutive draws see the stock consumed by previous ones.
+type stockLoader struct {
+	db         *gorm.DB
+	conditions []interface{}
+	products   map[uint]*models.Product
+	loaded     []uint
+	below      map[uint]bool
+}
+
+// newStockLoader returns a loader considering only the stock usages matching
+// the given conditions, if any.
+func newStockLoader(db *gorm.DB, conditions ...interface{}) *stockLoader {
+	return &stockLoader{
+		db:         db,
+		conditions: conditions,
+		products:   map[uint]*models.Product{},
+		below:      map[uint]bool{},
+	}
+}
+
+func (l *stockLoader) product(productID uint) *models.Product {
+	product, ok := l.products[productID]
+	if !ok {
+		tx := l.db.Joins("Company").Preload("Components")
+		tx.Preload("StockEntries.StockUsages", l.conditions...).First(&product, productID)
+
+		l.products[productID] = product
+		l.loaded = append(l.loaded, productID)
+		l.below[productID] = product.BelowMinimum()
+	}
+	return product
+}
+
+// droppedBelowMinimum returns the products which were above their minimum
+// stock level when loaded, but aren't anymore.
+func (l *stockLoader) droppedBelowMinimum() []*models.Product {
+	products := []*models.Product{}
+	for _, id := range l.loaded {
+		if product := l.products[id]; !l.below[id] && product.BelowMinimum() {
+			products = append(products, product)
+		}
+	}
+	return products
+}
+
+// stockDraw is what was drawn from the stock of a single product.
+type stockDraw struct {
+	Product *models.Product
+	Qty     float64
+	Usages  []*models.StockUsage
+	Cost    float64
+}
+
+// consume draws qty of the product from its stock. Kits draw from their
+// assembled stock first, and whatever it doesn't cover from their components.
+func (l *stockLoader) consume(productID uint, qty float64) []*stockDraw {
+	product := l.product(productID)
+
+	if !product.IsKit() {
+		usages, cost := product.Consume(qty)
+		return []*stockDraw{{product, qty, usages, cost}}
+	}
+
+	draws := []*stockDraw{}
+
+	assembled := product.Inventory()
+	if assembled > qty {
+		assembled = qty
+	}
+
+	if assembled > 0 {
+		usages, cost := product.Consume(assembled)
+		draws = append(draws, &stockDraw{product, assembled, usages, cost})
+	}
+
+	if left := models.RoundQty(qty - assembled); left > 0 {
+		for _, component := range product.Components {
+			draws = append(draws, l.consume(component.ComponentID, models.RoundQty(left*component.Qty))...)
+		}
+	}
+
+	return draws
+}
+
+// stockShortage returns the shortage left when the usages don't cover the
+// requested quantity, or nil if there's enough stock.
+func stockShortage(usages []*models.StockUsage, qty float64, product *models.Product, costAccountID uint) *models.StockShortage {
+	for _, usage := range usages {
+		qty = models.RoundQty(qty - usage.Qty)
+	}
+
+	if qty <= 0 {
+		return nil
+	}
+
+	return &models.StockShortage{
+		Qty:           qty,
+		ProductID:     product.ID,
+		CostAccountID: costAccountID,
+		CompanyID:     product.CompanyID,
+	}
+}
+
+// CostStockShortages consumes the stock brought in by a purchase to cover
+// the pending shortages of its products, oldest first, and books their cost.
+func CostStockShortages(data interface{}) {
+	purchase := data.(*models.Purchase)
+
+	costed := map[uint]bool{}
+	for _, line := range purchase.Lines {
+		if !costed[line.ProductID] {
+			costStockShortages(line.ProductID)
+			costed[line.ProductID] = true
+		}
+	}
+}
+
+func costStockShortages(productID uint) {
+	db, _ := database.GetConnection()
+
+	var shortages []*models.StockShortage
+	db.Where("product_id = ?", productID).Order("created_at, id").Find(&shortages)
+
+	if len(shortages) == 0 {
+		return
+	}
+
+	var product *models.Product
+	db.Joins("Company").Preload("StockEntries.StockUsages").First(&product, productID)
+
+	for _, shortage := range shortages {
+		usages, cost := product.Consume(shortage.Qty)
+
+		if len(usages) == 0 {
+			break
+		}
+
+		for _, usage := range usages {
+			usage.SourceID = shortage.SourceID
+			usage.SourceType = shortage.SourceType
+			shortage.Qty = models.RoundQty(shortage.Qty - usage.Qty)
+		}
+
+		db.Create(usages)
+
+		db.Create(&models.Entry{
+			Description: "Cost of stock shortage",
+			SourceID:    shortage.SourceID,
+			SourceType:  shortage.SourceType,
+			CompanyID:   shortage.CompanyID,
+			Transactions: []*models.Transaction{
+				{AccountID: product.InventoryAccountID, Value: -cost},
+				{AccountID: shortage.CostAccountID, Value: cost},
+			},
+		})
+
+		if shortage.Qty <= 0 {
+			db.Unscoped().Delete(shortage)
+		} else {
+			db.Save(shortage)
+		}
+	}
+}
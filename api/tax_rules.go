@@ -0,0 +1,225 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+func RegisterTaxRuleEndpoints(router *gin.Engine) {
+	group := router.Group("/tax-rules")
+
+	group.POST("", createTaxRule)
+	group.GET("", listTaxRules)
+	group.GET("/:id", viewTaxRule)
+	group.PUT("/:id", updateTaxRule)
+	group.DELETE("/:id", deleteTaxRule)
+}
+
+// companyTaxRules returns the tax rules of the company for its current
+// regime.
+func companyTaxRules(db *gorm.DB, companyID uint) []*models.TaxRule {
+	var company *models.Company
+	db.First(&company, companyID)
+
+	var rules []*models.TaxRule
+	db.Scopes(models.FromCompany(companyID)).Where("regime = ?", company.Regime).Find(&rules)
+	return rules
+}
+
+// taxTransactions books the taxes as an expense, owed in the payable
+// accounts of the rules that charged them.
+func taxTransactions(rules []*models.TaxRule, taxes []*models.Tax) []*models.Transaction {
+	byID := map[uint]*models.TaxRule{}
+	for _, rule := range rules {
+		byID[rule.ID] = rule
+	}
+
+	transactions := []*models.Transaction{}
+	for _, tax := range taxes {
+		rule, ok := byID[*tax.TaxRuleID]
+		if !ok || tax.Value == 0 {
+			continue
+		}
+
+		transactions = append(transactions,
+			&models.Transaction{
+				Value:     tax.Value,
+				AccountID: rule.ExpenseAccountID,
+			},
+			&models.Transaction{
+				Value:     tax.Value,
+				AccountID: rule.PayableAccountID,
+			},
+		)
+	}
+	return transactions
+}
+
+// chargePurchaseCredits computes the taxes recoverable on each line of the
+// purchase. Only companies under the non-cumulative regime recover them, and
+// only from rules with a recoverable account.
+func chargePurchaseCredits(db *gorm.DB, purchase *models.Purchase) {
+	var company *models.Company
+	db.First(&company, purchase.CompanyID)
+
+	rules := companyTaxRules(db, purchase.CompanyID)
+
+	recoverable := map[uint]bool{}
+	for _, rule := range rules {
+		recoverable[rule.ID] = rule.RecoverableAccountID != nil
+	}
+
+	for _, line := range purchase.Lines {
+		line.Taxes = []*models.Tax{}
+		if company.Regime != models.NonCumulativeRegime {
+			continue
+		}
+
+		var product *models.Product
+		db.First(&product, line.ProductID)
+
+		// A specific rule without credits still overrides a generic one
+		for _, tax := range models.ProductTaxes(rules, product, line.Subtotal()) {
+			if recoverable[*tax.TaxRuleID] && tax.Value != 0 {
+				line.Taxes = append(line.Taxes, tax)
+			}
+		}
+	}
+}
+
+func createTaxRule(context *gin.Context) {
+	var rule *models.TaxRule
+	if err := context.ShouldBindJSON(&rule); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	rule.CompanyID = context.Value("CompanyID").(uint)
+
+	if db.Create(&rule).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("ExpenseAccount").Joins("PayableAccount").Joins("RecoverableAccount").First(&rule)
+	context.JSON(http.StatusOK, rule)
+}
+
+func listTaxRules(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var rules []*models.TaxRule
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Joins("ExpenseAccount").Joins("PayableAccount").Joins("RecoverableAccount")
+	if tx.Find(&rules).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, rules)
+}
+
+func viewTaxRule(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var rule *models.TaxRule
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Joins("ExpenseAccount").Joins("PayableAccount").Joins("RecoverableAccount")
+	if tx.First(&rule, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, rule)
+}
+
+func updateTaxRule(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var rule *models.TaxRule
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&rule, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if err := context.ShouldBindJSON(&rule); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	// Taxes already charged keep the rate they were charged at
+	if db.Save(&rule).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("ExpenseAccount").Joins("PayableAccount").Joins("RecoverableAccount").First(&rule)
+	context.JSON(http.StatusOK, rule)
+}
+
+func deleteTaxRule(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&models.TaxRule{}, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if db.Delete(&models.TaxRule{}, id).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
@@ -0,0 +1,253 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+)
+
+func TestTaxRules(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.Component{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.StockReservation{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+	db.AutoMigrate(&models.WithholdingRule{})
+	db.AutoMigrate(&models.Withholding{})
+	db.AutoMigrate(&models.Customer{})
+	db.AutoMigrate(&models.Sale{})
+	db.AutoMigrate(&models.Item{})
+	db.AutoMigrate(&models.Serial{})
+	db.AutoMigrate(&models.Service{})
+	db.AutoMigrate(&models.Consumption{})
+	db.AutoMigrate(&models.ServicePerformed{})
+
+	t.Cleanup(database.Cleanup)
+
+	db.Create(&models.Company{Name: "Testing Company"})
+
+	cash := &models.Account{Name: "Cash", Type: models.Asset, CompanyID: 1}
+	db.Create(cash)
+
+	inventory := &models.Account{Name: "Inventory", Type: models.Asset, CompanyID: 1}
+	db.Create(inventory)
+
+	revenue := &models.Account{Name: "Revenue", Type: models.Revenue, CompanyID: 1}
+	db.Create(revenue)
+
+	cogs := &models.Account{Name: "Cost of Goods Sold", Type: models.Expense, CompanyID: 1}
+	db.Create(cogs)
+
+	expense := &models.Account{Name: "Taxes on sales", Type: models.Expense, CompanyID: 1}
+	db.Create(expense)
+
+	payable := &models.Account{Name: "Taxes payable", Type: models.Liability, CompanyID: 1}
+	db.Create(payable)
+
+	db.Create(&models.Customer{Name: "Customer", CompanyID: 1})
+
+	db.Create(&models.Product{
+		Name:                "Chair",
+		Price:               100,
+		NCM:                 "94017900",
+		RevenueAccountID:    &revenue.ID,
+		CostOfSaleAccountID: &cogs.ID,
+		InventoryAccountID:  inventory.ID,
+		CompanyID:           1,
+		StockEntries:        []*models.StockEntry{{Qty: 10, Price: 60}},
+	})
+
+	db.Create(&models.Service{
+		Name:                   "Repair",
+		Code:                   "14.01",
+		RevenueAccountID:       revenue.ID,
+		CostOfServiceAccountID: cogs.ID,
+		CompanyID:              1,
+	})
+
+	router := api.GetRouter()
+
+	rule := func(t *testing.T, body map[string]interface{}) *httptest.ResponseRecorder {
+		body["ExpenseAccountID"] = expense.ID
+		body["PayableAccountID"] = payable.ID
+
+		req := Post(t, "/tax-rules", body)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	balance := func(id uint) float64 {
+		var account *models.Account
+		db.Preload("Transactions").First(&account, id)
+		return account.Balance()
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		rules := []map[string]interface{}{
+			{"Type": models.ICMS, "Rate": 18},
+			{"Type": models.PIS, "Rate": 0.65},
+			{"Type": models.PIS, "Rate": 1, "NCM": "94017900"},
+			{"Type": models.ISS, "Rate": 5},
+			{"Type": models.ISS, "Rate": 2, "ServiceCode": "14.01"},
+			{"Type": models.COFINS, "Rate": 7.6, "Regime": models.NonCumulativeRegime},
+		}
+
+		for _, body := range rules {
+			if w := rule(t, body); w.Code != http.StatusOK {
+				t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+			}
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		w := rule(t, map[string]interface{}{"Type": models.ICMS, "Rate": 120})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req := Get(t, "/tax-rules")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var rules []*models.TaxRule
+		if err := json.Unmarshal(w.Body.Bytes(), &rules); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(rules) != 6 {
+			t.Errorf("Expected %v rules, got %v", 6, len(rules))
+		}
+	})
+
+	t.Run("Sale", func(t *testing.T) {
+		sale := &models.Sale{
+			Paid:             true,
+			CustomerID:       1,
+			PaymentAccountID: &cash.ID,
+			CompanyID:        1,
+			Items:            []*models.Item{{Qty: 2, Price: 100, ProductID: 1}},
+		}
+		db.Create(sale)
+
+		api.CreateAccountingEntries(sale)
+
+		var taxes []*models.Tax
+		db.Where("item_id = ?", sale.Items[0].ID).Find(&taxes)
+
+		// ICMS and the PIS rule for the product's NCM, but no ISS nor
+		// COFINS from another regime
+		if len(taxes) != 2 {
+			t.Fatalf("Expected %v taxes, got %v", 2, len(taxes))
+		}
+
+		values := map[models.TaxType]float64{}
+		for _, tax := range taxes {
+			values[tax.Type] = tax.Value
+		}
+
+		if values[models.ICMS] != 36 {
+			t.Errorf("Expected ICMS of %v, got %v", 36, values[models.ICMS])
+		}
+
+		if values[models.PIS] != 2 {
+			t.Errorf("Expected PIS of %v, got %v", 2, values[models.PIS])
+		}
+
+		if balance(expense.ID) != 38 {
+			t.Errorf("Expected expense of %v, got %v", 38, balance(expense.ID))
+		}
+
+		if balance(payable.ID) != 38 {
+			t.Errorf("Expected payable of %v, got %v", 38, balance(payable.ID))
+		}
+
+		var entries []*models.Entry
+		db.Preload("Transactions.Account").Where("source_type = ? AND source_id = ?", "sales", sale.ID).Find(&entries)
+
+		for _, entry := range entries {
+			if !entry.IsBalanced() {
+				t.Errorf("Expected entry %v to be balanced", entry.Description)
+			}
+		}
+	})
+
+	t.Run("Service performed", func(t *testing.T) {
+		req := Post(t, "/services/performed", map[string]interface{}{
+			"Paid":             true,
+			"Value":            200,
+			"ServiceID":        1,
+			"PaymentAccountID": cash.ID,
+			"Consumptions":     []map[string]interface{}{},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var performed *models.ServicePerformed
+		if err := json.Unmarshal(w.Body.Bytes(), &performed); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		// The ISS rule for the service's code and the generic PIS
+		types := map[models.TaxType]float64{}
+		for _, tax := range performed.Taxes {
+			types[tax.Type] = tax.Value
+		}
+
+		if _, ok := types[models.ICMS]; ok || len(types) != 2 {
+			t.Fatalf("Expected ISS and PIS, got %v", types)
+		}
+
+		if types[models.ISS] != 4 {
+			t.Errorf("Expected ISS of %v, got %v", 4, types[models.ISS])
+		}
+
+		if types[models.PIS] != 1.3 {
+			t.Errorf("Expected PIS of %v, got %v", 1.3, types[models.PIS])
+		}
+
+		if balance(payable.ID) != 43.3 {
+			t.Errorf("Expected payable of %v, got %v", 43.3, balance(payable.ID))
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		req := Delete(t, "/tax-rules/1")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNoContent {
+			t.Errorf("Expected status %v, got %v", http.StatusNoContent, w.Code)
+		}
+	})
+}
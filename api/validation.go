@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
@@ -8,6 +9,12 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
+var (
+	ErrInvalidYear  = errors.New("Year must be a number")
+	ErrInvalidMonth = errors.New("Month must be in the YYYY-MM format")
+	ErrInvalidDate  = errors.New("Date must be in the YYYY-MM-DD format")
+)
+
 var databaseUnique validator.Func = func(fl validator.FieldLevel) bool {
 	db, err := database.GetConnection()
 	if err != nil {
@@ -32,6 +39,10 @@ var validCpfCpnj validator.Func = func(fl validator.FieldLevel) bool {
 	return IsCPF(value) || IsCNPJ(value)
 }
 
+var validGtin validator.Func = func(fl validator.FieldLevel) bool {
+	return IsGTIN(fl.Field().String())
+}
+
 type MyError struct {
 	err validator.FieldError
 }
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterWithholdingEndpoints(router *gin.Engine) {
+	group := router.Group("/withholding-rules")
+
+	group.POST("", createWithholdingRule)
+	group.GET("", listWithholdingRules)
+	group.GET("/:id", viewWithholdingRule)
+	group.PUT("/:id", updateWithholdingRule)
+	group.DELETE("/:id", deleteWithholdingRule)
+
+	router.GET("/withholdings", listWithheldByCustomer)
+}
+
+type withheldByCustomer struct {
+	CustomerID uint `json:"-"`
+	Customer   *models.Customer
+	Type       models.WithholdingType
+	Base       float64
+	Value      float64
+}
+
+func createWithholdingRule(context *gin.Context) {
+	var rule *models.WithholdingRule
+	if err := context.ShouldBindJSON(&rule); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	rule.CompanyID = context.Value("CompanyID").(uint)
+
+	if db.Create(&rule).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("Service").Joins("RecoverableAccount").First(&rule)
+	context.JSON(http.StatusOK, rule)
+}
+
+func listWithholdingRules(context *gin.Context) {
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var rules []*models.WithholdingRule
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Joins("Service").Joins("RecoverableAccount")
+	if tx.Find(&rules).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.JSON(http.StatusOK, rules)
+}
+
+func viewWithholdingRule(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var rule *models.WithholdingRule
+	companyID := context.Value("CompanyID").(uint)
+
+	tx := db.Scopes(models.FromCompany(companyID)).Joins("Service").Joins("RecoverableAccount")
+	if tx.First(&rule, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	context.JSON(http.StatusOK, rule)
+}
+
+func updateWithholdingRule(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	var rule *models.WithholdingRule
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&rule, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if err := context.ShouldBindJSON(&rule); err != nil {
+		context.JSON(http.StatusBadRequest, Errors(err))
+		return
+	}
+
+	// Services already withheld keep the rate they were withheld at
+	if db.Save(&rule).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	db.Joins("Service").Joins("RecoverableAccount").First(&rule)
+	context.JSON(http.StatusOK, rule)
+}
+
+func deleteWithholdingRule(context *gin.Context) {
+	id, err := strconv.ParseUint(context.Param("id"), 10, 64)
+	if err != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+
+	if db.Scopes(models.FromCompany(companyID)).First(&models.WithholdingRule{}, id).Error != nil {
+		context.Status(http.StatusNotFound)
+		return
+	}
+
+	if db.Delete(&models.WithholdingRule{}, id).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	context.Status(http.StatusNoContent)
+}
+
+// listWithheldByCustomer totals what each customer withheld in the year,
+// by type, for the annual reconciliation with their statements.
+func listWithheldByCustomer(context *gin.Context) {
+	year, err := strconv.Atoi(context.DefaultQuery("year", strconv.Itoa(time.Now().Year())))
+	if err != nil {
+		context.JSON(http.StatusBadRequest, gin.H{
+			"year": ErrInvalidYear.Error(),
+		})
+		return
+	}
+
+	db, err := database.GetConnection()
+	if err != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	companyID := context.Value("CompanyID").(uint)
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.Local)
+
+	rows := []*withheldByCustomer{}
+
+	tx := db.Model(&models.Withholding{}).
+		Joins("JOIN service_performeds ON service_performeds.id = withholdings.service_performed_id AND service_performeds.deleted_at IS NULL").
+		Where("service_performeds.company_id = ?", companyID).
+		Where("service_performeds.created_at >= ? AND service_performeds.created_at < ?", start, start.AddDate(1, 0, 0)).
+		Select("service_performeds.customer_id, withholdings.type, SUM(withholdings.base) AS base, SUM(withholdings.value) AS value").
+		Group("service_performeds.customer_id, withholdings.type").
+		Order("service_performeds.customer_id, withholdings.type")
+
+	if tx.Scan(&rows).Error != nil {
+		context.Status(http.StatusInternalServerError)
+		return
+	}
+
+	customers := map[uint]*models.Customer{}
+	for _, row := range rows {
+		if _, ok := customers[row.CustomerID]; !ok {
+			var customer *models.Customer
+			db.First(&customer, row.CustomerID)
+			customers[row.CustomerID] = customer
+		}
+		row.Customer = customers[row.CustomerID]
+		row.Base = models.RoundMoney(row.Base)
+		row.Value = models.RoundMoney(row.Value)
+	}
+
+	context.JSON(http.StatusOK, rows)
+}
@@ -0,0 +1,256 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"example.com/accounting/api"
+	"example.com/accounting/database"
+	"example.com/accounting/models"
+)
+
+func TestWithholdingRules(t *testing.T) {
+	t.Setenv("DB_DRIVER", "sqlite")
+	t.Setenv("DB_CONNECTION", "file::memory:?cache=shared")
+
+	db, _ := database.GetConnection()
+
+	db.AutoMigrate(&models.Entry{})
+	db.AutoMigrate(&models.Transaction{})
+	db.AutoMigrate(&models.Product{})
+	db.AutoMigrate(&models.StockEntry{})
+	db.AutoMigrate(&models.StockUsage{})
+	db.AutoMigrate(&models.StockShortage{})
+	db.AutoMigrate(&models.StockReservation{})
+	db.AutoMigrate(&models.TaxRule{})
+	db.AutoMigrate(&models.Tax{})
+	db.AutoMigrate(&models.WithholdingRule{})
+	db.AutoMigrate(&models.Withholding{})
+	db.AutoMigrate(&models.Customer{})
+	db.AutoMigrate(&models.Service{})
+	db.AutoMigrate(&models.Consumption{})
+	db.AutoMigrate(&models.ServicePerformed{})
+
+	t.Cleanup(database.Cleanup)
+
+	db.Create(&models.Company{Name: "Testing Company"})
+
+	receivable := &models.Account{Name: "Receivables", Type: models.Asset, CompanyID: 1}
+	db.Create(receivable)
+
+	revenue := &models.Account{Name: "Revenue", Type: models.Revenue, CompanyID: 1}
+	db.Create(revenue)
+
+	costs := &models.Account{Name: "Cost of Services", Type: models.Expense, CompanyID: 1}
+	db.Create(costs)
+
+	recoverable := &models.Account{Name: "Taxes recoverable", Type: models.Asset, CompanyID: 1}
+	db.Create(recoverable)
+
+	db.Create(&models.Customer{Name: "Company", Cpf: "11.222.333/0001-81", CompanyID: 1})
+	db.Create(&models.Customer{Name: "Person", Cpf: "529.982.247-25", CompanyID: 1})
+
+	service := &models.Service{
+		Name:                   "Consulting",
+		RevenueAccountID:       revenue.ID,
+		CostOfServiceAccountID: costs.ID,
+		CompanyID:              1,
+	}
+	db.Create(service)
+
+	router := api.GetRouter()
+
+	perform := func(t *testing.T, value float64, customerID uint) *models.ServicePerformed {
+		req := Post(t, "/services/performed", map[string]interface{}{
+			"Value":               value,
+			"ServiceID":           service.ID,
+			"CustomerID":          customerID,
+			"ReceivableAccountID": receivable.ID,
+			"Consumptions":        []map[string]interface{}{},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var performed *models.ServicePerformed
+		if err := json.Unmarshal(w.Body.Bytes(), &performed); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+		return performed
+	}
+
+	balance := func(id uint) float64 {
+		var account *models.Account
+		db.Preload("Transactions").First(&account, id)
+		return models.RoundMoney(account.Balance())
+	}
+
+	t.Run("Create", func(t *testing.T) {
+		rules := []map[string]interface{}{
+			{"Type": models.IRRF, "Rate": 1.5, "CustomerType": models.CompanyCustomer},
+			{"Type": models.CSRF, "Rate": 4.65, "Threshold": 215.05, "CustomerType": models.CompanyCustomer},
+			{"Type": models.WithheldISS, "Rate": 2, "CustomerType": models.CompanyCustomer},
+			{"Type": models.WithheldISS, "Rate": 5, "CustomerType": models.CompanyCustomer, "ServiceID": service.ID},
+		}
+
+		for _, body := range rules {
+			body["RecoverableAccountID"] = recoverable.ID
+
+			req := Post(t, "/withholding-rules", body)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status %v, got %v", http.StatusOK, w.Code)
+			}
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		req := Post(t, "/withholding-rules", map[string]interface{}{
+			"Type": models.IRRF,
+			"Rate": 1.5,
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("Perform for a company", func(t *testing.T) {
+		performed := perform(t, 1000, 1)
+
+		// IRRF, CSRF and the ISS rule for the service
+		if len(performed.Withholdings) != 3 {
+			t.Fatalf("Expected %v withholdings, got %v", 3, len(performed.Withholdings))
+		}
+
+		if performed.Withheld() != 111.5 {
+			t.Errorf("Expected %v withheld, got %v", 111.5, performed.Withheld())
+		}
+
+		if balance(receivable.ID) != 888.5 {
+			t.Errorf("Expected receivable of %v, got %v", 888.5, balance(receivable.ID))
+		}
+
+		if balance(recoverable.ID) != 111.5 {
+			t.Errorf("Expected recoverable of %v, got %v", 111.5, balance(recoverable.ID))
+		}
+
+		if balance(revenue.ID) != 1000 {
+			t.Errorf("Expected revenue of %v, got %v", 1000, balance(revenue.ID))
+		}
+	})
+
+	t.Run("Perform below threshold", func(t *testing.T) {
+		performed := perform(t, 200, 1)
+
+		for _, withholding := range performed.Withholdings {
+			if withholding.Type == models.CSRF {
+				t.Error("Expected CSRF not to be withheld")
+			}
+		}
+
+		if performed.Withheld() != 13 {
+			t.Errorf("Expected %v withheld, got %v", 13, performed.Withheld())
+		}
+	})
+
+	t.Run("Perform for an individual", func(t *testing.T) {
+		performed := perform(t, 1000, 2)
+
+		if len(performed.Withholdings) != 0 {
+			t.Errorf("Expected no withholdings, got %v", len(performed.Withholdings))
+		}
+	})
+
+	t.Run("Update performed", func(t *testing.T) {
+		req := Put(t, "/services/performed/1", map[string]interface{}{
+			"Value":               2000,
+			"ServiceID":           service.ID,
+			"CustomerID":          1,
+			"ReceivableAccountID": receivable.ID,
+			"Consumptions":        []map[string]interface{}{},
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var count int64
+		db.Model(&models.Withholding{}).Where("service_performed_id = ?", 1).Count(&count)
+
+		if count != 3 {
+			t.Errorf("Expected %v withholdings, got %v", 3, count)
+		}
+
+		if balance(recoverable.ID) != 236 {
+			t.Errorf("Expected recoverable of %v, got %v", 236, balance(recoverable.ID))
+		}
+	})
+
+	t.Run("Withheld by customer", func(t *testing.T) {
+		req := Get(t, "/withholdings")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %v, got %v", http.StatusOK, w.Code)
+		}
+
+		var rows []struct {
+			Customer *models.Customer
+			Type     models.WithholdingType
+			Base     float64
+			Value    float64
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &rows); err != nil {
+			t.Error("Failed parsing JSON", err)
+		}
+
+		if len(rows) != 3 {
+			t.Fatalf("Expected %v rows, got %v", 3, len(rows))
+		}
+
+		expected := map[models.WithholdingType]float64{
+			models.IRRF:        33,
+			models.WithheldISS: 110,
+			models.CSRF:        93,
+		}
+
+		for _, row := range rows {
+			if row.Customer == nil || row.Customer.ID != 1 {
+				t.Errorf("Expected customer %v, got %v", 1, row.Customer)
+			}
+
+			if row.Value != expected[row.Type] {
+				t.Errorf("Expected %v withheld, got %v", expected[row.Type], row.Value)
+			}
+		}
+	})
+
+	t.Run("Withheld by customer with invalid year", func(t *testing.T) {
+		req := Get(t, "/withholdings?year=last")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %v, got %v", http.StatusBadRequest, w.Code)
+		}
+	})
+}
@@ -0,0 +1,166 @@
+// Package boleto computes the barcode and digitable line of boletos
+// following the FEBRABAN rules, renders them as PDF, and reads the payments
+// in the return files (CNAB 240 and 400) banks send back.
+package boleto
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+const (
+	BancoDoBrasil = "001"
+	Bradesco      = "237"
+	Itau          = "341"
+	// Real is the currency code of boletos in reais
+	Real = "9"
+)
+
+var ErrUnsupportedBank = errors.New("Bank is not supported")
+
+// baseDate is the date due date factors count from. Factors run from 1000
+// to 9999 and then start over from 1000.
+var baseDate = time.Date(1997, time.October, 7, 0, 0, 0, 0, time.UTC)
+
+// Agreement is the data of the agreement with the bank that goes into the
+// boletos it registers.
+type Agreement struct {
+	Bank    string
+	Agency  string
+	Account string
+	Wallet  string
+	Code    string
+}
+
+// OurNumber formats the sequence of a boleto as the nosso número of the
+// bank: the agreement code followed by the sequence for Banco do Brasil, 11
+// digits for Bradesco, and 8 for Itaú.
+func OurNumber(agreement *Agreement, sequence int) (string, error) {
+	switch agreement.Bank {
+	case BancoDoBrasil:
+		return fmt.Sprintf("%s%010d", pad(agreement.Code, 7), sequence), nil
+	case Bradesco:
+		return fmt.Sprintf("%011d", sequence), nil
+	case Itau:
+		return fmt.Sprintf("%08d", sequence), nil
+	default:
+		return "", ErrUnsupportedBank
+	}
+}
+
+// FreeField is the 25 digits of the barcode each bank lays out its own way.
+func FreeField(agreement *Agreement, ourNumber string) (string, error) {
+	switch agreement.Bank {
+	case BancoDoBrasil:
+		// Agreements of 7 digits, the nosso número holding the agreement
+		return "000000" + pad(ourNumber, 17) + pad(agreement.Wallet, 2), nil
+	case Bradesco:
+		return pad(agreement.Agency, 4) + pad(agreement.Wallet, 2) + pad(ourNumber, 11) + pad(agreement.Account, 7) + "0", nil
+	case Itau:
+		agency := pad(agreement.Agency, 4)
+		account := pad(agreement.Account, 5)
+		wallet := pad(agreement.Wallet, 3)
+		number := pad(ourNumber, 8)
+
+		return wallet + number + Mod10(agency+account+wallet+number) +
+			agency + account + Mod10(agency+account) + "000", nil
+	default:
+		return "", ErrUnsupportedBank
+	}
+}
+
+// Factor is the number of days from the base date to the due date.
+func Factor(dueDate time.Time) int {
+	date := time.Date(dueDate.Year(), dueDate.Month(), dueDate.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(date.Sub(baseDate).Hours() / 24)
+
+	if days > 9999 {
+		return (days-1000)%9000 + 1000
+	}
+	return days
+}
+
+// Barcode is the 44 digits of the barcode: the bank, the currency, the
+// check digit, the due date factor, the value in cents, and the free field.
+func Barcode(bank string, dueDate time.Time, value float64, freeField string) string {
+	cents := int64(math.Round(value * 100))
+	digits := fmt.Sprintf("%s%s%04d%010d%s", bank, Real, Factor(dueDate), cents, freeField)
+	return digits[:4] + Mod11(digits) + digits[4:]
+}
+
+// DigitableLine is the barcode as typed by who pays: the free field split
+// into three fields with their own check digits, the barcode's check digit,
+// and the due date factor with the value.
+func DigitableLine(barcode string) string {
+	first := barcode[0:4] + barcode[19:24]
+	second := barcode[24:34]
+	third := barcode[34:44]
+
+	first += Mod10(first)
+	second += Mod10(second)
+	third += Mod10(third)
+
+	return fmt.Sprintf("%s.%s %s.%s %s.%s %s %s",
+		first[:5], first[5:],
+		second[:5], second[5:],
+		third[:5], third[5:],
+		barcode[4:5],
+		barcode[5:19],
+	)
+}
+
+// Mod10 is the check digit of the digits weighted alternately by 2 and 1
+// from the right, adding up the digits of each product.
+func Mod10(digits string) string {
+	sum := 0
+	weight := 2
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		product := int(digits[i]-'0') * weight
+		sum += product/10 + product%10
+
+		weight = 3 - weight
+	}
+
+	return fmt.Sprint((10 - sum%10) % 10)
+}
+
+// Mod11 is the check digit of the barcode, the digits weighted from 2 to 9
+// starting from the right. Digits of 0, 10 and 11 become 1.
+func Mod11(digits string) string {
+	sum := 0
+	weight := 2
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		sum += int(digits[i]-'0') * weight
+
+		weight++
+		if weight > 9 {
+			weight = 2
+		}
+	}
+
+	digit := 11 - sum%11
+	if digit == 0 || digit > 9 {
+		return "1"
+	}
+	return fmt.Sprint(digit)
+}
+
+// pad keeps the digits of the value, zero padded on the left to the size.
+func pad(value string, size int) string {
+	digits := strings.Map(func(char rune) rune {
+		if char >= '0' && char <= '9' {
+			return char
+		}
+		return -1
+	}, value)
+
+	if len(digits) > size {
+		return digits[len(digits)-size:]
+	}
+	return strings.Repeat("0", size-len(digits)) + digits
+}
@@ -0,0 +1,170 @@
+package boleto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidReturnFile = errors.New("File is not a CNAB 240 or 400 return file")
+
+// liquidations are the occurrences of return files telling the boleto was
+// paid, on time or after it was written off.
+var liquidations = map[string]bool{"06": true, "17": true}
+
+// Payment is an occurrence of a boleto in the return file of the bank.
+type Payment struct {
+	Bank       string
+	OurNumber  string
+	Occurrence string
+	Date       time.Time
+	Value      float64
+}
+
+// Paid tells whether the occurrence settles the boleto.
+func (p Payment) Paid() bool {
+	return liquidations[p.Occurrence]
+}
+
+// ReadReturn reads the occurrences of the return file, telling CNAB 240
+// from CNAB 400 by the length of its lines.
+func ReadReturn(file []byte) ([]*Payment, error) {
+	lines := []string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(file))
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, ErrInvalidReturnFile
+	}
+
+	switch len(lines[0]) {
+	case 240:
+		return read240(lines)
+	case 400:
+		return read400(lines)
+	default:
+		return nil, ErrInvalidReturnFile
+	}
+}
+
+// read240 reads the segments T and U of the details, T holding the nosso
+// número and the occurrence, and U following it with the payment.
+func read240(lines []string) ([]*Payment, error) {
+	bank := field(lines[0], 1, 3)
+	payments := []*Payment{}
+
+	var payment *Payment
+	for _, line := range lines {
+		if len(line) != 240 {
+			return nil, ErrInvalidReturnFile
+		}
+
+		if field(line, 8, 8) != "3" {
+			continue
+		}
+
+		switch field(line, 14, 14) {
+		case "T":
+			payment = &Payment{
+				Bank:       bank,
+				OurNumber:  ourNumber240(bank, field(line, 38, 57)),
+				Occurrence: field(line, 16, 17),
+			}
+			payments = append(payments, payment)
+		case "U":
+			if payment == nil {
+				return nil, ErrInvalidReturnFile
+			}
+
+			date, err := time.ParseInLocation("02012006", field(line, 138, 145), time.Local)
+			if err != nil && payment.Paid() {
+				return nil, ErrInvalidReturnFile
+			}
+
+			payment.Date = date
+			payment.Value = cents(field(line, 78, 92))
+			payment = nil
+		}
+	}
+
+	return payments, nil
+}
+
+// read400 reads the details, each holding a whole occurrence.
+func read400(lines []string) ([]*Payment, error) {
+	bank := field(lines[0], 77, 79)
+	payments := []*Payment{}
+
+	for _, line := range lines {
+		if len(line) != 400 {
+			return nil, ErrInvalidReturnFile
+		}
+
+		// Banco do Brasil details of agreements of 7 digits are of type 7
+		if kind := field(line, 1, 1); kind != "1" && kind != "7" {
+			continue
+		}
+
+		payment := &Payment{
+			Bank:       bank,
+			OurNumber:  ourNumber400(bank, line),
+			Occurrence: field(line, 109, 110),
+			Value:      cents(field(line, 254, 266)),
+		}
+
+		date, err := time.ParseInLocation("020106", field(line, 111, 116), time.Local)
+		if err != nil && payment.Paid() {
+			return nil, ErrInvalidReturnFile
+		}
+		payment.Date = date
+
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+// ourNumber240 takes the nosso número from the field of the segment T,
+// which banks lay out with the wallet and check digits around it.
+func ourNumber240(bank string, value string) string {
+	switch bank {
+	case Bradesco:
+		return value[8:19]
+	case Itau:
+		return value[3:11]
+	default:
+		return strings.TrimSpace(value)
+	}
+}
+
+func ourNumber400(bank string, line string) string {
+	switch bank {
+	case BancoDoBrasil:
+		return field(line, 64, 80)
+	case Bradesco:
+		return field(line, 71, 81)
+	case Itau:
+		return field(line, 63, 70)
+	default:
+		return strings.TrimSpace(field(line, 63, 80))
+	}
+}
+
+// field is the value between the positions, counted from 1 as in the
+// layouts of the banks.
+func field(line string, from int, to int) string {
+	return line[from-1 : to]
+}
+
+func cents(value string) float64 {
+	amount, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	return float64(amount) / 100
+}
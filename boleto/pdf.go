@@ -0,0 +1,194 @@
+package boleto
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Document is what is printed on the boleto.
+type Document struct {
+	Bank                string
+	Beneficiary         string
+	BeneficiaryDocument string
+	AgencyCode          string
+	Payer               string
+	PayerDocument       string
+	PayerAddress        string
+	OurNumber           string
+	DocumentNumber      string
+	IssuedAt            time.Time
+	DueDate             time.Time
+	Value               float64
+	Barcode             string
+	DigitableLine       string
+}
+
+// interleaved holds the narrow and wide elements of each digit in the
+// Interleaved 2 of 5 barcode boletos use.
+var interleaved = [10]string{
+	"nnwwn", "wnnnw", "nwnnw", "wwnnn", "nnwnw",
+	"wnwnn", "nwwnn", "nnnww", "wnnwn", "nwnwn",
+}
+
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 40.0
+	narrow     = 0.96
+	wide       = 2.88
+)
+
+// PDF renders the boleto's ficha de compensação on an A4 page.
+func PDF(document *Document) []byte {
+	var content bytes.Buffer
+	width := pageWidth - 2*margin
+
+	text := func(x, y float64, size float64, bold bool, value string) {
+		font := "F1"
+		if bold {
+			font = "F2"
+		}
+		fmt.Fprintf(&content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escape(value))
+	}
+
+	// box draws a field of the form, its label above its value
+	box := func(x, y, w float64, label, value string) {
+		fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re S\n", x, y, w, 24.0)
+		text(x+3, y+16, 6, false, label)
+		text(x+3, y+5, 9, false, value)
+	}
+
+	top := pageHeight - margin - 20
+
+	text(margin, top, 14, true, bankLabel(document.Bank))
+	text(margin+110, top, 12, true, document.DigitableLine)
+	fmt.Fprintf(&content, "%.2f %.2f m %.2f %.2f l S\n", margin, top-6, margin+width, top-6)
+
+	right := 150.0
+	rows := [][2][2]string{
+		{{"Local de pagamento", "Pagável em qualquer banco até o vencimento"}, {"Vencimento", document.DueDate.Format("02/01/2006")}},
+		{{"Beneficiário", document.Beneficiary + " - " + document.BeneficiaryDocument}, {"Agência/Código do beneficiário", document.AgencyCode}},
+		{{"Data do documento", document.IssuedAt.Format("02/01/2006") + "    Nº do documento: " + document.DocumentNumber}, {"Nosso número", document.OurNumber}},
+		{{"Instruções", "Não receber após 30 dias do vencimento"}, {"Valor do documento", money(document.Value)}},
+	}
+
+	y := top - 32
+	for _, row := range rows {
+		box(margin, y, width-right, row[0][0], row[0][1])
+		box(margin+width-right, y, right, row[1][0], row[1][1])
+		y -= 24
+	}
+
+	fmt.Fprintf(&content, "%.2f %.2f %.2f %.2f re S\n", margin, y-12, width, 36.0)
+	text(margin+3, y+16, 6, false, "Pagador")
+	text(margin+3, y+5, 9, false, document.Payer+" - "+document.PayerDocument)
+	text(margin+3, y-7, 9, false, document.PayerAddress)
+
+	barcode(&content, margin, y-70, document.Barcode)
+
+	return render(content.Bytes())
+}
+
+// barcode draws the Interleaved 2 of 5 bars of the digits, pairs of digits
+// interleaving the bars of the first with the spaces of the second.
+func barcode(content *bytes.Buffer, x, y float64, digits string) {
+	const height = 49.0
+
+	elements := "nnnn"
+	for i := 0; i+1 < len(digits); i += 2 {
+		bars := interleaved[digits[i]-'0']
+		spaces := interleaved[digits[i+1]-'0']
+		for j := 0; j < 5; j++ {
+			elements += string(bars[j]) + string(spaces[j])
+		}
+	}
+	elements += "wnn"
+
+	for i, element := range elements {
+		size := narrow
+		if element == 'w' {
+			size = wide
+		}
+
+		// Bars and spaces alternate, starting with a bar
+		if i%2 == 0 {
+			fmt.Fprintf(content, "%.2f %.2f %.2f %.2f re f\n", x, y, size, height)
+		}
+		x += size
+	}
+}
+
+// render writes the PDF with a single page holding the content.
+func render(content []byte) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> /Contents 4 0 R >>", pageWidth, pageHeight),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>",
+	}
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+
+	offsets := []int{}
+	for i, object := range objects {
+		offsets = append(offsets, pdf.Len())
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return pdf.Bytes()
+}
+
+// escape encodes the text in Latin 1, as the fonts use, escaping what PDF
+// strings don't take as is.
+func escape(value string) string {
+	var escaped strings.Builder
+	for _, char := range value {
+		switch {
+		case char == '(' || char == ')' || char == '\\':
+			escaped.WriteByte('\\')
+			escaped.WriteRune(char)
+		case char < 128:
+			escaped.WriteRune(char)
+		case char < 256:
+			fmt.Fprintf(&escaped, "\\%03o", char)
+		default:
+			escaped.WriteByte('?')
+		}
+	}
+	return escaped.String()
+}
+
+// bankLabel is the bank code along its check digit, as printed on the
+// boleto.
+func bankLabel(bank string) string {
+	sum := 0
+	weight := 2
+	for i := len(bank) - 1; i >= 0; i-- {
+		sum += int(bank[i]-'0') * weight
+		weight++
+	}
+
+	digit := 11 - sum%11
+	if digit > 9 {
+		digit = 0
+	}
+	return fmt.Sprintf("%s-%d", bank, digit)
+}
+
+func money(value float64) string {
+	formatted := fmt.Sprintf("%.2f", value)
+	return "R$ " + strings.Replace(formatted, ".", ",", 1)
+}
@@ -7,6 +7,7 @@ const (
 	SaleUpdated
 	PurchaseCreated
 	PurchaseUpdated
+	ProductBelowMinimum
 )
 
 var handlers map[Event][]func(data interface{})
@@ -17,17 +17,44 @@ func main() {
 	db.AutoMigrate(
 		&models.Account{},
 		&models.Customer{},
+		&models.PriceList{},
+		&models.Price{},
 		&models.Company{},
 		&models.Vendor{},
 		&models.Product{},
+		&models.Component{},
+		&models.Attribute{},
+		&models.Option{},
 		&models.Service{},
 		&models.Purchase{},
+		&models.PurchaseLine{},
+		&models.PurchaseOrder{},
+		&models.PurchaseOrderLine{},
+		&models.LandedCost{},
+		&models.LandedCostAllocation{},
 		&models.StockEntry{},
 		&models.Transaction{},
+		&models.TaxRule{},
+		&models.Tax{},
+		&models.DAS{},
+		&models.WithholdingRule{},
+		&models.Withholding{},
+		&models.RPSBatch{},
+		&models.RPS{},
 		&models.Entry{},
 		&models.Sale{},
+		&models.Invoice{},
+		&models.BankAgreement{},
+		&models.Boleto{},
+		&models.PixCharge{},
 		&models.Item{},
+		&models.SalesOrder{},
+		&models.SalesOrderItem{},
 		&models.StockUsage{},
+		&models.StockShortage{},
+		&models.StockReservation{},
+		&models.Serial{},
+		&models.Assembly{},
 	)
 
 	api.RegisterEvents()
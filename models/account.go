@@ -21,6 +21,9 @@ const (
 	Revenue
 )
 
+// Account is an account of the chart of accounts, under its Code. The
+// ReferentialCode is the one it maps to in the referential chart of the
+// Receita Federal.
 type Account struct {
 	gorm.Model
 	Name         string      `binding:"required"`
@@ -31,6 +34,9 @@ type Account struct {
 	Company      *Company       `json:"-"`
 	Children     []*Account     `gorm:"foreignKey:ParentID; constraint:OnDelete:CASCADE;"`
 	Transactions []*Transaction `gorm:"constraint:OnDelete:CASCADE;"`
+
+	Code            string
+	ReferentialCode string
 }
 
 func (a Account) Balance() float64 {
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Assembly puts kits together ahead of their sale, turning the stock of
+// their components into stock of the kit at the components' cost.
+type Assembly struct {
+	gorm.Model
+	Qty          float64 `binding:"required,gt=0"`
+	ProductID    uint    `binding:"required"`
+	Product      *Product
+	StockEntryID *uint
+	StockEntry   *StockEntry   `gorm:"constraint:OnDelete:CASCADE;"`
+	StockUsages  []*StockUsage `json:"-" gorm:"polymorphic:Source"`
+	Entry        *Entry        `gorm:"polymorphic:Source"`
+	CompanyID    uint
+	Company      *Company
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BankAgreement is the agreement with a bank to register the boletos of the
+// company, the payments of which are credited to the bank account. The Code is
+// the convênio the bank knows the company by, and NextNumber the sequence the
+// nosso número of the next boleto is made of. What is paid over the value of
+// boletos, their interest and fines, is credited to the interest account.
+type BankAgreement struct {
+	gorm.Model
+	Bank              string `binding:"required,len=3,numeric"`
+	Agency            string `binding:"required"`
+	Account           string `binding:"required"`
+	Wallet            string
+	Code              string
+	NextNumber        int  `binding:"min=0"`
+	BankAccountID     uint `binding:"required"`
+	BankAccount       *Account
+	InterestAccountID *uint
+	InterestAccount   *Account `gorm:"constraint:OnDelete:SET NULL;"`
+	CompanyID         uint     `json:"-"`
+	Company           *Company `json:"-"`
+}
+
+// Boleto is the payment slip of a sale or service performed the customer
+// pays at any bank, identified in the return files by its nosso número.
+type Boleto struct {
+	gorm.Model
+	OurNumber       string `gorm:"uniqueIndex:idx_boleto_number"`
+	Value           float64
+	DueDate         time.Time
+	Barcode         string
+	DigitableLine   string
+	PaidAt          *time.Time
+	PaidValue       float64
+	BankAgreementID uint `gorm:"uniqueIndex:idx_boleto_number"`
+	BankAgreement   *BankAgreement
+	SourceID        uint
+	SourceType      string
+	Entry           *Entry   `json:",omitempty" gorm:"polymorphic:Source"`
+	CompanyID       uint     `json:"-"`
+	Company         *Company `json:"-"`
+}
@@ -9,12 +9,42 @@ type StockOption int
 const (
 	FIFO StockOption = iota
 	LIFO
+	FEFO
 )
 
+type NegativeStockOption int
+
+const (
+	RejectNegativeStock NegativeStockOption = iota
+	AllowNegativeStock
+	WarnNegativeStock
+)
+
+// Company is the one the books are kept for. Its NF-e are numbered in
+// NFeSeries, and have fiscal value when NFeProduction is set, being issued in
+// the homologation environment otherwise. Its services are numbered in
+// RPSSeries, and NFS-e require the MunicipalRegistration (inscrição municipal).
+// PIX charges are paid to its PixKey, the payments credited to PixAccount.
 type Company struct {
 	gorm.Model
-	Name  string
-	Stock StockOption
+	Name              string
+	Cnpj              string
+	StateRegistration string
+	Address           *Address `gorm:"embedded"`
+	Stock             StockOption
+	NegativeStock     NegativeStockOption
+	Regime            TaxRegime
+	Annex             SimplesAnnex
+	DiscountAccountID *uint
+	DiscountAccount   *Account `gorm:"foreignKey:DiscountAccountID;constraint:OnDelete:SET NULL;"`
+	NFeSeries         int
+	NFeProduction     bool
+	PixKey            string
+	PixAccountID      *uint
+	PixAccount        *Account `gorm:"foreignKey:PixAccountID;constraint:OnDelete:SET NULL;"`
+
+	MunicipalRegistration string
+	RPSSeries             string
 }
 
 type ForCompany struct {
@@ -1,20 +1,52 @@
 package models
 
 import (
+	"unicode"
+
 	"gorm.io/gorm"
 )
 
+type CustomerType int
+
+const (
+	IndividualCustomer CustomerType = iota
+	CompanyCustomer
+)
+
+// Customer is someone the company sells to. Customers contributing ICMS have
+// their state registration (IE).
 type Customer struct {
 	gorm.Model
-	Name      string `binding:"required"`
-	Email     string `binding:"omitempty,email,unique"`
-	Cpf       string `binding:"required,cpf_cnpj,unique"`
-	Phone     string
-	Address   *Address `gorm:"embedded"`
-	CompanyID uint
-	Company   *Company
+	Name        string `binding:"required"`
+	Email       string `binding:"omitempty,email,unique"`
+	Cpf         string `binding:"required,cpf_cnpj,unique"`
+	Phone       string
+	Address     *Address `gorm:"embedded"`
+	PriceListID *uint
+	PriceList   *PriceList `gorm:"constraint:OnDelete:SET NULL;"`
+	CompanyID   uint
+	Company     *Company
+
+	StateRegistration string
+}
+
+// Type tells individuals, identified by a CPF, from companies, identified by
+// a CNPJ.
+func (c Customer) Type() CustomerType {
+	digits := 0
+	for _, char := range c.Cpf {
+		if unicode.IsDigit(char) {
+			digits++
+		}
+	}
+
+	if digits == 14 {
+		return CompanyCustomer
+	}
+	return IndividualCustomer
 }
 
+// Address is a postal address, along with the IBGE code of its city.
 type Address struct {
 	Street       string
 	Number       string
@@ -22,4 +54,5 @@ type Address struct {
 	City         string
 	State        string
 	Postcode     string
+	CityCode     string
 }
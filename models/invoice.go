@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invoice is the NF-e issued for a sale. Numbers run on within each series
+// of the company.
+type Invoice struct {
+	gorm.Model
+	Series     int    `gorm:"uniqueIndex:idx_invoice_number"`
+	Number     int    `gorm:"uniqueIndex:idx_invoice_number"`
+	Code       string `json:"-"`
+	AccessKey  string `gorm:"uniqueIndex"`
+	IssuedAt   time.Time
+	Production bool
+	XML        string   `json:"-"`
+	SaleID     uint     `gorm:"uniqueIndex"`
+	Sale       *Sale    `json:"-" gorm:"constraint:OnDelete:RESTRICT;"`
+	CompanyID  uint     `json:"-" gorm:"uniqueIndex:idx_invoice_number"`
+	Company    *Company `json:"-"`
+}
@@ -0,0 +1,118 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type AllocationMethod int
+
+const (
+	AllocateByQty AllocationMethod = iota
+	AllocateByValue
+)
+
+// LandedCost is a cost of bringing purchased goods in, such as freight,
+// insurance or import duties. Instead of being expensed, it's allocated onto
+// the stock entries of its purchases, raising the cost of the goods.
+type LandedCost struct {
+	gorm.Model
+	Description      string  `binding:"required"`
+	Amount           float64 `binding:"required,gt=0"`
+	Method           AllocationMethod
+	PurchaseIDs      []uint      `gorm:"-" json:",omitempty" binding:"min=1,required"`
+	Purchases        []*Purchase `gorm:"many2many:landed_cost_purchases;constraint:OnDelete:CASCADE;" binding:"-"`
+	PayableAccountID uint        `binding:"required"`
+	PayableAccount   *Account
+	Allocations      []*LandedCostAllocation `gorm:"constraint:OnDelete:CASCADE;" binding:"-"`
+	Entry            *Entry                  `gorm:"polymorphic:Source;constraint:OnDelete:CASCADE;" binding:"-"`
+	CompanyID        uint
+	Company          *Company
+}
+
+// LandedCostAllocation is the share of a landed cost added to a stock entry.
+// The part falling on stock already used is expensed as cost of sale, and
+// the rest raises the price of the stock left by PriceIncrease.
+type LandedCostAllocation struct {
+	gorm.Model
+	Value         float64
+	Expensed      float64
+	PriceIncrease float64
+	LandedCostID  uint
+	LandedCost    *LandedCost `json:"-"`
+	StockEntryID  uint
+	StockEntry    *StockEntry `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Allocate spreads the amount over the stock entries of the purchases in
+// proportion to their quantity or value, and raises the prices of the stock
+// left by the share falling on it. The purchases must be loaded along their
+// lines' stock entries and their usages. Nothing is allocated when the
+// purchases have no stock entries.
+func (c *LandedCost) Allocate() {
+	var entries []*StockEntry
+	var weights []float64
+	total := 0.0
+
+	for _, purchase := range c.Purchases {
+		for _, line := range purchase.Lines {
+			if line.StockEntry == nil {
+				continue
+			}
+
+			weight := line.StockEntry.Qty
+			if c.Method == AllocateByValue {
+				weight = line.Subtotal()
+			}
+
+			entries = append(entries, line.StockEntry)
+			weights = append(weights, weight)
+			total += weight
+		}
+	}
+
+	if total == 0 {
+		return
+	}
+
+	allocated := 0.0
+	for i, entry := range entries {
+		value := c.Amount * weights[i] / total
+
+		// The last entry takes what's left so the shares add up to the amount
+		if i == len(entries)-1 {
+			value = c.Amount - allocated
+		}
+		allocated += value
+
+		// The share of the stock already used was costed without it
+		stock := entry.Stock()
+		expensed := value
+		if entry.Qty > 0 {
+			expensed = RoundMoney(value * (entry.Qty - stock) / entry.Qty)
+		}
+
+		increase := 0.0
+		if stock > 0 {
+			increase = (value - expensed) / stock
+			entry.Price += increase
+		}
+
+		c.Allocations = append(c.Allocations, &LandedCostAllocation{
+			Value:         value,
+			Expensed:      expensed,
+			PriceIncrease: increase,
+			StockEntryID:  entry.ID,
+			StockEntry:    entry,
+		})
+	}
+}
+
+// Deallocate takes the allocated shares back out of the stock entries'
+// prices. The allocations must be loaded along their stock entries.
+func (c *LandedCost) Deallocate() {
+	for _, allocation := range c.Allocations {
+		if entry := allocation.StockEntry; entry != nil {
+			entry.Price -= allocation.PriceIncrease
+		}
+	}
+}
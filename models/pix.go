@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PixCharge is the PIX charge of a sale or service performed, identified by
+// the txid its payment comes back with. Dynamic QR codes have the Location
+// of the charge at the PSP.
+type PixCharge struct {
+	gorm.Model
+	TxID       string `gorm:"uniqueIndex"`
+	Value      float64
+	Payload    string
+	Location   string
+	PaidAt     *time.Time
+	PaidValue  float64
+	EndToEndID string
+	SourceID   uint
+	SourceType string
+	Entry      *Entry   `json:",omitempty" gorm:"polymorphic:Source"`
+	CompanyID  uint     `json:"-"`
+	Company    *Company `json:"-"`
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PriceList holds the prices some customers pay, such as retail, wholesale
+// or a single customer's negotiated prices, while it's valid.
+type PriceList struct {
+	gorm.Model
+	Name       string `binding:"required"`
+	ValidFrom  *time.Time
+	ValidUntil *time.Time
+	Prices     []*Price `gorm:"constraint:OnDelete:CASCADE;" binding:"dive"`
+	CompanyID  uint     `json:"-"`
+	Company    *Company `json:"-"`
+}
+
+// Price is what a product costs in a price list when buying at least MinQty
+// of it, in the product's sale unit.
+type Price struct {
+	gorm.Model
+	Value       float64 `binding:"required,gt=0"`
+	MinQty      float64 `binding:"min=0"`
+	ProductID   uint    `binding:"required"`
+	Product     *Product
+	PriceListID uint       `json:"-"`
+	PriceList   *PriceList `json:"-"`
+}
+
+// Valid tells whether the list applies on the given date.
+func (l *PriceList) Valid(date time.Time) bool {
+	if l.ValidFrom != nil && date.Before(*l.ValidFrom) {
+		return false
+	}
+	return l.ValidUntil == nil || !date.After(*l.ValidUntil)
+}
+
+// PriceFor returns the price of the product when buying qty of it on the
+// given date: the one with the largest quantity break qty reaches. It tells
+// whether the list has such a price.
+func (l *PriceList) PriceFor(productID uint, qty float64, date time.Time) (float64, bool) {
+	if !l.Valid(date) {
+		return 0, false
+	}
+
+	var found *Price
+	for _, price := range l.Prices {
+		if price.ProductID != productID || price.MinQty > qty {
+			continue
+		}
+
+		if found == nil || price.MinQty > found.MinQty {
+			found = price
+		}
+	}
+
+	if found == nil {
+		return 0, false
+	}
+	return found.Value, true
+}
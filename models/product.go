@@ -2,15 +2,29 @@ package models
 
 import (
 	"math"
+	"sort"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type Product struct {
 	gorm.Model
-	Name                string  `binding:"required"`
+	Name                string `binding:"required"`
+	SKU                 string
+	GTIN                string `binding:"omitempty,gtin"`
+	NCM                 string
+	CFOP                string
 	Price               float64 `binding:"required"`
 	Purchasable         bool
+	Serialized          bool
+	StockUnit           string
+	PurchaseUnit        string
+	PurchaseFactor      float64 `binding:"min=0"`
+	SaleUnit            string
+	SaleFactor          float64 `binding:"min=0"`
+	MinStock            float64
+	ReorderQty          float64
 	RevenueAccountID    *uint    `binding:"required_if=Purchasable true"`
 	RevenueAccount      *Account `gorm:"constraint:OnDelete:SET NULL;"`
 	CostOfSaleAccountID *uint    `binding:"required_if=Purchasable true"`
@@ -19,98 +33,289 @@ type Product struct {
 	InventoryAccount    *Account `gorm:"constraint:OnDelete:SET NULL;"`
 	VendorID            *uint
 	Vendor              *Vendor       `gorm:"constraint:OnDelete:SET NULL;"`
+	Components          []*Component  `gorm:"foreignKey:ProductID;constraint:OnDelete:CASCADE;" binding:"dive"`
+	Attributes          []*Attribute  `gorm:"constraint:OnDelete:CASCADE;" binding:"dive"`
+	ParentID            *uint         `binding:"-"`
+	Parent              *Product      `json:"-" binding:"-"`
+	Options             []*Option     `gorm:"constraint:OnDelete:CASCADE;" binding:"-"`
+	Variants            []*Product    `gorm:"foreignKey:ParentID;constraint:OnDelete:CASCADE;" binding:"-"`
+	Stock               float64       `gorm:"-" binding:"-"`
 	StockEntries        []*StockEntry `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
 	CompanyID           uint          `json:"-"`
 	Company             *Company      `json:"-"`
 }
 
-func (p *Product) Inventory() uint {
-	var inventory uint = 0
+// RoundQty rounds a quantity to the precision quantities are kept in, so
+// fractional quantities adding up don't leave crumbs of stock behind.
+func RoundQty(qty float64) float64 {
+	return math.Round(qty*1e6) / 1e6
+}
+
+func (p *Product) Inventory() float64 {
+	inventory := 0.0
 	for _, entry := range p.StockEntries {
 		inventory += entry.Stock()
 	}
-	return inventory
+	return RoundQty(inventory)
 }
 
-func (p *Product) Consume(qty uint) []*StockUsage {
-	left := qty
-	var usages []*StockUsage
-
-	// Invert entries for LIFO
-	if p.Company.Stock == LIFO {
-		for i, j := 0, len(p.StockEntries)-1; i < j; i, j = i+1, j-1 {
-			p.StockEntries[i], p.StockEntries[j] = p.StockEntries[j], p.StockEntries[i]
-		}
+// TotalInventory returns the inventory of the product along with the
+// inventory of its variants.
+func (p *Product) TotalInventory() float64 {
+	inventory := p.Inventory()
+	for _, variant := range p.Variants {
+		inventory += variant.Inventory()
 	}
+	return RoundQty(inventory)
+}
 
+// InventoryValue is what the remaining stock of the product cost, layer by
+// layer.
+func (p *Product) InventoryValue() float64 {
+	value := 0.0
 	for _, entry := range p.StockEntries {
-		qty := math.Min(float64(left), float64(entry.Qty))
+		value += entry.Stock() * entry.Price
+	}
+	return RoundMoney(value)
+}
 
-		usages = append(usages, &StockUsage{
-			Qty:          uint(qty),
-			StockEntryID: entry.ID,
-		})
+// PurchaseToStock converts a quantity in the purchase unit of the product to
+// its stock unit. Products without a factor are purchased in the stock unit.
+func (p *Product) PurchaseToStock(qty float64) float64 {
+	if p.PurchaseFactor == 0 {
+		return qty
+	}
+	return RoundQty(qty * p.PurchaseFactor)
+}
 
-		left -= uint(qty)
+// SaleToStock converts a quantity in the sale unit of the product to its
+// stock unit. Products without a factor are sold in the stock unit.
+func (p *Product) SaleToStock(qty float64) float64 {
+	if p.SaleFactor == 0 {
+		return qty
+	}
+	return RoundQty(qty * p.SaleFactor)
+}
 
-		if left <= 0 {
-			break
-		}
+// IsKit tells whether the product is made of other products.
+func (p *Product) IsKit() bool {
+	return len(p.Components) > 0
+}
+
+// IsVariant tells whether the product is a variant of a parent product.
+func (p *Product) IsVariant() bool {
+	return p.ParentID != nil
+}
+
+// BelowMinimum tells whether the product's inventory dropped below its
+// minimum stock level.
+func (p *Product) BelowMinimum() bool {
+	return p.Inventory() < p.MinStock
+}
+
+// SuggestedPurchase returns how much of the product should be purchased:
+// the reorder quantity, or whatever is missing to get back to the minimum
+// stock level if that's more.
+func (p *Product) SuggestedPurchase() float64 {
+	inventory := p.Inventory()
+	if inventory >= p.MinStock {
+		return 0
 	}
 
-	return usages
+	if missing := p.MinStock - inventory; missing > p.ReorderQty {
+		return missing
+	}
+	return p.ReorderQty
+}
+
+// Consume draws qty from the remaining stock layers of the product, in the
+// order defined by the company's stock option, and returns the usages
+// alongside their cost. The usages are also appended to the layers, so
+// consecutive calls on the same product keep drawing from what's left.
+func (p *Product) Consume(qty float64) ([]*StockUsage, float64) {
+	return p.draw(qty, true)
 }
 
-func (p *Product) Cost(qty uint) float64 {
+// Cost returns what it would cost to consume qty from the remaining stock
+// layers, without consuming them.
+func (p *Product) Cost(qty float64) float64 {
+	_, cost := p.draw(qty, false)
+	return cost
+}
+
+func (p *Product) draw(qty float64, consume bool) ([]*StockUsage, float64) {
+	left := RoundQty(qty)
 	cost := 0.0
-	left := qty
+	var usages []*StockUsage
 
-	// Invert entries for LIFO
-	if p.Company.Stock == LIFO {
-		for i, j := 0, len(p.StockEntries)-1; i < j; i, j = i+1, j-1 {
-			p.StockEntries[i], p.StockEntries[j] = p.StockEntries[j], p.StockEntries[i]
+	for _, entry := range p.layers() {
+		if left == 0 {
+			break
 		}
+
+		used := entry.Stock()
+		if used > left {
+			used = left
+		}
+
+		usage := &StockUsage{
+			Qty:          used,
+			Lot:          entry.Lot,
+			StockEntryID: entry.ID,
+		}
+
+		if consume {
+			entry.StockUsages = append(entry.StockUsages, usage)
+		}
+
+		usages = append(usages, usage)
+		cost += entry.Price * used
+		left = RoundQty(left - used)
 	}
 
-	for _, entry := range p.StockEntries {
-		qty := math.Min(float64(left), float64(entry.Qty))
-		cost += entry.Price * qty
-		left -= uint(qty)
+	return usages, cost
+}
 
-		if left <= 0 {
-			break
+// layers returns the stock entries which still have stock, ordered by
+// acquisition date: oldest first for FIFO, newest first for LIFO. FEFO
+// takes the lots expiring first, and those without expiry date last.
+func (p *Product) layers() []*StockEntry {
+	layers := make([]*StockEntry, 0, len(p.StockEntries))
+	for _, entry := range p.StockEntries {
+		if entry.Stock() > 0 {
+			layers = append(layers, entry)
 		}
 	}
 
-	return cost
+	stock := FIFO
+	if p.Company != nil {
+		stock = p.Company.Stock
+	}
+
+	sort.SliceStable(layers, func(i, j int) bool {
+		switch stock {
+		case LIFO:
+			return layers[j].acquiredBefore(layers[i])
+		case FEFO:
+			if !layers[i].expiresWith(layers[j]) {
+				return layers[i].expiresBefore(layers[j])
+			}
+		}
+		return layers[i].acquiredBefore(layers[j])
+	})
+
+	return layers
+}
+
+// Component is a product, and how much of it, that goes into a kit.
+type Component struct {
+	gorm.Model
+	Qty         float64 `binding:"required,gt=0"`
+	ProductID   uint
+	Product     *Product `json:"-"`
+	ComponentID uint     `binding:"required"`
+	Component   *Product `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Attribute is something the variants of a product differ by, such as size
+// or colour.
+type Attribute struct {
+	gorm.Model
+	Name      string `binding:"required"`
+	ProductID uint   `json:"-"`
+}
+
+// Option is the value a variant takes for one of its parent's attributes.
+type Option struct {
+	gorm.Model
+	Name      string `binding:"required"`
+	Value     string `binding:"required"`
+	ProductID uint   `json:"-"`
 }
 
 type StockEntry struct {
 	gorm.Model
-	Qty         uint
+	Qty         float64
 	Price       float64
+	Lot         string
+	ExpiryDate  *time.Time
 	ProductID   uint
 	Product     *Product
 	StockUsages []*StockUsage `gorm:"constraint:OnDelete:CASCADE"`
 }
 
-func (e *StockEntry) Stock() uint {
-	used := uint(0)
+func (e *StockEntry) Stock() float64 {
+	used := 0.0
 	for _, usage := range e.StockUsages {
 		used += usage.Qty
 	}
-	return e.Qty - used
+	return RoundQty(e.Qty - used)
+}
+
+// Expired tells whether the entry's lot expired by the given date.
+func (e *StockEntry) Expired(date time.Time) bool {
+	return e.ExpiryDate != nil && !e.ExpiryDate.After(date)
+}
+
+func (e *StockEntry) expiresWith(other *StockEntry) bool {
+	if e.ExpiryDate == nil || other.ExpiryDate == nil {
+		return e.ExpiryDate == other.ExpiryDate
+	}
+	return e.ExpiryDate.Equal(*other.ExpiryDate)
+}
+
+func (e *StockEntry) expiresBefore(other *StockEntry) bool {
+	if e.ExpiryDate == nil {
+		return false
+	}
+	return other.ExpiryDate == nil || e.ExpiryDate.Before(*other.ExpiryDate)
+}
+
+func (e *StockEntry) acquiredBefore(other *StockEntry) bool {
+	if e.CreatedAt.Equal(other.CreatedAt) {
+		return e.ID < other.ID
+	}
+	return e.CreatedAt.Before(other.CreatedAt)
 }
 
 type StockUsage struct {
 	gorm.Model
-	Qty          uint
+	Qty          float64
+	Lot          string
 	SourceID     uint
 	SourceType   string
 	StockEntryID uint
 	StockEntry   *StockEntry `gorm:"constraint:OnDelete:CASCADE"`
 }
 
+// StockReservation holds stock of a product for a document, such as a
+// confirmed sales order, keeping it from being used by others until the
+// document releases it.
+type StockReservation struct {
+	gorm.Model
+	Qty        float64
+	SourceID   uint
+	SourceType string
+	ProductID  uint
+	Product    *Product `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// StockShortage records the quantity a document consumed beyond the
+// available stock of a product. It is costed against the cost account once
+// the next purchase of the product arrives.
+type StockShortage struct {
+	gorm.Model
+	Qty           float64
+	SourceID      uint
+	SourceType    string
+	ProductID     uint
+	Product       *Product `gorm:"constraint:OnDelete:CASCADE"`
+	CostAccountID uint
+	CostAccount   *Account `gorm:"constraint:OnDelete:CASCADE"`
+	CompanyID     uint
+	Company       *Company
+}
+
 type Vendor struct {
 	gorm.Model
 	Name      string `binding:"required"`
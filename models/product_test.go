@@ -0,0 +1,296 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"example.com/accounting/models"
+	"gorm.io/gorm"
+)
+
+func stockEntry(id uint, daysAgo int, qty float64, price float64) *models.StockEntry {
+	return &models.StockEntry{
+		Model: gorm.Model{
+			ID:        id,
+			CreatedAt: time.Now().AddDate(0, 0, -daysAgo),
+		},
+		Qty:   qty,
+		Price: price,
+	}
+}
+
+func TestProductConsume(t *testing.T) {
+	inAWeek := time.Now().AddDate(0, 0, 7)
+	inAMonth := time.Now().AddDate(0, 1, 0)
+
+	type sale struct {
+		qty   float64
+		cost  float64
+		usage map[uint]float64
+	}
+
+	cases := []struct {
+		name      string
+		stock     models.StockOption
+		entries   []*models.StockEntry
+		sales     []sale
+		inventory float64
+	}{
+		{
+			name:  "FIFO draws from the oldest layer first",
+			stock: models.FIFO,
+			entries: []*models.StockEntry{
+				stockEntry(1, 10, 100, 100),
+				stockEntry(2, 5, 100, 90),
+			},
+			sales: []sale{
+				{qty: 10, cost: 1000, usage: map[uint]float64{1: 10}},
+			},
+			inventory: 190,
+		},
+		{
+			name:  "FIFO does not consume the same layer twice",
+			stock: models.FIFO,
+			entries: []*models.StockEntry{
+				stockEntry(1, 10, 10, 100),
+				stockEntry(2, 5, 10, 90),
+			},
+			sales: []sale{
+				{qty: 8, cost: 800, usage: map[uint]float64{1: 8}},
+				{qty: 8, cost: 200 + 540, usage: map[uint]float64{1: 2, 2: 6}},
+				{qty: 4, cost: 360, usage: map[uint]float64{2: 4}},
+			},
+			inventory: 0,
+		},
+		{
+			name:  "FIFO orders layers by acquisition date",
+			stock: models.FIFO,
+			entries: []*models.StockEntry{
+				stockEntry(1, 1, 10, 50),
+				stockEntry(2, 20, 10, 30),
+				stockEntry(3, 10, 10, 40),
+			},
+			sales: []sale{
+				{qty: 15, cost: 300 + 200, usage: map[uint]float64{2: 10, 3: 5}},
+				{qty: 10, cost: 200 + 250, usage: map[uint]float64{3: 5, 1: 5}},
+			},
+			inventory: 5,
+		},
+		{
+			name:  "LIFO draws from the newest layer first",
+			stock: models.LIFO,
+			entries: []*models.StockEntry{
+				stockEntry(1, 10, 100, 400),
+				stockEntry(2, 5, 100, 450),
+			},
+			sales: []sale{
+				{qty: 10, cost: 4500, usage: map[uint]float64{2: 10}},
+			},
+			inventory: 190,
+		},
+		{
+			name:  "LIFO does not consume the same layer twice",
+			stock: models.LIFO,
+			entries: []*models.StockEntry{
+				stockEntry(1, 10, 10, 100),
+				stockEntry(2, 5, 10, 90),
+			},
+			sales: []sale{
+				{qty: 8, cost: 720, usage: map[uint]float64{2: 8}},
+				{qty: 8, cost: 180 + 600, usage: map[uint]float64{2: 2, 1: 6}},
+				{qty: 4, cost: 400, usage: map[uint]float64{1: 4}},
+			},
+			inventory: 0,
+		},
+		{
+			name:  "FEFO draws from the lot expiring first",
+			stock: models.FEFO,
+			entries: []*models.StockEntry{
+				{Model: gorm.Model{ID: 1, CreatedAt: time.Now().AddDate(0, 0, -10)}, Qty: 10, Price: 100},
+				{Model: gorm.Model{ID: 2, CreatedAt: time.Now().AddDate(0, 0, -5)}, Qty: 10, Price: 90, ExpiryDate: &inAMonth},
+				{Model: gorm.Model{ID: 3, CreatedAt: time.Now().AddDate(0, 0, -1)}, Qty: 10, Price: 80, ExpiryDate: &inAWeek},
+			},
+			sales: []sale{
+				{qty: 15, cost: 800 + 450, usage: map[uint]float64{3: 10, 2: 5}},
+				{qty: 10, cost: 450 + 500, usage: map[uint]float64{2: 5, 1: 5}},
+			},
+			inventory: 5,
+		},
+		{
+			name:  "Considers previous usages",
+			stock: models.FIFO,
+			entries: []*models.StockEntry{
+				{
+					Model:       gorm.Model{ID: 1, CreatedAt: time.Now().AddDate(0, 0, -10)},
+					Qty:         10,
+					Price:       100,
+					StockUsages: []*models.StockUsage{{Qty: 10}},
+				},
+				stockEntry(2, 5, 10, 90),
+			},
+			sales: []sale{
+				{qty: 5, cost: 450, usage: map[uint]float64{2: 5}},
+			},
+			inventory: 5,
+		},
+		{
+			name:  "Fractional quantities",
+			stock: models.FIFO,
+			entries: []*models.StockEntry{
+				stockEntry(1, 10, 0.3, 10),
+				stockEntry(2, 5, 10, 20),
+			},
+			sales: []sale{
+				{qty: 0.1, cost: 1, usage: map[uint]float64{1: 0.1}},
+				{qty: 0.2, cost: 2, usage: map[uint]float64{1: 0.2}},
+				{qty: 2.5, cost: 50, usage: map[uint]float64{2: 2.5}},
+			},
+			inventory: 7.5,
+		},
+		{
+			name:  "Stops when there is no stock left",
+			stock: models.FIFO,
+			entries: []*models.StockEntry{
+				stockEntry(1, 10, 10, 100),
+			},
+			sales: []sale{
+				{qty: 15, cost: 1000, usage: map[uint]float64{1: 10}},
+				{qty: 5, cost: 0, usage: map[uint]float64{}},
+			},
+			inventory: 0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			product := &models.Product{
+				Company:      &models.Company{Stock: tc.stock},
+				StockEntries: tc.entries,
+			}
+
+			order := make([]uint, len(tc.entries))
+			for i, entry := range tc.entries {
+				order[i] = entry.ID
+			}
+
+			for idx, sale := range tc.sales {
+				if cost := product.Cost(sale.qty); cost != sale.cost {
+					t.Errorf("Sale %d: expected preview cost %v, got %v", idx, sale.cost, cost)
+				}
+
+				usages, cost := product.Consume(sale.qty)
+
+				if cost != sale.cost {
+					t.Errorf("Sale %d: expected cost %v, got %v", idx, sale.cost, cost)
+				}
+
+				if len(usages) != len(sale.usage) {
+					t.Errorf("Sale %d: expected %v usages, got %v", idx, len(sale.usage), len(usages))
+				}
+
+				for _, usage := range usages {
+					if sale.usage[usage.StockEntryID] != usage.Qty {
+						t.Errorf(
+							"Sale %d: expected %v from entry %v, got %v",
+							idx, sale.usage[usage.StockEntryID], usage.StockEntryID, usage.Qty,
+						)
+					}
+				}
+			}
+
+			if product.Inventory() != tc.inventory {
+				t.Errorf("Expected inventory %v, got %v", tc.inventory, product.Inventory())
+			}
+
+			for i, entry := range product.StockEntries {
+				if entry.ID != order[i] {
+					t.Errorf("Expected stock entries to keep their order, got %v at %v", entry.ID, i)
+				}
+			}
+		})
+	}
+}
+
+func TestProductConsumeLots(t *testing.T) {
+	product := &models.Product{
+		StockEntries: []*models.StockEntry{
+			{Model: gorm.Model{ID: 1}, Qty: 5, Price: 10, Lot: "A1"},
+			{Model: gorm.Model{ID: 2}, Qty: 5, Price: 10, Lot: "B2"},
+		},
+	}
+
+	usages, _ := product.Consume(7)
+
+	if len(usages) != 2 {
+		t.Fatalf("Expected %v usages, got %v", 2, len(usages))
+	}
+
+	if usages[0].Lot != "A1" || usages[1].Lot != "B2" {
+		t.Errorf("Expected lots A1 and B2, got %v and %v", usages[0].Lot, usages[1].Lot)
+	}
+}
+
+func TestProductSuggestedPurchase(t *testing.T) {
+	cases := []struct {
+		name       string
+		minStock   float64
+		reorderQty float64
+		inventory  float64
+		below      bool
+		suggested  float64
+	}{
+		{"Without minimum", 0, 0, 0, false, 0},
+		{"Above minimum", 10, 50, 20, false, 0},
+		{"At minimum", 10, 50, 10, false, 0},
+		{"Below minimum", 10, 50, 5, true, 50},
+		{"Reorder not enough to reach minimum", 100, 20, 30, true, 70},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			product := &models.Product{
+				MinStock:     tc.minStock,
+				ReorderQty:   tc.reorderQty,
+				StockEntries: []*models.StockEntry{{Qty: tc.inventory}},
+			}
+
+			if product.BelowMinimum() != tc.below {
+				t.Errorf("Expected below minimum %v, got %v", tc.below, product.BelowMinimum())
+			}
+
+			if product.SuggestedPurchase() != tc.suggested {
+				t.Errorf("Expected suggested %v, got %v", tc.suggested, product.SuggestedPurchase())
+			}
+		})
+	}
+}
+
+func TestProductUnits(t *testing.T) {
+	product := &models.Product{
+		StockUnit:      "can",
+		PurchaseUnit:   "box",
+		PurchaseFactor: 12,
+	}
+
+	if qty := product.PurchaseToStock(2.5); qty != 30 {
+		t.Errorf("Expected %v cans, got %v", 30, qty)
+	}
+
+	// Without a factor the product is sold in its stock unit
+	if qty := product.SaleToStock(3); qty != 3 {
+		t.Errorf("Expected %v cans, got %v", 3, qty)
+	}
+}
+
+func TestProductInventoryValue(t *testing.T) {
+	older := stockEntry(1, 10, 10, 5)
+	older.StockUsages = []*models.StockUsage{{Qty: 6}}
+
+	product := &models.Product{
+		StockEntries: []*models.StockEntry{older, stockEntry(2, 5, 3, 7.5)},
+	}
+
+	if value := product.InventoryValue(); value != 42.5 {
+		t.Errorf("Expected value %v, got %v", 42.5, value)
+	}
+}
@@ -8,20 +8,62 @@ import (
 
 type Purchase struct {
 	gorm.Model
-	Qty              uint    `binding:"required"`
-	Price            float64 `binding:"required"`
+	InvoiceNumber    string
 	Paid             bool
 	PaymentDate      time.Time `binding:"required_if=Paid true"`
 	CompanyID        uint
 	Company          *Company
-	PayableAccountID *uint    `binding:"required_if=Paid false"`
-	PayableAccount   *Account `gorm:"foreignKey:PayableAccountID;"`
-	PaymentAccountID *uint    `binding:"required_if=Paid true"`
-	PaymentAccount   *Account `gorm:"foreignKey:PaymentAccountID;"`
-	ProductID        uint     `binding:"required"`
-	Product          *Product
-	StockEntryID     *uint
-	StockEntry       *StockEntry `gorm:"constraint:OnDelete:CASCADE;"`
-	PaymentEntry     *Entry      `gorm:"polymorphic:Source;polymorphicValue:PurchasePayment;constraint:OnDelete:CASCADE;"`
-	PayableEntry     *Entry      `gorm:"polymorphic:Source;polymorphicValue:PurchasePayable;constraint:OnDelete:CASCADE;"`
+	VendorID         uint `binding:"required"`
+	Vendor           *Vendor
+	Lines            []*PurchaseLine `gorm:"constraint:OnDelete:CASCADE;" binding:"min=1,required,dive,required"`
+	PayableAccountID *uint           `binding:"required_if=Paid false"`
+	PayableAccount   *Account        `gorm:"foreignKey:PayableAccountID;"`
+	PaymentAccountID *uint           `binding:"required_if=Paid true"`
+	PaymentAccount   *Account        `gorm:"foreignKey:PaymentAccountID;"`
+	PaymentEntry     *Entry          `gorm:"polymorphic:Source;polymorphicValue:PurchasePayment;constraint:OnDelete:CASCADE;"`
+	PayableEntry     *Entry          `gorm:"polymorphic:Source;polymorphicValue:PurchasePayable;constraint:OnDelete:CASCADE;"`
+}
+
+func (p Purchase) Total() float64 {
+	total := 0.0
+	for _, line := range p.Lines {
+		total += line.Subtotal()
+	}
+	return total
+}
+
+type PurchaseLine struct {
+	gorm.Model
+	Qty                 float64 `binding:"required,gt=0"`
+	Price               float64 `binding:"required"`
+	Lot                 string
+	ExpiryDate          *time.Time
+	ProductID           uint `binding:"required"`
+	Product             *Product
+	PurchaseID          uint
+	Purchase            *Purchase
+	PurchaseOrderLineID *uint
+	PurchaseOrderLine   *PurchaseOrderLine `json:"-" gorm:"constraint:OnDelete:SET NULL;"`
+	StockEntryID        *uint
+	StockEntry          *StockEntry `gorm:"constraint:OnDelete:CASCADE;"`
+	Serials             []*Serial   `gorm:"constraint:OnDelete:CASCADE;" binding:"dive"`
+	Taxes               []*Tax      `gorm:"constraint:OnDelete:CASCADE;" binding:"-"`
+}
+
+func (l PurchaseLine) Subtotal() float64 {
+	return l.Qty * l.Price
+}
+
+// Credits is the value of the taxes recoverable on the line.
+func (l PurchaseLine) Credits() float64 {
+	credits := 0.0
+	for _, tax := range l.Taxes {
+		credits += tax.Value
+	}
+	return credits
+}
+
+// NetSubtotal is what the line costs once its taxes are recovered.
+func (l PurchaseLine) NetSubtotal() float64 {
+	return l.Subtotal() - l.Credits()
 }
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PurchaseOrder is what's ordered from a vendor. It doesn't touch stock nor
+// the ledger, that only happens when its lines are received as purchases.
+type PurchaseOrder struct {
+	gorm.Model
+	ExpectedDate time.Time
+	VendorID     uint `binding:"required"`
+	Vendor       *Vendor
+	Lines        []*PurchaseOrderLine `gorm:"constraint:OnDelete:CASCADE;" binding:"min=1,required,dive,required"`
+	CompanyID    uint
+	Company      *Company
+}
+
+// Received tells whether every line of the order was received.
+func (o PurchaseOrder) Received() bool {
+	for _, line := range o.Lines {
+		if line.OpenQty() > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+type PurchaseOrderLine struct {
+	gorm.Model
+	Qty             float64 `binding:"required,gt=0"`
+	Price           float64 `binding:"required"`
+	ProductID       uint    `binding:"required"`
+	Product         *Product
+	PurchaseOrderID uint
+	PurchaseOrder   *PurchaseOrder
+	Receipts        []*PurchaseLine `json:"-" gorm:"constraint:OnDelete:SET NULL;"`
+}
+
+// ReceivedQty returns the quantity already received through purchases.
+func (l PurchaseOrderLine) ReceivedQty() float64 {
+	received := 0.0
+	for _, receipt := range l.Receipts {
+		received += receipt.Qty
+	}
+	return RoundQty(received)
+}
+
+// OpenQty returns the quantity still waiting to be received.
+func (l PurchaseOrderLine) OpenQty() float64 {
+	if received := l.ReceivedQty(); received < l.Qty {
+		return RoundQty(l.Qty - received)
+	}
+	return 0
+}
+
+// MarshalJSON adds the received and open quantities to the line.
+func (l PurchaseOrderLine) MarshalJSON() ([]byte, error) {
+	type line PurchaseOrderLine
+	return json.Marshal(struct {
+		line
+		ReceivedQty float64
+		OpenQty     float64
+	}{line(l), l.ReceivedQty(), l.OpenQty()})
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RPS is the provisional receipt of a service performed, which the city
+// converts into an NFS-e. Numbers run on within each series of the company.
+type RPS struct {
+	gorm.Model
+	Series             string `gorm:"uniqueIndex:idx_rps_number"`
+	Number             int    `gorm:"uniqueIndex:idx_rps_number"`
+	IssuedAt           time.Time
+	XML                string            `json:"-"`
+	ServicePerformedID uint              `gorm:"uniqueIndex"`
+	ServicePerformed   *ServicePerformed `json:"-" gorm:"constraint:OnDelete:RESTRICT;"`
+	RPSBatchID         *uint
+	RPSBatch           *RPSBatch `json:"-" gorm:"constraint:OnDelete:SET NULL;"`
+	CompanyID          uint      `json:"-" gorm:"uniqueIndex:idx_rps_number"`
+	Company            *Company  `json:"-"`
+}
+
+// RPSBatch groups RPS for their upload to the city.
+type RPSBatch struct {
+	gorm.Model
+	Number    int      `gorm:"uniqueIndex:idx_rps_batch_number"`
+	XML       string   `json:"-"`
+	RPS       []*RPS   `gorm:"foreignKey:RPSBatchID"`
+	CompanyID uint     `json:"-" gorm:"uniqueIndex:idx_rps_batch_number"`
+	Company   *Company `json:"-"`
+}
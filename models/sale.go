@@ -1,19 +1,41 @@
 package models
 
 import (
+	"math"
+
 	"gorm.io/gorm"
 )
 
+type DiscountType int
+
+const (
+	FixedDiscount DiscountType = iota
+	PercentageDiscount
+)
+
+// discount returns how much a discount takes from the given amount, never
+// more than the amount itself.
+func discount(amount float64, value float64, discountType DiscountType) float64 {
+	if discountType == PercentageDiscount {
+		value = amount * value / 100
+	}
+	return math.Min(math.Max(value, 0), amount)
+}
+
 type Sale struct {
 	gorm.Model
 	Paid              bool
+	Discount          float64 `binding:"min=0"`
+	DiscountType      DiscountType
 	Items             []*Item  `gorm:"constraint:OnDelete:CASCADE;" binding:"min=1,required,dive,required"`
 	Entries           []*Entry `gorm:"polymorphic:Source"`
 	Customer          *Customer
 	Company           *Company
-	PaymentAccount    *Account      `gorm:"constraint:OnDelete:SET NULL;"`
-	ReceivableAccount *Account      `gorm:"constraint:OnDelete:SET NULL;"`
-	StockUsages       []*StockUsage `gorm:"polymorphic:Source"`
+	PaymentAccount    *Account          `gorm:"constraint:OnDelete:SET NULL;"`
+	ReceivableAccount *Account          `gorm:"constraint:OnDelete:SET NULL;"`
+	StockUsages       []*StockUsage     `gorm:"polymorphic:Source"`
+	StockShortages    []*StockShortage  `json:"-" gorm:"polymorphic:Source"`
+	Warnings          map[string]string `json:",omitempty" gorm:"-"`
 
 	CustomerID          uint `binding:"required"`
 	CompanyID           uint
@@ -21,24 +43,58 @@ type Sale struct {
 	ReceivableAccountID *uint `binding:"required_if=Paid false"`
 }
 
-func (s Sale) Total() float64 {
-	total := 0.0
+// Subtotal is the sum of the items after their own discounts.
+func (s Sale) Subtotal() float64 {
+	subtotal := 0.0
 	for _, item := range s.Items {
-		total += item.Subtotal()
+		subtotal += item.Subtotal()
+	}
+	return subtotal
+}
+
+// DiscountAmount is what the whole-sale discount takes from the subtotal.
+func (s Sale) DiscountAmount() float64 {
+	return discount(s.Subtotal(), s.Discount, s.DiscountType)
+}
+
+// ItemDiscountAmount is the share of the whole-sale discount falling on the
+// item, in proportion to its subtotal.
+func (s Sale) ItemDiscountAmount(item *Item) float64 {
+	subtotal := s.Subtotal()
+	if subtotal == 0 {
+		return 0
 	}
-	return total
+	return s.DiscountAmount() * item.Subtotal() / subtotal
+}
+
+func (s Sale) Total() float64 {
+	return s.Subtotal() - s.DiscountAmount()
 }
 
 type Item struct {
 	gorm.Model
-	Qty       uint    `binding:"required,min=1"`
-	Price     float64 `binding:"required"`
-	ProductID uint    `binding:"required"`
-	Product   *Product
-	SaleID    uint
-	Sale      *Sale
+	Qty          float64 `binding:"required,gt=0"`
+	Price        float64 `binding:"min=0"`
+	Discount     float64 `binding:"min=0"`
+	DiscountType DiscountType
+	ProductID    uint `binding:"required"`
+	Product      *Product
+	SaleID       uint
+	Sale         *Sale
+	Serials      []*Serial `gorm:"constraint:OnDelete:SET NULL;" binding:"dive"`
+	Taxes        []*Tax    `gorm:"constraint:OnDelete:CASCADE;" binding:"-"`
+}
+
+// Gross is the value of the item before discounts.
+func (i Item) Gross() float64 {
+	return i.Qty * i.Price
+}
+
+// DiscountAmount is what the item's discount takes from its gross value.
+func (i Item) DiscountAmount() float64 {
+	return discount(i.Gross(), i.Discount, i.DiscountType)
 }
 
 func (i Item) Subtotal() float64 {
-	return float64(i.Qty) * i.Price
+	return i.Gross() - i.DiscountAmount()
 }
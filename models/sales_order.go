@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SalesOrderStatus int
+
+const (
+	Quoted SalesOrderStatus = iota
+	Confirmed
+	Invoiced
+	Cancelled
+)
+
+// SalesOrder is a quote sent to a customer which, once confirmed, becomes an
+// order. Neither touches the ledger: confirming the order reserves its stock
+// and invoicing it turns it into a sale.
+type SalesOrder struct {
+	gorm.Model
+	Status       SalesOrderStatus `binding:"-"`
+	ExpiryDate   *time.Time
+	Discount     float64 `binding:"min=0"`
+	DiscountType DiscountType
+	CustomerID   uint `binding:"required"`
+	Customer     *Customer
+	Items        []*SalesOrderItem   `gorm:"constraint:OnDelete:CASCADE;" binding:"min=1,required,dive,required"`
+	Reservations []*StockReservation `json:"-" gorm:"polymorphic:Source"`
+	SaleID       *uint               `binding:"-"`
+	Sale         *Sale               `gorm:"constraint:OnDelete:SET NULL;" binding:"-"`
+	CompanyID    uint
+	Company      *Company
+}
+
+// Expired tells whether the quote expired by the given date.
+func (o SalesOrder) Expired(date time.Time) bool {
+	return o.ExpiryDate != nil && o.ExpiryDate.Before(date)
+}
+
+// CanChangeTo tells whether the order can go from its current status to the
+// given one. Quotes are confirmed into orders, which are then invoiced, and
+// both can be cancelled along the way.
+func (o SalesOrder) CanChangeTo(status SalesOrderStatus) bool {
+	switch status {
+	case Confirmed:
+		return o.Status == Quoted
+	case Invoiced:
+		return o.Status == Confirmed
+	case Cancelled:
+		return o.Status == Quoted || o.Status == Confirmed
+	}
+	return false
+}
+
+// ToSale returns the sale invoicing the order.
+func (o SalesOrder) ToSale() *Sale {
+	sale := &Sale{
+		Discount:     o.Discount,
+		DiscountType: o.DiscountType,
+		CustomerID:   o.CustomerID,
+		CompanyID:    o.CompanyID,
+	}
+
+	for _, item := range o.Items {
+		sale.Items = append(sale.Items, &Item{
+			Qty:          item.Qty,
+			Price:        item.Price,
+			Discount:     item.Discount,
+			DiscountType: item.DiscountType,
+			ProductID:    item.ProductID,
+		})
+	}
+
+	return sale
+}
+
+type SalesOrderItem struct {
+	gorm.Model
+	Qty          float64 `binding:"required,gt=0"`
+	Price        float64 `binding:"min=0"`
+	Discount     float64 `binding:"min=0"`
+	DiscountType DiscountType
+	ProductID    uint `binding:"required"`
+	Product      *Product
+	SalesOrderID uint
+	SalesOrder   *SalesOrder `json:"-"`
+}
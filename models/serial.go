@@ -0,0 +1,24 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Serial identifies a single unit of a serialized product, from the
+// purchase that brought it in to the sale that took it out.
+type Serial struct {
+	gorm.Model
+	Number         string `binding:"required"`
+	ProductID      uint
+	Product        *Product `json:"-"`
+	PurchaseLineID *uint
+	PurchaseLine   *PurchaseLine `json:"-"`
+	ItemID         *uint
+	Item           *Item `json:"-"`
+	CompanyID      uint  `json:"-"`
+}
+
+// Sold tells whether the unit was already sold.
+func (s *Serial) Sold() bool {
+	return s.ItemID != nil
+}
@@ -5,7 +5,8 @@ import "gorm.io/gorm"
 type Service struct {
 	gorm.Model
 	Name                   string `binding:"required"`
-	RevenueAccountID       uint   `binding:"required"`
+	Code                   string
+	RevenueAccountID       uint `binding:"required"`
 	RevenueAccount         *Account
 	CostOfServiceAccountID uint `binding:"required"`
 	CostOfServiceAccount   *Account
@@ -19,6 +20,8 @@ type ServicePerformed struct {
 	Value               float64
 	ServiceID           uint
 	Service             *Service
+	CustomerID          *uint
+	Customer            *Customer
 	Consumptions        []*Consumption `binding:"required,dive,required"`
 	CompanyID           uint           `json:"-"`
 	Company             *Company       `json:"-"`
@@ -26,14 +29,33 @@ type ServicePerformed struct {
 	PaymentAccount      *Account
 	ReceivableAccountID *uint `binding:"required_if=Paid false"`
 	ReceivableAccount   *Account
-	StockUsages         []*StockUsage `json:"-" gorm:"polymorphic:Source"`
-	Entries             []*Entry      `gorm:"polymorphic:Source"`
+	StockUsages         []*StockUsage     `json:"-" gorm:"polymorphic:Source"`
+	StockShortages      []*StockShortage  `json:"-" gorm:"polymorphic:Source"`
+	Entries             []*Entry          `gorm:"polymorphic:Source"`
+	Taxes               []*Tax            `gorm:"constraint:OnDelete:CASCADE;" binding:"-"`
+	Withholdings        []*Withholding    `gorm:"constraint:OnDelete:CASCADE;" binding:"-"`
+	Warnings            map[string]string `json:",omitempty" gorm:"-"`
+}
+
+// Withheld is the value the customer withheld from the service.
+func (s ServicePerformed) Withheld() float64 {
+	withheld := 0.0
+	for _, withholding := range s.Withholdings {
+		withheld += withholding.Value
+	}
+	return withheld
+}
+
+// Receivable is what the customer pays for the service, net of the
+// withholdings.
+func (s ServicePerformed) Receivable() float64 {
+	return s.Value - s.Withheld()
 }
 
 type Consumption struct {
 	gorm.Model
-	Qty                uint `binding:"required"`
-	ProductID          uint `binding:"required"`
+	Qty                float64 `binding:"required,gt=0"`
+	ProductID          uint    `binding:"required"`
 	Product            *Product
 	ServicePerformedID uint
 	ServicePerformed   *ServicePerformed
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SimplesAnnex int
+
+const (
+	NoAnnex SimplesAnnex = iota
+	// AnnexI is for commerce
+	AnnexI
+	// AnnexII is for industry
+	AnnexII
+	// AnnexIII to AnnexV are for services, depending on the activity and
+	// the payroll
+	AnnexIII
+	AnnexIV
+	AnnexV
+)
+
+// SimplesBracket is a range of the progressive table, applying to trailing
+// revenues up to its limit.
+type SimplesBracket struct {
+	Limit     float64
+	Rate      float64
+	Deduction float64
+}
+
+// SimplesTable holds the brackets of each annex, as in Lei Complementar
+// 155/2016.
+var SimplesTable = map[SimplesAnnex][]*SimplesBracket{
+	AnnexI: {
+		{Limit: 180000, Rate: 4, Deduction: 0},
+		{Limit: 360000, Rate: 7.3, Deduction: 5940},
+		{Limit: 720000, Rate: 9.5, Deduction: 13860},
+		{Limit: 1800000, Rate: 10.7, Deduction: 22500},
+		{Limit: 3600000, Rate: 14.3, Deduction: 87300},
+		{Limit: 4800000, Rate: 19, Deduction: 378000},
+	},
+	AnnexII: {
+		{Limit: 180000, Rate: 4.5, Deduction: 0},
+		{Limit: 360000, Rate: 7.8, Deduction: 5940},
+		{Limit: 720000, Rate: 10, Deduction: 13860},
+		{Limit: 1800000, Rate: 11.2, Deduction: 22500},
+		{Limit: 3600000, Rate: 14.7, Deduction: 85500},
+		{Limit: 4800000, Rate: 30, Deduction: 720000},
+	},
+	AnnexIII: {
+		{Limit: 180000, Rate: 6, Deduction: 0},
+		{Limit: 360000, Rate: 11.2, Deduction: 9360},
+		{Limit: 720000, Rate: 13.5, Deduction: 17640},
+		{Limit: 1800000, Rate: 16, Deduction: 35640},
+		{Limit: 3600000, Rate: 21, Deduction: 125640},
+		{Limit: 4800000, Rate: 33, Deduction: 648000},
+	},
+	AnnexIV: {
+		{Limit: 180000, Rate: 4.5, Deduction: 0},
+		{Limit: 360000, Rate: 9, Deduction: 8100},
+		{Limit: 720000, Rate: 10.2, Deduction: 12420},
+		{Limit: 1800000, Rate: 14, Deduction: 39780},
+		{Limit: 3600000, Rate: 22, Deduction: 183780},
+		{Limit: 4800000, Rate: 33, Deduction: 828000},
+	},
+	AnnexV: {
+		{Limit: 180000, Rate: 15.5, Deduction: 0},
+		{Limit: 360000, Rate: 18, Deduction: 4500},
+		{Limit: 720000, Rate: 19.5, Deduction: 9900},
+		{Limit: 1800000, Rate: 20.5, Deduction: 17100},
+		{Limit: 3600000, Rate: 23, Deduction: 62100},
+		{Limit: 4800000, Rate: 30.5, Deduction: 540000},
+	},
+}
+
+// SimplesBracketFor returns the bracket of the annex the trailing revenue
+// falls in, or nil when it's over the limit of Simples Nacional.
+func SimplesBracketFor(annex SimplesAnnex, trailingRevenue float64) *SimplesBracket {
+	for _, bracket := range SimplesTable[annex] {
+		if trailingRevenue <= bracket.Limit {
+			return bracket
+		}
+	}
+	return nil
+}
+
+// DAS is the monthly tax of a company under Simples Nacional. The effective
+// rate comes from the revenue of the 12 months before, and is charged on the
+// revenue of the month.
+type DAS struct {
+	gorm.Model
+	Month            time.Time
+	Annex            SimplesAnnex
+	TrailingRevenue  float64
+	Revenue          float64
+	Rate             float64
+	Deduction        float64
+	EffectiveRate    float64
+	Value            float64
+	ExpenseAccountID *uint
+	ExpenseAccount   *Account `gorm:"foreignKey:ExpenseAccountID;"`
+	PayableAccountID *uint
+	PayableAccount   *Account `gorm:"foreignKey:PayableAccountID;"`
+	Entry            *Entry   `gorm:"polymorphic:Source;constraint:OnDelete:CASCADE;"`
+	CompanyID        uint     `json:"-"`
+	Company          *Company `json:"-"`
+}
+
+// NewDAS computes the DAS of a month from the revenues and the months of
+// activity before it. Companies with less than 12 of them have the trailing
+// revenue annualized from their monthly average, or from the revenue of the
+// month in the first one (LC 123/2006, art. 18, §2). It returns nil when the
+// trailing revenue is over the limit of Simples Nacional.
+func NewDAS(annex SimplesAnnex, month time.Time, trailingRevenue float64, months int, revenue float64) *DAS {
+	if months == 0 {
+		trailingRevenue = revenue * 12
+	} else if months < 12 {
+		trailingRevenue = trailingRevenue / float64(months) * 12
+	}
+
+	bracket := SimplesBracketFor(annex, trailingRevenue)
+	if bracket == nil {
+		return nil
+	}
+
+	das := &DAS{
+		Month:           month,
+		Annex:           annex,
+		TrailingRevenue: RoundMoney(trailingRevenue),
+		Revenue:         RoundMoney(revenue),
+		Rate:            bracket.Rate,
+		Deduction:       bracket.Deduction,
+		EffectiveRate:   bracket.Rate,
+	}
+
+	if trailingRevenue > 0 {
+		das.EffectiveRate = (trailingRevenue*bracket.Rate/100 - bracket.Deduction) / trailingRevenue * 100
+	}
+
+	das.Value = RoundMoney(revenue * das.EffectiveRate / 100)
+	return das
+}
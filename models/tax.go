@@ -0,0 +1,140 @@
+package models
+
+import (
+	"math"
+
+	"gorm.io/gorm"
+)
+
+type TaxRegime int
+
+const (
+	// CumulativeRegime is Lucro Presumido, where PIS and COFINS are
+	// cumulative
+	CumulativeRegime TaxRegime = iota
+	// NonCumulativeRegime is Lucro Real, where PIS and COFINS are
+	// non-cumulative
+	NonCumulativeRegime
+	SimplesNacional
+)
+
+type TaxType int
+
+const (
+	ICMS TaxType = iota
+	PIS
+	COFINS
+	ISS
+)
+
+// TaxRule is the rate of a tax for companies under a regime. Rules with an
+// NCM only apply to the products under it, and rules with a service code to
+// those services, taking precedence over the rules without. ICMS is only
+// charged on products and ISS on services. Under the non-cumulative regime,
+// rules with a recoverable account also give credits on purchases, held in
+// that asset account.
+type TaxRule struct {
+	gorm.Model
+	Type                 TaxType
+	Rate                 float64 `binding:"required,gt=0,max=100"`
+	Regime               TaxRegime
+	NCM                  string
+	ServiceCode          string
+	ExpenseAccountID     uint `binding:"required"`
+	ExpenseAccount       *Account
+	PayableAccountID     uint `binding:"required"`
+	PayableAccount       *Account
+	RecoverableAccountID *uint
+	RecoverableAccount   *Account `gorm:"foreignKey:RecoverableAccountID;"`
+	CompanyID            uint     `json:"-"`
+	Company              *Company `json:"-"`
+}
+
+// Tax is what a tax rule charged on an item or a service performed, or the
+// credit it gave on a purchase line.
+type Tax struct {
+	gorm.Model
+	Type               TaxType
+	Rate               float64
+	Base               float64
+	Value              float64
+	TaxRuleID          *uint
+	TaxRule            *TaxRule          `json:"-" gorm:"constraint:OnDelete:SET NULL;"`
+	ItemID             *uint             `json:"-"`
+	Item               *Item             `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	ServicePerformedID *uint             `json:"-"`
+	ServicePerformed   *ServicePerformed `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+	PurchaseLineID     *uint             `json:"-"`
+	PurchaseLine       *PurchaseLine     `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// RoundMoney rounds a value to cents.
+func RoundMoney(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// ProductTaxes returns the taxes the rules charge on the given value of the
+// product, one for each type of tax.
+func ProductTaxes(rules []*TaxRule, product *Product, base float64) []*Tax {
+	return charge(rules, base, func(rule *TaxRule) (bool, bool) {
+		if rule.Type == ISS || rule.ServiceCode != "" {
+			return false, false
+		}
+		if rule.NCM == "" {
+			return true, false
+		}
+		return rule.NCM == product.NCM, true
+	})
+}
+
+// ServiceTaxes returns the taxes the rules charge on the given value of the
+// service, one for each type of tax.
+func ServiceTaxes(rules []*TaxRule, service *Service, base float64) []*Tax {
+	return charge(rules, base, func(rule *TaxRule) (bool, bool) {
+		if rule.Type == ICMS || rule.NCM != "" {
+			return false, false
+		}
+		if rule.ServiceCode == "" {
+			return true, false
+		}
+		return rule.ServiceCode == service.Code, true
+	})
+}
+
+// charge picks the rule to apply for each type of tax, preferring specific
+// ones, and computes the taxes. The matcher tells whether a rule applies and
+// whether it's specific.
+func charge(rules []*TaxRule, base float64, matches func(*TaxRule) (bool, bool)) []*Tax {
+	picked := map[TaxType]*TaxRule{}
+	specific := map[TaxType]bool{}
+	order := []TaxType{}
+
+	for _, rule := range rules {
+		applies, isSpecific := matches(rule)
+		if !applies || (specific[rule.Type] && !isSpecific) {
+			continue
+		}
+
+		if _, ok := picked[rule.Type]; !ok {
+			order = append(order, rule.Type)
+		}
+
+		if picked[rule.Type] == nil || isSpecific {
+			picked[rule.Type] = rule
+			specific[rule.Type] = isSpecific
+		}
+	}
+
+	taxes := []*Tax{}
+	for _, taxType := range order {
+		rule := picked[taxType]
+		taxes = append(taxes, &Tax{
+			Type:      rule.Type,
+			Rate:      rule.Rate,
+			Base:      RoundMoney(base),
+			Value:     RoundMoney(base * rule.Rate / 100),
+			TaxRuleID: &rule.ID,
+		})
+	}
+	return taxes
+}
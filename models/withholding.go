@@ -0,0 +1,82 @@
+package models
+
+import "gorm.io/gorm"
+
+type WithholdingType int
+
+const (
+	IRRF WithholdingType = iota
+	WithheldISS
+	INSS
+	// CSRF is PIS, COFINS and CSLL withheld together
+	CSRF
+)
+
+// WithholdingRule is the rate a customer of a type withholds from the value
+// of services. Rules for a service take precedence over the ones for all
+// services. Services worth up to the threshold aren't withheld.
+type WithholdingRule struct {
+	gorm.Model
+	Type                 WithholdingType
+	Rate                 float64 `binding:"required,gt=0,max=100"`
+	Threshold            float64 `binding:"min=0"`
+	CustomerType         CustomerType
+	ServiceID            *uint
+	Service              *Service `gorm:"constraint:OnDelete:CASCADE;"`
+	RecoverableAccountID uint     `binding:"required"`
+	RecoverableAccount   *Account
+	CompanyID            uint     `json:"-"`
+	Company              *Company `json:"-"`
+}
+
+// Withholding is what the customer withheld from a service performed.
+type Withholding struct {
+	gorm.Model
+	Type               WithholdingType
+	Rate               float64
+	Base               float64
+	Value              float64
+	WithholdingRuleID  *uint
+	WithholdingRule    *WithholdingRule  `json:"-" gorm:"constraint:OnDelete:SET NULL;"`
+	ServicePerformedID uint              `json:"-"`
+	ServicePerformed   *ServicePerformed `json:"-" gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// Withhold returns what the rules withhold from the given value of the
+// service for the customer, one withholding for each type.
+func Withhold(rules []*WithholdingRule, service *Service, customer *Customer, base float64) []*Withholding {
+	picked := map[WithholdingType]*WithholdingRule{}
+	order := []WithholdingType{}
+
+	for _, rule := range rules {
+		if rule.CustomerType != customer.Type() || base <= rule.Threshold {
+			continue
+		}
+
+		if rule.ServiceID != nil && *rule.ServiceID != service.ID {
+			continue
+		}
+
+		current, ok := picked[rule.Type]
+		if !ok {
+			order = append(order, rule.Type)
+		}
+
+		if current == nil || (current.ServiceID == nil && rule.ServiceID != nil) {
+			picked[rule.Type] = rule
+		}
+	}
+
+	withholdings := []*Withholding{}
+	for _, withholdingType := range order {
+		rule := picked[withholdingType]
+		withholdings = append(withholdings, &Withholding{
+			Type:              rule.Type,
+			Rate:              rule.Rate,
+			Base:              RoundMoney(base),
+			Value:             RoundMoney(base * rule.Rate / 100),
+			WithholdingRuleID: &rule.ID,
+		})
+	}
+	return withholdings
+}
@@ -0,0 +1,255 @@
+package nfe
+
+import (
+	"fmt"
+	"strings"
+
+	"example.com/accounting/models"
+)
+
+// Build assembles the NF-e of the sale under the invoice's numbering and
+// access key. The sale must come with its company, customer, and items
+// along their products and taxes.
+func Build(sale *models.Sale, invoice *models.Invoice) *NFe {
+	company := sale.Company
+	customer := sale.Customer
+	key := invoice.AccessKey
+
+	ide := &Ide{
+		CUF:      StateCodes[company.Address.State],
+		CNF:      invoice.Code,
+		NatOp:    "Venda de mercadoria",
+		Mod:      Model,
+		Serie:    invoice.Series,
+		NNF:      invoice.Number,
+		DhEmi:    invoice.IssuedAt.Format("2006-01-02T15:04:05-07:00"),
+		TpNF:     "1",
+		IdDest:   "1",
+		CMunFG:   company.Address.CityCode,
+		TpImp:    "1",
+		TpEmis:   NormalEmission,
+		CDV:      key[len(key)-1:],
+		TpAmb:    "2",
+		FinNFe:   "1",
+		IndFinal: "0",
+		IndPres:  "1",
+		ProcEmi:  "0",
+		VerProc:  "accounting",
+	}
+
+	if invoice.Production {
+		ide.TpAmb = "1"
+	}
+
+	// Sales to other states are interstate operations
+	if customer.Address.State != company.Address.State {
+		ide.IdDest = "2"
+	}
+
+	// Individuals and companies without state registration are final
+	// consumers, as SEFAZ rejects non-contributors otherwise (696)
+	if customer.Type() == models.IndividualCustomer || customer.StateRegistration == "" {
+		ide.IndFinal = "1"
+	}
+
+	emit := &Emit{
+		CNPJ:      Digits(company.Cnpj),
+		XNome:     text(company.Name),
+		EnderEmit: address(company.Address),
+		IE:        Digits(company.StateRegistration),
+		CRT:       "3",
+	}
+
+	if company.Regime == models.SimplesNacional {
+		emit.CRT = "1"
+	}
+
+	dest := &Dest{
+		XNome:     text(customer.Name),
+		EnderDest: address(customer.Address),
+		IndIEDest: "9",
+		Email:     strings.TrimSpace(customer.Email),
+	}
+
+	if customer.Type() == models.CompanyCustomer {
+		dest.CNPJ = Digits(customer.Cpf)
+	} else {
+		dest.CPF = Digits(customer.Cpf)
+	}
+
+	if customer.StateRegistration != "" {
+		dest.IndIEDest = "1"
+		dest.IE = Digits(customer.StateRegistration)
+	}
+
+	if !invoice.Production {
+		dest.XNome = HomologationName
+	}
+
+	totals := map[string]float64{}
+	dets := []*Det{}
+
+	for idx, item := range sale.Items {
+		product := item.Product
+
+		gross := models.RoundMoney(item.Gross())
+		discount := models.RoundMoney(item.DiscountAmount() + sale.ItemDiscountAmount(item))
+
+		gtin := product.GTIN
+		if gtin == "" {
+			gtin = "SEM GTIN"
+		}
+
+		unit := product.SaleUnit
+		if unit == "" {
+			unit = "UN"
+		}
+
+		code := product.SKU
+		if code == "" {
+			code = fmt.Sprint(product.ID)
+		}
+
+		prod := &Prod{
+			CProd:    text(code),
+			CEAN:     gtin,
+			XProd:    text(product.Name),
+			NCM:      Digits(product.NCM),
+			CFOP:     Digits(product.CFOP),
+			UCom:     text(unit),
+			QCom:     fmt.Sprintf("%.4f", item.Qty),
+			VUnCom:   fmt.Sprintf("%.4f", item.Price),
+			VProd:    money(gross),
+			CEANTrib: gtin,
+			UTrib:    text(unit),
+			QTrib:    fmt.Sprintf("%.4f", item.Qty),
+			VUnTrib:  fmt.Sprintf("%.4f", item.Price),
+			IndTot:   "1",
+		}
+
+		if discount > 0 {
+			prod.VDesc = money(discount)
+		}
+
+		taxes := map[models.TaxType]*models.Tax{}
+		for _, tax := range item.Taxes {
+			taxes[tax.Type] = tax
+		}
+
+		imposto := &Imposto{
+			ICMS:   &ICMS{},
+			PIS:    &PIS{},
+			COFINS: &COFINS{},
+		}
+
+		if company.Regime == models.SimplesNacional {
+			imposto.ICMS.ICMSSN102 = &ICMSSN102{Orig: "0", CSOSN: "102"}
+		} else if tax, ok := taxes[models.ICMS]; ok {
+			imposto.ICMS.ICMS00 = &ICMS00{
+				Orig:  "0",
+				CST:   "00",
+				ModBC: "3",
+				VBC:   money(tax.Base),
+				PICMS: rate(tax.Rate),
+				VICMS: money(tax.Value),
+			}
+			totals["vBC"] += tax.Base
+			totals["vICMS"] += tax.Value
+		} else {
+			imposto.ICMS.ICMS40 = &ICMS40{Orig: "0", CST: "40"}
+		}
+
+		if tax, ok := taxes[models.PIS]; ok {
+			imposto.PIS.PISAliq = &PISAliq{CST: "01", VBC: money(tax.Base), PPIS: rate(tax.Rate), VPIS: money(tax.Value)}
+			totals["vPIS"] += tax.Value
+		} else {
+			imposto.PIS.PISNT = &PISNT{CST: "07"}
+		}
+
+		if tax, ok := taxes[models.COFINS]; ok {
+			imposto.COFINS.COFINSAliq = &COFINSAliq{CST: "01", VBC: money(tax.Base), PCOFINS: rate(tax.Rate), VCOFINS: money(tax.Value)}
+			totals["vCOFINS"] += tax.Value
+		} else {
+			imposto.COFINS.COFINSNT = &COFINSNT{CST: "07"}
+		}
+
+		totals["vProd"] += gross
+		totals["vDesc"] += discount
+
+		dets = append(dets, &Det{NItem: idx + 1, Prod: prod, Imposto: imposto})
+	}
+
+	total := models.RoundMoney(totals["vProd"] - totals["vDesc"])
+
+	detPag := &DetPag{IndPag: "0", TPag: "01", VPag: money(total)}
+
+	// Sales on credit are paid to the store later
+	if !sale.Paid {
+		detPag.IndPag = "1"
+		detPag.TPag = "05"
+	}
+
+	return &NFe{
+		Xmlns: Namespace,
+		InfNFe: &InfNFe{
+			Versao: Version,
+			ID:     "NFe" + key,
+			Ide:    ide,
+			Emit:   emit,
+			Dest:   dest,
+			Det:    dets,
+			Total: &Total{
+				ICMSTot: &ICMSTot{
+					VBC:        money(totals["vBC"]),
+					VICMS:      money(totals["vICMS"]),
+					VICMSDeson: money(0),
+					VFCP:       money(0),
+					VBCST:      money(0),
+					VST:        money(0),
+					VFCPST:     money(0),
+					VFCPSTRet:  money(0),
+					VProd:      money(totals["vProd"]),
+					VFrete:     money(0),
+					VSeg:       money(0),
+					VDesc:      money(totals["vDesc"]),
+					VII:        money(0),
+					VIPI:       money(0),
+					VIPIDevol:  money(0),
+					VPIS:       money(totals["vPIS"]),
+					VCOFINS:    money(totals["vCOFINS"]),
+					VOutro:     money(0),
+					VNF:        money(total),
+				},
+			},
+			Transp: &Transp{ModFrete: "9"},
+			Pag:    &Pag{DetPag: []*DetPag{detPag}},
+		},
+	}
+}
+
+func address(address *models.Address) *Endereco {
+	return &Endereco{
+		XLgr:    text(address.Street),
+		Nro:     text(address.Number),
+		XBairro: text(address.Neighborhood),
+		CMun:    address.CityCode,
+		XMun:    text(address.City),
+		UF:      address.State,
+		CEP:     Digits(address.Postcode),
+		CPais:   Country,
+		XPais:   CountryName,
+	}
+}
+
+// text trims the spaces the layout doesn't allow around values.
+func text(value string) string {
+	return strings.TrimSpace(value)
+}
+
+func money(value float64) string {
+	return fmt.Sprintf("%.2f", models.RoundMoney(value))
+}
+
+func rate(value float64) string {
+	return fmt.Sprintf("%.4f", value)
+}
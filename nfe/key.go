@@ -0,0 +1,66 @@
+package nfe
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// StateCodes maps the states to their IBGE codes, which lead the access key.
+var StateCodes = map[string]string{
+	"RO": "11", "AC": "12", "AM": "13", "RR": "14", "PA": "15", "AP": "16", "TO": "17",
+	"MA": "21", "PI": "22", "CE": "23", "RN": "24", "PB": "25", "PE": "26", "AL": "27", "SE": "28", "BA": "29",
+	"MG": "31", "ES": "32", "RJ": "33", "SP": "35",
+	"PR": "41", "SC": "42", "RS": "43",
+	"MS": "50", "MT": "51", "GO": "52", "DF": "53",
+}
+
+// Digits strips everything but the digits of a document or postcode.
+func Digits(value string) string {
+	var digits strings.Builder
+	for _, char := range value {
+		if unicode.IsDigit(char) {
+			digits.WriteRune(char)
+		}
+	}
+	return digits.String()
+}
+
+// AccessKey builds the 44 digits identifying the NF-e, the last one being
+// its check digit. The code is the 8 random digits the issuer picks.
+func AccessKey(state string, issuedAt time.Time, cnpj string, series int, number int, code string) string {
+	key := fmt.Sprintf("%s%s%s%s%03d%09d%s%s",
+		StateCodes[state],
+		issuedAt.Format("0601"),
+		Digits(cnpj),
+		Model,
+		series,
+		number,
+		NormalEmission,
+		code,
+	)
+	return key + checkDigit(key)
+}
+
+// checkDigit is the modulo 11 of the digits, weighted from 2 to 9 starting
+// from the right.
+func checkDigit(digits string) string {
+	sum := 0
+	weight := 2
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		sum += int(digits[i]-'0') * weight
+
+		weight++
+		if weight > 9 {
+			weight = 2
+		}
+	}
+
+	remainder := sum % 11
+	if remainder < 2 {
+		return "0"
+	}
+	return fmt.Sprint(11 - remainder)
+}
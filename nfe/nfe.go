@@ -0,0 +1,226 @@
+// Package nfe builds the XML of the NF-e, layout 4.00, for the sales of
+// products. Documents are built unsigned, signing and transmitting them to
+// SEFAZ are up to the caller.
+package nfe
+
+import "encoding/xml"
+
+const (
+	Version        = "4.00"
+	Namespace      = "http://www.portalfiscal.inf.br/nfe"
+	Model          = "55"
+	NormalEmission = "1"
+	Country        = "1058"
+	CountryName    = "BRASIL"
+	// HomologationName replaces the name of the recipient outside
+	// production, as SEFAZ requires
+	HomologationName = "NF-E EMITIDA EM AMBIENTE DE HOMOLOGACAO - SEM VALOR FISCAL"
+)
+
+type NFe struct {
+	XMLName xml.Name `xml:"NFe"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	InfNFe  *InfNFe  `xml:"infNFe"`
+}
+
+type InfNFe struct {
+	Versao string  `xml:"versao,attr"`
+	ID     string  `xml:"Id,attr"`
+	Ide    *Ide    `xml:"ide"`
+	Emit   *Emit   `xml:"emit"`
+	Dest   *Dest   `xml:"dest"`
+	Det    []*Det  `xml:"det"`
+	Total  *Total  `xml:"total"`
+	Transp *Transp `xml:"transp"`
+	Pag    *Pag    `xml:"pag"`
+}
+
+type Ide struct {
+	CUF      string `xml:"cUF"`
+	CNF      string `xml:"cNF"`
+	NatOp    string `xml:"natOp"`
+	Mod      string `xml:"mod"`
+	Serie    int    `xml:"serie"`
+	NNF      int    `xml:"nNF"`
+	DhEmi    string `xml:"dhEmi"`
+	TpNF     string `xml:"tpNF"`
+	IdDest   string `xml:"idDest"`
+	CMunFG   string `xml:"cMunFG"`
+	TpImp    string `xml:"tpImp"`
+	TpEmis   string `xml:"tpEmis"`
+	CDV      string `xml:"cDV"`
+	TpAmb    string `xml:"tpAmb"`
+	FinNFe   string `xml:"finNFe"`
+	IndFinal string `xml:"indFinal"`
+	IndPres  string `xml:"indPres"`
+	ProcEmi  string `xml:"procEmi"`
+	VerProc  string `xml:"verProc"`
+}
+
+type Endereco struct {
+	XLgr    string `xml:"xLgr"`
+	Nro     string `xml:"nro"`
+	XBairro string `xml:"xBairro"`
+	CMun    string `xml:"cMun"`
+	XMun    string `xml:"xMun"`
+	UF      string `xml:"UF"`
+	CEP     string `xml:"CEP,omitempty"`
+	CPais   string `xml:"cPais"`
+	XPais   string `xml:"xPais"`
+}
+
+type Emit struct {
+	CNPJ      string    `xml:"CNPJ"`
+	XNome     string    `xml:"xNome"`
+	EnderEmit *Endereco `xml:"enderEmit"`
+	IE        string    `xml:"IE"`
+	CRT       string    `xml:"CRT"`
+}
+
+type Dest struct {
+	CNPJ      string    `xml:"CNPJ,omitempty"`
+	CPF       string    `xml:"CPF,omitempty"`
+	XNome     string    `xml:"xNome"`
+	EnderDest *Endereco `xml:"enderDest"`
+	IndIEDest string    `xml:"indIEDest"`
+	IE        string    `xml:"IE,omitempty"`
+	Email     string    `xml:"email,omitempty"`
+}
+
+type Det struct {
+	NItem   int      `xml:"nItem,attr"`
+	Prod    *Prod    `xml:"prod"`
+	Imposto *Imposto `xml:"imposto"`
+}
+
+type Prod struct {
+	CProd    string `xml:"cProd"`
+	CEAN     string `xml:"cEAN"`
+	XProd    string `xml:"xProd"`
+	NCM      string `xml:"NCM"`
+	CFOP     string `xml:"CFOP"`
+	UCom     string `xml:"uCom"`
+	QCom     string `xml:"qCom"`
+	VUnCom   string `xml:"vUnCom"`
+	VProd    string `xml:"vProd"`
+	CEANTrib string `xml:"cEANTrib"`
+	UTrib    string `xml:"uTrib"`
+	QTrib    string `xml:"qTrib"`
+	VUnTrib  string `xml:"vUnTrib"`
+	VDesc    string `xml:"vDesc,omitempty"`
+	IndTot   string `xml:"indTot"`
+}
+
+type Imposto struct {
+	ICMS   *ICMS   `xml:"ICMS"`
+	PIS    *PIS    `xml:"PIS"`
+	COFINS *COFINS `xml:"COFINS"`
+}
+
+// ICMS holds one of its groups, depending on the regime of the issuer and
+// whether the item is taxed.
+type ICMS struct {
+	ICMS00    *ICMS00    `xml:"ICMS00,omitempty"`
+	ICMS40    *ICMS40    `xml:"ICMS40,omitempty"`
+	ICMSSN102 *ICMSSN102 `xml:"ICMSSN102,omitempty"`
+}
+
+type ICMS00 struct {
+	Orig  string `xml:"orig"`
+	CST   string `xml:"CST"`
+	ModBC string `xml:"modBC"`
+	VBC   string `xml:"vBC"`
+	PICMS string `xml:"pICMS"`
+	VICMS string `xml:"vICMS"`
+}
+
+type ICMS40 struct {
+	Orig string `xml:"orig"`
+	CST  string `xml:"CST"`
+}
+
+type ICMSSN102 struct {
+	Orig  string `xml:"orig"`
+	CSOSN string `xml:"CSOSN"`
+}
+
+type PIS struct {
+	PISAliq *PISAliq `xml:"PISAliq,omitempty"`
+	PISNT   *PISNT   `xml:"PISNT,omitempty"`
+}
+
+type PISAliq struct {
+	CST  string `xml:"CST"`
+	VBC  string `xml:"vBC"`
+	PPIS string `xml:"pPIS"`
+	VPIS string `xml:"vPIS"`
+}
+
+type PISNT struct {
+	CST string `xml:"CST"`
+}
+
+type COFINS struct {
+	COFINSAliq *COFINSAliq `xml:"COFINSAliq,omitempty"`
+	COFINSNT   *COFINSNT   `xml:"COFINSNT,omitempty"`
+}
+
+type COFINSAliq struct {
+	CST     string `xml:"CST"`
+	VBC     string `xml:"vBC"`
+	PCOFINS string `xml:"pCOFINS"`
+	VCOFINS string `xml:"vCOFINS"`
+}
+
+type COFINSNT struct {
+	CST string `xml:"CST"`
+}
+
+type Total struct {
+	ICMSTot *ICMSTot `xml:"ICMSTot"`
+}
+
+type ICMSTot struct {
+	VBC        string `xml:"vBC"`
+	VICMS      string `xml:"vICMS"`
+	VICMSDeson string `xml:"vICMSDeson"`
+	VFCP       string `xml:"vFCP"`
+	VBCST      string `xml:"vBCST"`
+	VST        string `xml:"vST"`
+	VFCPST     string `xml:"vFCPST"`
+	VFCPSTRet  string `xml:"vFCPSTRet"`
+	VProd      string `xml:"vProd"`
+	VFrete     string `xml:"vFrete"`
+	VSeg       string `xml:"vSeg"`
+	VDesc      string `xml:"vDesc"`
+	VII        string `xml:"vII"`
+	VIPI       string `xml:"vIPI"`
+	VIPIDevol  string `xml:"vIPIDevol"`
+	VPIS       string `xml:"vPIS"`
+	VCOFINS    string `xml:"vCOFINS"`
+	VOutro     string `xml:"vOutro"`
+	VNF        string `xml:"vNF"`
+}
+
+type Transp struct {
+	ModFrete string `xml:"modFrete"`
+}
+
+type Pag struct {
+	DetPag []*DetPag `xml:"detPag"`
+}
+
+type DetPag struct {
+	IndPag string `xml:"indPag"`
+	TPag   string `xml:"tPag"`
+	VPag   string `xml:"vPag"`
+}
+
+// Marshal renders the document with the XML declaration.
+func (n *NFe) Marshal() ([]byte, error) {
+	body, err := xml.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
@@ -0,0 +1,8 @@
+package nfe
+
+import _ "embed"
+
+// Schema is the XSD the documents built here are valid against.
+//
+//go:embed schemas/nfe_v4.00.xsd
+var Schema []byte
@@ -0,0 +1,155 @@
+package nfse
+
+import (
+	"fmt"
+	"strings"
+
+	"example.com/accounting/models"
+	"example.com/accounting/nfe"
+)
+
+// csrfShares split the CSRF withheld into PIS, COFINS and CSLL, in
+// proportion to their rates of 0.65%, 3% and 1%.
+var csrfShares = [3]float64{0.65 / 4.65, 3 / 4.65, 1 / 4.65}
+
+// Build assembles the RPS of the service performed under the given
+// numbering. The service performed must come with its company, service,
+// customer, taxes, and withholdings.
+func Build(performed *models.ServicePerformed, rps *models.RPS) *Rps {
+	company := performed.Company
+
+	valores := &Valores{ValorServicos: money(performed.Value)}
+	servico := &Servico{
+		Valores:             valores,
+		IssRetido:           "2",
+		ItemListaServico:    text(performed.Service.Code),
+		Discriminacao:       text(performed.Service.Name),
+		CodigoMunicipio:     company.Address.CityCode,
+		ExigibilidadeISS:    "1",
+		MunicipioIncidencia: company.Address.CityCode,
+	}
+
+	for _, tax := range performed.Taxes {
+		if tax.Type == models.ISS {
+			valores.ValorIss = money(tax.Value)
+			valores.Aliquota = money(tax.Rate)
+		}
+	}
+
+	for _, withholding := range performed.Withholdings {
+		switch withholding.Type {
+		case models.IRRF:
+			valores.ValorIr = money(withholding.Value)
+		case models.INSS:
+			valores.ValorInss = money(withholding.Value)
+		case models.CSRF:
+			pis := models.RoundMoney(withholding.Value * csrfShares[0])
+			cofins := models.RoundMoney(withholding.Value * csrfShares[1])
+
+			valores.ValorPis = money(pis)
+			valores.ValorCofins = money(cofins)
+			valores.ValorCsll = money(withholding.Value - pis - cofins)
+		case models.WithheldISS:
+			// The customer pays the ISS to the city instead
+			servico.IssRetido = "1"
+			servico.ResponsavelRetencao = "1"
+
+			if valores.ValorIss == "" {
+				valores.ValorIss = money(withholding.Value)
+				valores.Aliquota = money(withholding.Rate)
+			}
+		}
+	}
+
+	declaration := &InfDeclaracaoPrestacaoServico{
+		ID: fmt.Sprintf("rps%s%d", strings.ReplaceAll(rps.Series, " ", ""), rps.Number),
+		Rps: &InfRps{
+			IdentificacaoRps: &IdentificacaoRps{
+				Numero: rps.Number,
+				Serie:  rps.Series,
+				Tipo:   RPSType,
+			},
+			DataEmissao: rps.IssuedAt.Format("2006-01-02"),
+			Status:      NormalStatus,
+		},
+		Competencia:            performed.CreatedAt.Format("2006-01-02"),
+		Servico:                servico,
+		Prestador:              prestador(company),
+		OptanteSimplesNacional: "2",
+		IncentivoFiscal:        "2",
+	}
+
+	if company.Regime == models.SimplesNacional {
+		declaration.OptanteSimplesNacional = "1"
+	}
+
+	if customer := performed.Customer; customer != nil {
+		tomador := &TomadorServico{
+			IdentificacaoTomador: &IdentificacaoTomador{CpfCnpj: &CpfCnpj{}},
+			RazaoSocial:          text(customer.Name),
+		}
+
+		if customer.Type() == models.CompanyCustomer {
+			tomador.IdentificacaoTomador.CpfCnpj.Cnpj = nfe.Digits(customer.Cpf)
+		} else {
+			tomador.IdentificacaoTomador.CpfCnpj.Cpf = nfe.Digits(customer.Cpf)
+		}
+
+		if address := customer.Address; address != nil && address.Street != "" {
+			tomador.Endereco = &Endereco{
+				Endereco:        text(address.Street),
+				Numero:          text(address.Number),
+				Bairro:          text(address.Neighborhood),
+				CodigoMunicipio: address.CityCode,
+				Uf:              address.State,
+				Cep:             nfe.Digits(address.Postcode),
+			}
+		}
+
+		if customer.Phone != "" || customer.Email != "" {
+			tomador.Contato = &Contato{
+				Telefone: nfe.Digits(customer.Phone),
+				Email:    text(customer.Email),
+			}
+		}
+
+		declaration.TomadorServico = tomador
+	}
+
+	return &Rps{InfDeclaracaoPrestacaoServico: declaration}
+}
+
+// Batch assembles the batch of the RPS, as rendered when they were issued.
+func Batch(company *models.Company, batch *models.RPSBatch) *EnviarLoteRpsEnvio {
+	var list strings.Builder
+	for _, rps := range batch.RPS {
+		list.WriteString(rps.XML)
+	}
+
+	return &EnviarLoteRpsEnvio{
+		Xmlns: Namespace,
+		LoteRps: &LoteRps{
+			ID:            fmt.Sprintf("lote%d", batch.Number),
+			Versao:        Version,
+			NumeroLote:    batch.Number,
+			Prestador:     prestador(company),
+			QuantidadeRps: len(batch.RPS),
+			ListaRps:      &ListaRps{Rps: list.String()},
+		},
+	}
+}
+
+func prestador(company *models.Company) *Prestador {
+	return &Prestador{
+		CpfCnpj:            &CpfCnpj{Cnpj: nfe.Digits(company.Cnpj)},
+		InscricaoMunicipal: text(company.MunicipalRegistration),
+	}
+}
+
+func text(value string) string {
+	return strings.TrimSpace(value)
+}
+
+func money(value float64) string {
+	return fmt.Sprintf("%.2f", models.RoundMoney(value))
+}
@@ -0,0 +1,138 @@
+// Package nfse builds the RPS of services performed, and their batches for
+// the upload to the city, following the ABRASF layout 2.04. Cities convert
+// each RPS into an NFS-e. Documents are built unsigned.
+package nfse
+
+import "encoding/xml"
+
+const (
+	Version   = "2.04"
+	Namespace = "http://www.abrasf.org.br/nfse.xsd"
+	// RPSType is the RPS proper, as opposed to mixed notes and coupons
+	RPSType = "1"
+	// NormalStatus is an RPS in effect, as opposed to a cancelled one
+	NormalStatus = "1"
+)
+
+// EnviarLoteRpsEnvio is the batch of RPS sent to the city.
+type EnviarLoteRpsEnvio struct {
+	XMLName xml.Name `xml:"EnviarLoteRpsEnvio"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	LoteRps *LoteRps `xml:"LoteRps"`
+}
+
+type LoteRps struct {
+	ID            string     `xml:"Id,attr"`
+	Versao        string     `xml:"versao,attr"`
+	NumeroLote    int        `xml:"NumeroLote"`
+	Prestador     *Prestador `xml:"Prestador"`
+	QuantidadeRps int        `xml:"QuantidadeRps"`
+	ListaRps      *ListaRps  `xml:"ListaRps"`
+}
+
+// ListaRps holds the RPS as they were rendered when issued.
+type ListaRps struct {
+	Rps string `xml:",innerxml"`
+}
+
+type Rps struct {
+	XMLName                       xml.Name                       `xml:"Rps"`
+	InfDeclaracaoPrestacaoServico *InfDeclaracaoPrestacaoServico `xml:"InfDeclaracaoPrestacaoServico"`
+}
+
+type InfDeclaracaoPrestacaoServico struct {
+	ID                     string          `xml:"Id,attr"`
+	Rps                    *InfRps         `xml:"Rps"`
+	Competencia            string          `xml:"Competencia"`
+	Servico                *Servico        `xml:"Servico"`
+	Prestador              *Prestador      `xml:"Prestador"`
+	TomadorServico         *TomadorServico `xml:"TomadorServico,omitempty"`
+	OptanteSimplesNacional string          `xml:"OptanteSimplesNacional"`
+	IncentivoFiscal        string          `xml:"IncentivoFiscal"`
+}
+
+type InfRps struct {
+	IdentificacaoRps *IdentificacaoRps `xml:"IdentificacaoRps"`
+	DataEmissao      string            `xml:"DataEmissao"`
+	Status           string            `xml:"Status"`
+}
+
+type IdentificacaoRps struct {
+	Numero int    `xml:"Numero"`
+	Serie  string `xml:"Serie"`
+	Tipo   string `xml:"Tipo"`
+}
+
+type Servico struct {
+	Valores             *Valores `xml:"Valores"`
+	IssRetido           string   `xml:"IssRetido"`
+	ResponsavelRetencao string   `xml:"ResponsavelRetencao,omitempty"`
+	ItemListaServico    string   `xml:"ItemListaServico"`
+	Discriminacao       string   `xml:"Discriminacao"`
+	CodigoMunicipio     string   `xml:"CodigoMunicipio"`
+	ExigibilidadeISS    string   `xml:"ExigibilidadeISS"`
+	MunicipioIncidencia string   `xml:"MunicipioIncidencia"`
+}
+
+// Valores holds the value of the service, the ISS charged on it, and what
+// the customer withheld.
+type Valores struct {
+	ValorServicos string `xml:"ValorServicos"`
+	ValorPis      string `xml:"ValorPis,omitempty"`
+	ValorCofins   string `xml:"ValorCofins,omitempty"`
+	ValorInss     string `xml:"ValorInss,omitempty"`
+	ValorIr       string `xml:"ValorIr,omitempty"`
+	ValorCsll     string `xml:"ValorCsll,omitempty"`
+	ValorIss      string `xml:"ValorIss,omitempty"`
+	Aliquota      string `xml:"Aliquota,omitempty"`
+}
+
+type Prestador struct {
+	CpfCnpj            *CpfCnpj `xml:"CpfCnpj"`
+	InscricaoMunicipal string   `xml:"InscricaoMunicipal"`
+}
+
+type CpfCnpj struct {
+	Cnpj string `xml:"Cnpj,omitempty"`
+	Cpf  string `xml:"Cpf,omitempty"`
+}
+
+type TomadorServico struct {
+	IdentificacaoTomador *IdentificacaoTomador `xml:"IdentificacaoTomador"`
+	RazaoSocial          string                `xml:"RazaoSocial"`
+	Endereco             *Endereco             `xml:"Endereco,omitempty"`
+	Contato              *Contato              `xml:"Contato,omitempty"`
+}
+
+type IdentificacaoTomador struct {
+	CpfCnpj *CpfCnpj `xml:"CpfCnpj"`
+}
+
+type Endereco struct {
+	Endereco        string `xml:"Endereco"`
+	Numero          string `xml:"Numero"`
+	Bairro          string `xml:"Bairro"`
+	CodigoMunicipio string `xml:"CodigoMunicipio"`
+	Uf              string `xml:"Uf"`
+	Cep             string `xml:"Cep"`
+}
+
+type Contato struct {
+	Telefone string `xml:"Telefone,omitempty"`
+	Email    string `xml:"Email,omitempty"`
+}
+
+// Marshal renders the RPS without the XML declaration, so it can be put
+// into batches as is.
+func (r *Rps) Marshal() ([]byte, error) {
+	return xml.Marshal(r)
+}
+
+// Marshal renders the batch with the XML declaration.
+func (e *EnviarLoteRpsEnvio) Marshal() ([]byte, error) {
+	body, err := xml.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
@@ -0,0 +1,113 @@
+// Package pix builds the BR Code payloads of PIX charges, following the EMV
+// QR Code Merchant Presented Mode the Central Bank adopted, and renders
+// them as QR codes.
+package pix
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// GUI is the identifier of PIX in the merchant account information.
+const GUI = "br.gov.bcb.pix"
+
+// Charge is what the payload of a charge is made of. Static payloads carry
+// the key of the receiver, and dynamic ones the location of the charge the
+// PSP serves, without the scheme.
+type Charge struct {
+	Key      string
+	Location string
+	Name     string
+	City     string
+	Value    float64
+	TxID     string
+}
+
+// Dynamic tells whether the charge is served by the PSP at its location.
+func (c Charge) Dynamic() bool {
+	return c.Location != ""
+}
+
+// Payload is the BR Code of the charge, its fields closed by the CRC16.
+func Payload(charge *Charge) string {
+	account := field("00", GUI)
+	if charge.Dynamic() {
+		account += field("25", strings.TrimPrefix(charge.Location, "https://"))
+	} else {
+		account += field("01", charge.Key)
+	}
+
+	// Dynamic charges are paid once, and carry their txid at the location
+	initiation := "11"
+	txid := charge.TxID
+	if charge.Dynamic() {
+		initiation = "12"
+		txid = "***"
+	}
+
+	payload := field("00", "01") +
+		field("01", initiation) +
+		field("26", account) +
+		field("52", "0000") +
+		field("53", "986")
+
+	if charge.Value > 0 && !charge.Dynamic() {
+		payload += field("54", fmt.Sprintf("%.2f", charge.Value))
+	}
+
+	payload += field("58", "BR") +
+		field("59", text(charge.Name, 25)) +
+		field("60", text(charge.City, 15)) +
+		field("62", field("05", txid)) +
+		"6304"
+
+	return payload + fmt.Sprintf("%04X", CRC16(payload))
+}
+
+// CRC16 is the CRC16-CCITT of the payload, polynomial 0x1021 starting from
+// 0xFFFF.
+func CRC16(payload string) uint16 {
+	crc := uint16(0xFFFF)
+	for i := 0; i < len(payload); i++ {
+		crc ^= uint16(payload[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// accents maps the accented letters of Portuguese to the plain ones.
+var accents = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "é", "e", "ê", "e", "í", "i",
+	"ó", "o", "ô", "o", "õ", "o", "ú", "u", "ü", "u", "ç", "c",
+	"Á", "A", "À", "A", "Â", "A", "Ã", "A", "É", "E", "Ê", "E", "Í", "I",
+	"Ó", "O", "Ô", "O", "Õ", "O", "Ú", "U", "Ü", "U", "Ç", "C",
+)
+
+// field is the value preceded by its ID and length.
+func field(id string, value string) string {
+	return fmt.Sprintf("%s%02d%s", id, len(value), value)
+}
+
+// text keeps the letters, digits and spaces of the value without accents,
+// as payment apps read, up to the size.
+func text(value string, size int) string {
+	var result strings.Builder
+	for _, char := range accents.Replace(value) {
+		if char < 128 && (unicode.IsLetter(char) || unicode.IsDigit(char) || char == ' ') {
+			result.WriteRune(char)
+		}
+	}
+
+	cleaned := strings.TrimSpace(result.String())
+	if len(cleaned) > size {
+		cleaned = strings.TrimSpace(cleaned[:size])
+	}
+	return cleaned
+}
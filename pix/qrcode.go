@@ -0,0 +1,520 @@
+package pix
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+var ErrPayloadTooLong = errors.New("Payload is too long for a QR code")
+
+// qrBlocks is the error correction of each version at level M: the error
+// correction codewords of each block, and the number of blocks and their
+// data codewords in each of the two groups.
+var qrBlocks = [41][5]int{
+	{},
+	{10, 1, 16, 0, 0}, {16, 1, 28, 0, 0}, {26, 1, 44, 0, 0}, {18, 2, 32, 0, 0}, {24, 2, 43, 0, 0},
+	{16, 4, 27, 0, 0}, {18, 4, 31, 0, 0}, {22, 2, 38, 2, 39}, {22, 3, 36, 2, 37}, {26, 4, 43, 1, 44},
+	{30, 1, 50, 4, 51}, {22, 6, 36, 2, 37}, {22, 8, 37, 1, 38}, {24, 4, 40, 5, 41}, {24, 5, 41, 5, 42},
+	{28, 7, 45, 3, 46}, {28, 10, 46, 1, 47}, {26, 9, 43, 4, 44}, {26, 3, 44, 11, 45}, {26, 3, 41, 13, 42},
+	{26, 17, 42, 0, 0}, {28, 17, 46, 0, 0}, {28, 4, 47, 14, 48}, {28, 6, 45, 14, 46}, {28, 8, 47, 13, 48},
+	{28, 19, 46, 4, 47}, {28, 22, 45, 3, 46}, {28, 3, 45, 23, 46}, {28, 21, 45, 7, 46}, {28, 19, 47, 10, 48},
+	{28, 2, 46, 29, 47}, {28, 10, 46, 23, 47}, {28, 14, 46, 21, 47}, {28, 14, 46, 23, 47}, {28, 12, 47, 26, 48},
+	{28, 6, 47, 34, 48}, {28, 29, 46, 14, 47}, {28, 13, 46, 32, 47}, {28, 40, 47, 7, 48}, {28, 18, 47, 31, 48},
+}
+
+// qrCode is the matrix of a QR code, dark modules set, along the modules
+// taken by function patterns, which aren't masked.
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// QRCode renders the payload as a PNG QR code in byte mode at error
+// correction level M, with the given size in pixels of each module.
+func QRCode(payload string, scale int) ([]byte, error) {
+	code, err := encodeQR([]byte(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	// Quiet zone of 4 modules around the code
+	border := 4
+	width := (code.size + 2*border) * scale
+	img := image.NewGray(image.Rect(0, 0, width, width))
+
+	for y := 0; y < width; y++ {
+		for x := 0; x < width; x++ {
+			row := y/scale - border
+			col := x/scale - border
+
+			pixel := color.Gray{Y: 255}
+			if row >= 0 && row < code.size && col >= 0 && col < code.size && code.modules[row][col] {
+				pixel = color.Gray{Y: 0}
+			}
+			img.SetGray(x, y, pixel)
+		}
+	}
+
+	var file bytes.Buffer
+	if err := png.Encode(&file, img); err != nil {
+		return nil, err
+	}
+	return file.Bytes(), nil
+}
+
+// encodeQR lays out the data in the smallest version fitting it, with the
+// mask of lowest penalty.
+func encodeQR(data []byte) (*qrCode, error) {
+	version, codewords, err := qrCodewords(data)
+	if err != nil {
+		return nil, err
+	}
+
+	code := newQRCode(version)
+	code.placeData(interleave(version, codewords))
+
+	best := -1
+	penalty := 0
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormat(mask)
+
+		if score := code.penalty(); best < 0 || score < penalty {
+			best = mask
+			penalty = score
+		}
+
+		// Masking twice undoes it
+		code.applyMask(mask)
+	}
+
+	code.applyMask(best)
+	code.drawFormat(best)
+
+	return code, nil
+}
+
+// qrCodewords returns the smallest version fitting the data, and the data
+// codewords of it in byte mode, padded to the capacity of the version.
+func qrCodewords(data []byte) (int, []byte, error) {
+	version := 1
+	for ; version <= 40; version++ {
+		if 4+countBits(version)+8*len(data) <= 8*dataCodewords(version) {
+			break
+		}
+	}
+
+	if version > 40 {
+		return 0, nil, ErrPayloadTooLong
+	}
+
+	// Byte mode, the length, the data, and the terminator
+	bits := []bool{}
+	appendBits := func(value int, count int) {
+		for i := count - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+
+	appendBits(0x4, 4)
+	appendBits(len(data), countBits(version))
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+
+	capacity := 8 * dataCodewords(version)
+	appendBits(0, min(4, capacity-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+
+	codewords := []byte{}
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << (7 - j)
+			}
+		}
+		codewords = append(codewords, b)
+	}
+
+	for pad := byte(0xEC); len(codewords) < capacity/8; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	return version, codewords, nil
+}
+
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+func dataCodewords(version int) int {
+	blocks := qrBlocks[version]
+	return blocks[1]*blocks[2] + blocks[3]*blocks[4]
+}
+
+// interleave splits the data in the blocks of the version, computes their
+// error correction, and interleaves the codewords of all the blocks.
+func interleave(version int, data []byte) []byte {
+	blocks := qrBlocks[version]
+	divisor := reedSolomonDivisor(blocks[0])
+
+	dataBlocks := [][]byte{}
+	eccBlocks := [][]byte{}
+
+	offset := 0
+	for group := 0; group < 2; group++ {
+		for i := 0; i < blocks[1+2*group]; i++ {
+			block := data[offset : offset+blocks[2+2*group]]
+			offset += len(block)
+
+			dataBlocks = append(dataBlocks, block)
+			eccBlocks = append(eccBlocks, reedSolomonRemainder(block, divisor))
+		}
+	}
+
+	result := []byte{}
+	for i := 0; i < max(blocks[2], blocks[4]); i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+
+	for i := 0; i < blocks[0]; i++ {
+		for _, block := range eccBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x byte, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+func reedSolomonDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func reedSolomonRemainder(data []byte, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+
+		for i := range result {
+			result[i] ^= gfMultiply(divisor[i], factor)
+		}
+	}
+	return result
+}
+
+// newQRCode draws the function patterns of the version: the finders, the
+// timing and alignment patterns, and the version information, reserving
+// the modules of the format information.
+func newQRCode(version int) *qrCode {
+	size := version*4 + 17
+	code := &qrCode{size: size}
+
+	for i := 0; i < size; i++ {
+		code.modules = append(code.modules, make([]bool, size))
+		code.function = append(code.function, make([]bool, size))
+	}
+
+	for i := 0; i < size; i++ {
+		code.set(6, i, i%2 == 0)
+		code.set(i, 6, i%2 == 0)
+	}
+
+	for _, corner := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x < 0 || x >= size || y < 0 || y >= size {
+					continue
+				}
+
+				distance := max(abs(dx), abs(dy))
+				code.set(x, y, distance != 2 && distance != 4)
+			}
+		}
+	}
+
+	positions := alignmentPositions(version)
+	for i, x := range positions {
+		for j, y := range positions {
+			// Not over the finders
+			if (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0) {
+				continue
+			}
+
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					code.set(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	code.drawFormat(0)
+
+	if version >= 7 {
+		remainder := version
+		for i := 0; i < 12; i++ {
+			remainder = (remainder << 1) ^ ((remainder >> 11) * 0x1F25)
+		}
+		bits := version<<12 | remainder
+
+		for i := 0; i < 18; i++ {
+			dark := (bits>>i)&1 == 1
+			a, b := size-11+i%3, i/3
+			code.set(a, b, dark)
+			code.set(b, a, dark)
+		}
+	}
+
+	return code
+}
+
+func alignmentPositions(version int) []int {
+	if version == 1 {
+		return nil
+	}
+
+	count := version/7 + 2
+	step := (version*4 + count*2 + 1) / (count*2 - 2) * 2
+	if version == 32 {
+		step = 26
+	}
+
+	positions := make([]int, count)
+	positions[0] = 6
+	for i, position := count-1, version*4+10; i > 0; i, position = i-1, position-step {
+		positions[i] = position
+	}
+	return positions
+}
+
+// set draws a module of a function pattern.
+func (c *qrCode) set(x int, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+// drawFormat draws both copies of the format information: the error
+// correction level M and the mask.
+func (c *qrCode) drawFormat(mask int) {
+	data := mask
+	remainder := data
+	for i := 0; i < 10; i++ {
+		remainder = (remainder << 1) ^ ((remainder >> 9) * 0x537)
+	}
+	bits := (data<<10 | remainder) ^ 0x5412
+
+	bit := func(i int) bool {
+		return (bits>>i)&1 == 1
+	}
+
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.set(c.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.size-15+i, bit(i))
+	}
+	c.set(8, c.size-8, true)
+}
+
+// placeData fills the modules left by the function patterns with the
+// codewords, in columns of two going up and down from the right.
+func (c *qrCode) placeData(codewords []byte) {
+	i := 0
+	for right := c.size - 1; right >= 1; right -= 2 {
+		// Skipping the vertical timing pattern
+		if right == 6 {
+			right = 5
+		}
+
+		for vertical := 0; vertical < c.size; vertical++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vertical
+				if (right+1)&2 == 0 {
+					y = c.size - 1 - vertical
+				}
+
+				if !c.function[y][x] && i < len(codewords)*8 {
+					c.modules[y][x] = (codewords[i>>3]>>(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (c *qrCode) applyMask(mask int) {
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+
+			if invert && !c.function[y][x] {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+// penalty scores the code by the rules of the standard: runs of modules of
+// the same color, blocks of 2 by 2, patterns looking like finders, and the
+// balance of dark and light modules.
+func (c *qrCode) penalty() int {
+	score := 0
+	finder := []bool{true, false, true, true, true, false, true}
+
+	at := func(x int, y int, vertical bool) bool {
+		if vertical {
+			return c.modules[x][y]
+		}
+		return c.modules[y][x]
+	}
+
+	// light is whether the modules of the line in the range are all light
+	light := func(line int, from int, to int, vertical bool) bool {
+		for i := from; i < to; i++ {
+			if i >= 0 && i < c.size && at(i, line, vertical) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, vertical := range []bool{false, true} {
+		for line := 0; line < c.size; line++ {
+			run := 1
+			for i := 1; i <= c.size; i++ {
+				if i < c.size && at(i, line, vertical) == at(i-1, line, vertical) {
+					run++
+					continue
+				}
+
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for i := 0; i+7 <= c.size; i++ {
+				matches := true
+				for j, dark := range finder {
+					if at(i+j, line, vertical) != dark {
+						matches = false
+						break
+					}
+				}
+
+				if matches && (light(line, i-4, i, vertical) || light(line, i+7, i+11, vertical)) {
+					score += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.size; y++ {
+		for x := 0; x < c.size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+
+			if x+1 < c.size && y+1 < c.size {
+				color := c.modules[y][x]
+				if c.modules[y][x+1] == color && c.modules[y+1][x] == color && c.modules[y+1][x+1] == color {
+					score += 3
+				}
+			}
+		}
+	}
+
+	total := c.size * c.size
+	score += abs(dark*20-total*10) / total * 10
+
+	return score
+}
+
+func min(x int, y int) int {
+	if x < y {
+		return x
+	}
+	return y
+}
+
+func max(x int, y int) int {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,401 @@
+package pix
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The format information of level M for each mask, from the table of the
+// standard.
+var formatBits = [8]int{0x5412, 0x5125, 0x5E7C, 0x5B4B, 0x45F9, 0x40CE, 0x4F97, 0x4AA0}
+
+// The version information of versions 7 to 10, from the table of the
+// standard.
+var versionBits = map[int]int{7: 0x07C94, 8: 0x085BC, 9: 0x09A99, 10: 0x0A4D3}
+
+// The centers of the alignment patterns of versions 1 to 10.
+var alignments = [11][]int{
+	{}, {}, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34}, {6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50},
+}
+
+// The payloads filling the byte capacity of versions 1 to 10 at level M.
+var capacities = [11]int{0, 14, 26, 42, 62, 84, 106, 122, 152, 180, 213}
+
+func payloadOf(size int) []byte {
+	return []byte(strings.Repeat("00020101021226580014br.gov.bcb.pix0136123e4567-e12b-12d1", 4)[:size])
+}
+
+// isFunction tells the modules of the function patterns of the version
+// apart from the ones holding data.
+func isFunction(version int, x int, y int) bool {
+	size := version*4 + 17
+
+	// Finders, their separators and the format information
+	if (x < 9 && y < 9) || (x >= size-8 && y < 9) || (x < 9 && y >= size-8) {
+		return true
+	}
+
+	if x == 6 || y == 6 {
+		return true
+	}
+
+	positions := alignments[version]
+	for i, cx := range positions {
+		for j, cy := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0) {
+				continue
+			}
+
+			if abs(x-cx) <= 2 && abs(y-cy) <= 2 {
+				return true
+			}
+		}
+	}
+
+	return version >= 7 && ((x >= size-11 && x < size-8 && y < 6) || (y >= size-11 && y < size-8 && x < 6))
+}
+
+func masked(mask int, x int, y int) bool {
+	switch mask {
+	case 0:
+		return (y+x)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (y+x)%3 == 0
+	case 4:
+		return (y/2+x/3)%2 == 0
+	case 5:
+		return (y*x)%2+(y*x)%3 == 0
+	case 6:
+		return ((y*x)%2+(y*x)%3)%2 == 0
+	default:
+		return ((y+x)%2+(y*x)%3)%2 == 0
+	}
+}
+
+// readFormat reads both copies of the format information.
+func readFormat(code *qrCode) (int, int) {
+	first, second := 0, 0
+	bit := func(value int, x int, y int) int {
+		if code.modules[y][x] {
+			return value<<1 | 1
+		}
+		return value << 1
+	}
+
+	for _, x := range []int{0, 1, 2, 3, 4, 5, 7, 8} {
+		first = bit(first, x, 8)
+	}
+	for _, y := range []int{7, 5, 4, 3, 2, 1, 0} {
+		first = bit(first, 8, y)
+	}
+
+	for y := code.size - 1; y >= code.size-7; y-- {
+		second = bit(second, 8, y)
+	}
+	for x := code.size - 8; x < code.size; x++ {
+		second = bit(second, x, 8)
+	}
+
+	return first, second
+}
+
+// readVersion reads both copies of the version information.
+func readVersion(code *qrCode) (int, int) {
+	first, second := 0, 0
+	for i := 17; i >= 0; i-- {
+		first <<= 1
+		second <<= 1
+
+		if code.modules[i/3][code.size-11+i%3] {
+			first |= 1
+		}
+		if code.modules[code.size-11+i%3][i/3] {
+			second |= 1
+		}
+	}
+	return first, second
+}
+
+// readCodewords unmasks the data modules, reading them in columns of two
+// from the bottom right, going up and down.
+func readCodewords(code *qrCode, version int, mask int) []byte {
+	bits := []bool{}
+	upward := true
+
+	for right := code.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+
+		for i := 0; i < code.size; i++ {
+			y := i
+			if upward {
+				y = code.size - 1 - i
+			}
+
+			for _, x := range []int{right, right - 1} {
+				if !isFunction(version, x, y) {
+					bits = append(bits, code.modules[y][x] != masked(mask, x, y))
+				}
+			}
+		}
+		upward = !upward
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i := range codewords {
+		for j := 0; j < 8; j++ {
+			if bits[i*8+j] {
+				codewords[i] |= 1 << (7 - j)
+			}
+		}
+	}
+	return codewords
+}
+
+// gfTables returns the powers of α and their logarithms in GF(2^8).
+func gfTables() ([256]byte, [256]int) {
+	var exp [256]byte
+	var log [256]int
+
+	value := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(value)
+		log[value] = i
+
+		if value <<= 1; value >= 256 {
+			value ^= 0x11D
+		}
+	}
+	exp[255] = exp[0]
+	return exp, log
+}
+
+// syndromesZero tells whether the block is a codeword of the Reed-Solomon
+// code with the given number of error correction codewords.
+func syndromesZero(block []byte, ecc int) bool {
+	exp, log := gfTables()
+
+	for i := 0; i < ecc; i++ {
+		syndrome := byte(0)
+		for _, b := range block {
+			if syndrome != 0 {
+				syndrome = exp[(log[syndrome]+i)%255]
+			}
+			syndrome ^= b
+		}
+
+		if syndrome != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// decode reads the byte mode data of the code, checking each block against
+// its error correction.
+func decode(t *testing.T, code *qrCode, version int, mask int) []byte {
+	blocks := qrBlocks[version]
+	codewords := readCodewords(code, version, mask)
+
+	sizes := []int{}
+	for group := 0; group < 2; group++ {
+		for i := 0; i < blocks[1+2*group]; i++ {
+			sizes = append(sizes, blocks[2+2*group])
+		}
+	}
+
+	data := make([][]byte, len(sizes))
+	offset := 0
+	for i := 0; i < sizes[len(sizes)-1]; i++ {
+		for j, size := range sizes {
+			if i < size {
+				data[j] = append(data[j], codewords[offset])
+				offset++
+			}
+		}
+	}
+
+	for i := 0; i < blocks[0]; i++ {
+		for j := range sizes {
+			data[j] = append(data[j], codewords[offset])
+			offset++
+		}
+	}
+
+	stream := []byte{}
+	for j, block := range data {
+		if !syndromesZero(block, blocks[0]) {
+			t.Errorf("Expected block %v to be a Reed-Solomon codeword, got %v", j, block)
+		}
+		stream = append(stream, block[:sizes[j]]...)
+	}
+
+	position := 0
+	read := func(count int) int {
+		value := 0
+		for i := 0; i < count; i++ {
+			value = value<<1 | int(stream[position/8]>>(7-position%8)&1)
+			position++
+		}
+		return value
+	}
+
+	if mode := read(4); mode != 0x4 {
+		t.Fatalf("Expected byte mode, got %b", mode)
+	}
+
+	count := 8
+	if version >= 10 {
+		count = 16
+	}
+
+	result := make([]byte, read(count))
+	for i := range result {
+		result[i] = byte(read(8))
+	}
+	return result
+}
+
+func TestQRCode(t *testing.T) {
+	t.Run("Reed-Solomon", func(t *testing.T) {
+		// HELLO WORLD at 1-M, from the tutorial of Thonky
+		data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+		expected := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+
+		if ecc := reedSolomonRemainder(data, reedSolomonDivisor(10)); !bytes.Equal(ecc, expected) {
+			t.Errorf("Expected error correction %v, got %v", expected, ecc)
+		}
+	})
+
+	t.Run("Capacity", func(t *testing.T) {
+		for version := 1; version <= 40; version++ {
+			modules := (16*version+128)*version + 64
+			if version >= 2 {
+				count := version/7 + 2
+				modules -= (25*count-10)*count - 55
+				if version >= 7 {
+					modules -= 36
+				}
+			}
+
+			blocks := qrBlocks[version]
+			if total := dataCodewords(version) + blocks[0]*(blocks[1]+blocks[3]); total != modules/8 {
+				t.Errorf("Expected %v codewords in version %v, got %v", modules/8, version, total)
+			}
+		}
+	})
+
+	t.Run("Alignment positions", func(t *testing.T) {
+		expected := map[int][]int{
+			2:  {6, 18},
+			7:  {6, 22, 38},
+			10: {6, 28, 50},
+			32: {6, 34, 60, 86, 112, 138},
+			40: {6, 30, 58, 86, 114, 142, 170},
+		}
+
+		for version, positions := range expected {
+			if got := alignmentPositions(version); !reflect.DeepEqual(got, positions) {
+				t.Errorf("Expected positions %v in version %v, got %v", positions, version, got)
+			}
+		}
+	})
+
+	t.Run("Versions and masks", func(t *testing.T) {
+		for version := 1; version <= 10; version++ {
+			payload := payloadOf(capacities[version])
+
+			fitted, codewords, err := qrCodewords(payload)
+			if err != nil || fitted != version {
+				t.Fatalf("Expected %v bytes to fit version %v, got %v %v", len(payload), version, fitted, err)
+			}
+
+			if next, _, _ := qrCodewords(payloadOf(capacities[version] + 1)); next != version+1 {
+				t.Errorf("Expected %v bytes to fit version %v, got %v", capacities[version]+1, version+1, next)
+			}
+
+			for mask := 0; mask < 8; mask++ {
+				code := newQRCode(version)
+				code.placeData(interleave(version, codewords))
+				code.applyMask(mask)
+				code.drawFormat(mask)
+
+				if code.size != version*4+17 {
+					t.Fatalf("Expected size %v, got %v", version*4+17, code.size)
+				}
+
+				if first, second := readFormat(code); first != formatBits[mask] || second != formatBits[mask] {
+					t.Errorf("Expected format %015b in version %v, got %015b and %015b", formatBits[mask], version, first, second)
+				}
+
+				if expected, ok := versionBits[version]; ok {
+					if first, second := readVersion(code); first != expected || second != expected {
+						t.Errorf("Expected version information %018b, got %018b and %018b", expected, first, second)
+					}
+				}
+
+				if decoded := decode(t, code, version, mask); !bytes.Equal(decoded, payload) {
+					t.Errorf("Expected %q in version %v with mask %v, got %q", payload, version, mask, decoded)
+				}
+			}
+		}
+	})
+
+	t.Run("Lowest penalty", func(t *testing.T) {
+		payload := []byte(Payload(&Charge{
+			Key:   "pix@example.com",
+			Name:  "Testing Company",
+			City:  "São Paulo",
+			Value: 180,
+			TxID:  "SALE000001000000000000001",
+		}))
+
+		code, err := encodeQR(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		version := (code.size - 17) / 4
+		first, _ := readFormat(code)
+
+		mask := -1
+		for i, bits := range formatBits {
+			if bits == first {
+				mask = i
+			}
+		}
+
+		if mask < 0 {
+			t.Fatalf("Expected a format of level M, got %015b", first)
+		}
+
+		if decoded := decode(t, code, version, mask); !bytes.Equal(decoded, payload) {
+			t.Errorf("Expected %q, got %q", payload, decoded)
+		}
+
+		_, codewords, _ := qrCodewords(payload)
+		for other := 0; other < 8; other++ {
+			candidate := newQRCode(version)
+			candidate.placeData(interleave(version, codewords))
+			candidate.applyMask(other)
+			candidate.drawFormat(other)
+
+			if candidate.penalty() < code.penalty() {
+				t.Errorf("Expected mask %v to have the lowest penalty, got mask %v lower", mask, other)
+			}
+		}
+	})
+
+	t.Run("Too long", func(t *testing.T) {
+		if _, err := QRCode(strings.Repeat("0", 2332), 1); err != ErrPayloadTooLong {
+			t.Errorf("Expected error %v, got %v", ErrPayloadTooLong, err)
+		}
+	})
+}
@@ -0,0 +1,370 @@
+package sped
+
+import (
+	"fmt"
+	"time"
+
+	"example.com/accounting/models"
+	"example.com/accounting/nfe"
+)
+
+// ECDLayout is the version of the ECD layout the files follow.
+const ECDLayout = "9.00"
+
+// Ledger is what the ECD of a fiscal year is built from: the company's chart
+// of accounts, its balances when the year starts, what its result accounts
+// moved in the year before, and the entries of the year along their
+// transactions. Balances and movements are in the accounts' natural side.
+// Result accounts are closed at the end of each year into the equity account
+// of ResultAccountID.
+type Ledger struct {
+	Company         *models.Company
+	Start           time.Time
+	End             time.Time
+	Accounts        []*models.Account
+	Opening         map[uint]float64
+	Previous        map[uint]float64
+	Entries         []*models.Entry
+	ResultAccountID uint
+}
+
+// ECD writes the SPED Contábil of the ledger, as a G book (Diário Geral).
+func ECD(ledger *Ledger) []byte {
+	w := NewWriter()
+	company := ledger.Company
+	last := ledger.End.AddDate(0, 0, -1)
+
+	accounts := map[uint]*models.Account{}
+	children := map[uint][]*models.Account{}
+	for _, account := range ledger.Accounts {
+		accounts[account.ID] = account
+		if account.ParentID != nil {
+			children[*account.ParentID] = append(children[*account.ParentID], account)
+		}
+	}
+
+	code := func(account *models.Account) string {
+		if account.Code != "" {
+			return account.Code
+		}
+		return fmt.Sprint(account.ID)
+	}
+
+	level := func(account *models.Account) int {
+		level := 1
+		for account.ParentID != nil && accounts[*account.ParentID] != nil {
+			account = accounts[*account.ParentID]
+			level++
+		}
+		return level
+	}
+
+	parent := func(account *models.Account) string {
+		if account.ParentID == nil || accounts[*account.ParentID] == nil {
+			return ""
+		}
+		return code(accounts[*account.ParentID])
+	}
+
+	signed := func(balances map[uint]float64) map[uint]float64 {
+		debits := map[uint]float64{}
+		for id, value := range balances {
+			if account := accounts[id]; account != nil {
+				debits[id] = debit(account, value)
+			}
+		}
+		return debits
+	}
+
+	state := ""
+	cityCode := ""
+	if company.Address != nil {
+		state = company.Address.State
+		cityCode = company.Address.CityCode
+	}
+
+	w.Record("0000", "LECD", Date(ledger.Start), Date(last), company.Name, nfe.Digits(company.Cnpj),
+		state, nfe.Digits(company.StateRegistration), cityCode, company.MunicipalRegistration,
+		"", "0", "0", "0", "", "0", "0", "", "N", "N", "0", "0", referentialPlan(company))
+	w.Open("0", false)
+	w.Close("0")
+
+	// Balances of each account, signed as debits, at the start of the
+	// year and after each month. The results of the years before were
+	// closed into equity.
+	balances := signed(ledger.Opening)
+	for id, balance := range balances {
+		if isResult(accounts[id]) {
+			balances[ledger.ResultAccountID] += balance
+			balances[id] = 0
+		}
+	}
+	opening := copyBalances(balances)
+
+	// The result of the year is closed at its last day, after the entries
+	results := map[uint]float64{}
+	for _, entry := range ledger.Entries {
+		for _, transaction := range entry.Transactions {
+			if account := accounts[transaction.AccountID]; isResult(account) {
+				results[account.ID] += debit(account, transaction.Value)
+			}
+		}
+	}
+
+	closingEntry := &models.Entry{Description: "Encerramento do exercicio"}
+	closingEntry.CreatedAt = last
+
+	result := 0.0
+	for _, account := range ledger.Accounts {
+		if value := results[account.ID]; isResult(account) && !isZero(value) {
+			closingEntry.Transactions = append(closingEntry.Transactions, &models.Transaction{
+				Value:     debit(account, -value),
+				AccountID: account.ID,
+			})
+			result += value
+		}
+	}
+
+	entries := ledger.Entries
+	if equity := accounts[ledger.ResultAccountID]; equity != nil && len(closingEntry.Transactions) > 0 {
+		closingEntry.Transactions = append(closingEntry.Transactions, &models.Transaction{
+			Value:     debit(equity, result),
+			AccountID: equity.ID,
+		})
+		entries = append(entries[:len(entries):len(entries)], closingEntry)
+	}
+
+	w.Open("I", false)
+	w.Record("I010", "G", ECDLayout)
+	opening030 := w.Lines()
+	w.Record("I030", "TERMO DE ABERTURA", "1", "DIARIO GERAL", "", company.Name, "", nfe.Digits(company.Cnpj),
+		"", "", cityName(company), Date(last))
+
+	for _, account := range ledger.Accounts {
+		indicator := "A"
+		if len(children[account.ID]) > 0 {
+			indicator = "S"
+		}
+
+		w.Record("I050", Date(account.CreatedAt), nature(account), indicator, fmt.Sprint(level(account)),
+			code(account), parent(account), account.Name)
+
+		if indicator == "A" && account.ReferentialCode != "" {
+			w.Record("I051", "", account.ReferentialCode)
+		}
+	}
+
+	for month := ledger.Start; month.Before(ledger.End); month = month.AddDate(0, 1, 0) {
+		next := month.AddDate(0, 1, 0)
+
+		debits := map[uint]float64{}
+		credits := map[uint]float64{}
+		for _, entry := range entries {
+			if entry.CreatedAt.Before(month) || !entry.CreatedAt.Before(next) {
+				continue
+			}
+
+			for _, transaction := range entry.Transactions {
+				value := debit(accounts[transaction.AccountID], transaction.Value)
+				if value > 0 {
+					debits[transaction.AccountID] += value
+				} else {
+					credits[transaction.AccountID] -= value
+				}
+			}
+		}
+
+		w.Record("I150", Date(month), Date(next.AddDate(0, 0, -1)))
+
+		for _, account := range ledger.Accounts {
+			if len(children[account.ID]) > 0 {
+				continue
+			}
+
+			initial := balances[account.ID]
+			final := initial + debits[account.ID] - credits[account.ID]
+			balances[account.ID] = final
+
+			if isZero(initial) && isZero(debits[account.ID]) && isZero(credits[account.ID]) {
+				continue
+			}
+
+			w.Record("I155", code(account), "", Value(initial), side(initial),
+				Value(debits[account.ID]), Value(credits[account.ID]), Value(final), side(final))
+		}
+	}
+
+	for _, entry := range entries {
+		total := 0.0
+		for _, transaction := range entry.Transactions {
+			if value := debit(accounts[transaction.AccountID], transaction.Value); value > 0 {
+				total += value
+			}
+		}
+
+		if entry == closingEntry {
+			w.Record("I200", fmt.Sprintf("E%d", ledger.Start.Year()), Date(entry.CreatedAt), Value(total), "E", "")
+		} else {
+			w.Record("I200", fmt.Sprint(entry.ID), Date(entry.CreatedAt), Value(total), "N", "")
+		}
+
+		for _, transaction := range entry.Transactions {
+			account := accounts[transaction.AccountID]
+			value := debit(account, transaction.Value)
+
+			w.Record("I250", code(account), "", Value(value), side(value), "", "", entry.Description, "")
+		}
+	}
+
+	// Balances of the result accounts before they were closed
+	w.Record("I350", Date(last))
+	for _, account := range ledger.Accounts {
+		if value := results[account.ID]; isResult(account) && !isZero(value) {
+			w.Record("I355", code(account), "", Value(value), side(value))
+		}
+	}
+
+	w.Close("I")
+
+	// Demonstrations aggregate the balances up the tree
+	var total func(account *models.Account, balances map[uint]float64) float64
+	total = func(account *models.Account, balances map[uint]float64) float64 {
+		sum := balances[account.ID]
+		for _, child := range children[account.ID] {
+			sum += total(child, balances)
+		}
+		return sum
+	}
+
+	// The result is what the result accounts moved in the year, before
+	// being closed
+	closing := balances
+	current := results
+	previous := signed(ledger.Previous)
+
+	w.Open("J", false)
+	w.Record("J005", Date(ledger.Start), Date(last), "1", "")
+
+	for _, account := range ledger.Accounts {
+		if isResult(account) {
+			continue
+		}
+
+		group := "P"
+		if account.Type == models.Asset {
+			group = "A"
+		}
+
+		initial := total(account, opening)
+		final := total(account, closing)
+
+		w.Record("J100", code(account), aggregation(children[account.ID]), fmt.Sprint(level(account)),
+			parent(account), group, account.Name, Value(initial), side(initial), Value(final), side(final), "")
+	}
+
+	order := 0
+	for _, account := range ledger.Accounts {
+		if !isResult(account) {
+			continue
+		}
+
+		group := "D"
+		if account.Type == models.Revenue {
+			group = "R"
+		}
+
+		initial := total(account, previous)
+		final := total(account, current)
+
+		order++
+		w.Record("J150", fmt.Sprint(order), code(account), aggregation(children[account.ID]), fmt.Sprint(level(account)),
+			parent(account), account.Name, Value(initial), side(initial), Value(final), side(final), group, "")
+	}
+
+	closing900 := w.Lines()
+	w.Record("J900", "TERMO DE ENCERRAMENTO", "1", "DIARIO GERAL", company.Name, "", Date(ledger.Start), Date(last))
+	w.Close("J")
+
+	w.Finish()
+
+	lines := fmt.Sprint(w.Lines())
+	w.Set(opening030, 3, lines)
+	w.Set(closing900, 4, lines)
+
+	return w.Bytes()
+}
+
+// debit signs the value of a transaction or balance as a debit, positive,
+// or a credit, negative.
+func debit(account *models.Account, value float64) float64 {
+	if account.TransactionType() == models.Credit {
+		return -value
+	}
+	return value
+}
+
+func side(value float64) string {
+	if value < 0 {
+		return "C"
+	}
+	return "D"
+}
+
+func isZero(value float64) bool {
+	return Value(value) == "0,00"
+}
+
+func isResult(account *models.Account) bool {
+	return account != nil && (account.Type == models.Revenue || account.Type == models.Expense)
+}
+
+// nature is the group of the account in the ECD: assets, liabilities,
+// equity, or results.
+func nature(account *models.Account) string {
+	switch account.Type {
+	case models.Asset:
+		return "01"
+	case models.Liability:
+		return "02"
+	case models.Equity, models.Dividend:
+		return "03"
+	default:
+		return "04"
+	}
+}
+
+// aggregation tells totals, with lines under them, from details.
+func aggregation(children []*models.Account) string {
+	if len(children) > 0 {
+		return "T"
+	}
+	return "D"
+}
+
+func cityName(company *models.Company) string {
+	if company.Address == nil {
+		return ""
+	}
+	return company.Address.City
+}
+
+func copyBalances(balances map[uint]float64) map[uint]float64 {
+	copied := map[uint]float64{}
+	for id, value := range balances {
+		copied[id] = value
+	}
+	return copied
+}
+
+// referentialPlan is the referential chart of accounts of the company's
+// regime, which Simples Nacional companies aren't required to map to.
+func referentialPlan(company *models.Company) string {
+	switch company.Regime {
+	case models.NonCumulativeRegime:
+		return "1"
+	case models.CumulativeRegime:
+		return "2"
+	default:
+		return ""
+	}
+}
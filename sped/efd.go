@@ -0,0 +1,128 @@
+package sped
+
+import (
+	"fmt"
+	"time"
+
+	"example.com/accounting/models"
+	"example.com/accounting/nfe"
+)
+
+// EFDLayout is the version of the EFD ICMS/IPI layout the files follow.
+const EFDLayout = "019"
+
+// Inventory is what the inventory of the EFD ICMS/IPI is built from: the
+// period of the file, the date of the inventory, and the products along
+// their stock entries and usages up to that date and their inventory
+// accounts.
+type Inventory struct {
+	Company  *models.Company
+	Start    time.Time
+	End      time.Time
+	Date     time.Time
+	Products []*models.Product
+}
+
+// EFDInventory writes the EFD ICMS/IPI of the period declaring the
+// inventory in block H, along the registers of its products and units in
+// block 0. Products out of stock are left out, and the other blocks go
+// without data.
+func EFDInventory(inventory *Inventory) []byte {
+	w := NewWriter()
+	company := inventory.Company
+	last := inventory.End.AddDate(0, 0, -1)
+
+	products := []*models.Product{}
+	for _, product := range inventory.Products {
+		if product.Inventory() > 0 {
+			products = append(products, product)
+		}
+	}
+
+	address := company.Address
+	if address == nil {
+		address = &models.Address{}
+	}
+
+	w.Record("0000", EFDLayout, "0", Date(inventory.Start), Date(last), company.Name, nfe.Digits(company.Cnpj), "",
+		address.State, nfe.Digits(company.StateRegistration), address.CityCode, company.MunicipalRegistration, "", "A", "1")
+	w.Open("0", false)
+	w.Record("0005", company.Name, nfe.Digits(address.Postcode), address.Street, address.Number, "",
+		address.Neighborhood, "", "", "")
+
+	units := []string{}
+	seen := map[string]bool{}
+	for _, product := range products {
+		if unit := stockUnit(product); !seen[unit] {
+			seen[unit] = true
+			units = append(units, unit)
+		}
+	}
+
+	for _, unit := range units {
+		w.Record("0190", unit, unit)
+	}
+
+	for _, product := range products {
+		w.Record("0200", itemCode(product), product.Name, product.GTIN, "", stockUnit(product), "00",
+			nfe.Digits(product.NCM), "", "", "", "", "")
+	}
+
+	w.Close("0")
+
+	for _, block := range []string{"B", "C", "D", "E", "G"} {
+		w.Open(block, true)
+		w.Close(block)
+	}
+
+	total := 0.0
+	for _, product := range products {
+		total += product.InventoryValue()
+	}
+
+	w.Open("H", false)
+
+	// Inventories at the end of the period, as the one of the year
+	w.Record("H005", Date(inventory.Date), Value(total), "01")
+
+	for _, product := range products {
+		qty := product.Inventory()
+		value := product.InventoryValue()
+
+		account := ""
+		if product.InventoryAccount != nil {
+			account = product.InventoryAccount.Code
+			if account == "" {
+				account = fmt.Sprint(product.InventoryAccount.ID)
+			}
+		}
+
+		w.Record("H010", itemCode(product), stockUnit(product), Decimal(qty, 3), Decimal(value/qty, 6), Value(value),
+			"0", "", "", account, Value(value))
+	}
+
+	w.Close("H")
+
+	for _, block := range []string{"K", "1"} {
+		w.Open(block, true)
+		w.Close(block)
+	}
+
+	w.Finish()
+	return w.Bytes()
+}
+
+// itemCode is the code the product goes by in the file.
+func itemCode(product *models.Product) string {
+	if product.SKU != "" {
+		return product.SKU
+	}
+	return fmt.Sprint(product.ID)
+}
+
+func stockUnit(product *models.Product) string {
+	if product.StockUnit != "" {
+		return product.StockUnit
+	}
+	return "UN"
+}
@@ -0,0 +1,116 @@
+// Package sped writes the text files of the SPED, the digital bookkeeping
+// the Receita Federal receives: records of pipe-delimited fields, grouped
+// in blocks that close with their line counts.
+package sped
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Writer collects the records of a file, counting them by block and type
+// for the closing records.
+type Writer struct {
+	lines  []string
+	blocks map[string]int
+	counts map[string]int
+	order  []string
+}
+
+func NewWriter() *Writer {
+	return &Writer{
+		blocks: map[string]int{},
+		counts: map[string]int{},
+	}
+}
+
+// Record writes a record of the given type and fields.
+func (w *Writer) Record(kind string, fields ...string) {
+	for i, field := range fields {
+		fields[i] = strings.ReplaceAll(field, "|", " ")
+	}
+
+	w.lines = append(w.lines, "|"+kind+"|"+strings.Join(fields, "|")+"|")
+	w.blocks[kind[:1]]++
+
+	if _, ok := w.counts[kind]; !ok {
+		w.order = append(w.order, kind)
+	}
+	w.counts[kind]++
+}
+
+// Open writes the opening record of the block, telling whether it has data.
+func (w *Writer) Open(block string, empty bool) {
+	indicator := "0"
+	if empty {
+		indicator = "1"
+	}
+	w.Record(block+"001", indicator)
+}
+
+// Close writes the closing record of the block with its line count.
+func (w *Writer) Close(block string) {
+	w.Record(block+"990", fmt.Sprint(w.blocks[block]+1))
+}
+
+// Lines is the number of lines written so far.
+func (w *Writer) Lines() int {
+	return len(w.lines)
+}
+
+// Set replaces a field, counted from 0, of a line already written, for
+// counts only known at the end of the file.
+func (w *Writer) Set(line int, field int, value string) {
+	fields := strings.Split(w.lines[line], "|")
+	fields[field+2] = value
+	w.lines[line] = strings.Join(fields, "|")
+}
+
+// Finish writes block 9, counting the records of each type and the lines of
+// the file.
+func (w *Writer) Finish() {
+	w.Open("9", false)
+
+	kinds := append([]string{}, w.order...)
+	kinds = append(kinds, "9900", "9990", "9999")
+
+	for _, kind := range kinds {
+		count := w.counts[kind]
+		switch kind {
+		case "9900":
+			count = len(kinds)
+		case "9990", "9999":
+			count = 1
+		}
+		w.Record("9900", kind, fmt.Sprint(count))
+	}
+
+	// 9990 counts the lines of block 9 including itself and 9999
+	w.Record("9990", fmt.Sprint(w.blocks["9"]+2))
+	w.Record("9999", fmt.Sprint(len(w.lines)+1))
+}
+
+// Bytes renders the file, one record per line.
+func (w *Writer) Bytes() []byte {
+	return []byte(strings.Join(w.lines, "\r\n") + "\r\n")
+}
+
+// Date formats dates as DDMMYYYY.
+func Date(date time.Time) string {
+	return date.Format("02012006")
+}
+
+// Value formats values with two decimals and a decimal comma. Values are
+// unsigned, records tell debits from credits in a field of their own.
+func Value(value float64) string {
+	return Decimal(value, 2)
+}
+
+// Decimal formats values with the given decimals and a decimal comma.
+func Decimal(value float64, decimals int) string {
+	scale := math.Pow(10, float64(decimals))
+	value = math.Round(value*scale) / scale
+	return strings.Replace(fmt.Sprintf("%.*f", decimals, math.Abs(value)), ".", ",", 1)
+}